@@ -10,7 +10,7 @@ import Json.Decode as Decode
 import Ports
 import Routes
 import Util exposing (AsyncResource(..), errorToString, optionalList)
-import VideoRepository exposing (Video, VideoInfo, VideoStatus(..), VideoUpdatePayload, updateVideo)
+import VideoRepository exposing (Video, VideoInfo, VideoStatus(..), VideoUpdatePayload, saveProgress, updateVideo)
 
 
 type alias FormState =
@@ -25,8 +25,10 @@ type alias Model =
     { video : AsyncResource VideoInfo String
     , formState : FormState
     , videoUpdate : AsyncResource () String
+    , savedPosition : Float
     , key : Navigation.Key
     , changesUrl : Bool
+    , fetchVideo : (Result Http.Error VideoInfo -> Msg) -> Cmd Msg
     , fetchNextVideo : Video -> ((Result Http.Error VideoInfo -> Msg) -> Cmd Msg)
     }
 
@@ -41,6 +43,18 @@ type Msg
     | TogglePlayPause
     | VolumeChanged Float
     | ToggleFullscreen
+    | Refresh
+    | GotRefreshedInfo (Result Http.Error VideoInfo)
+    | MetadataLoaded
+    | TimeUpdated Float Float
+    | Paused Float Float
+    | SavedProgress (Result Http.Error ())
+
+
+-- how far the playback moves before its position is saved again, in seconds
+progressInterval : Float
+progressInterval =
+    10
 
 
 init : Navigation.Key -> Bool -> ((Result Http.Error VideoInfo -> Msg) -> Cmd Msg) -> (Video -> ((Result Http.Error VideoInfo -> Msg) -> Cmd Msg)) -> ( Model, Cmd Msg )
@@ -53,8 +67,10 @@ init key changesUrl getter fetchNext =
             , volume = 1.0
             }
       , videoUpdate = Idle
+      , savedPosition = 0
       , key = key
       , changesUrl = changesUrl
+      , fetchVideo = getter
       , fetchNextVideo = fetchNext
       }
     , getter GotVideoInfo
@@ -175,6 +191,82 @@ update msg model =
         ( ToggleFullscreen, Success _ ) ->
             ( model, Ports.toggleFullscreen "video" )
 
+        -- fetching the next video of the one on screen fetches what the page
+        -- shows now: the same video, or the head of the queue
+        ( Refresh, Success videoInfo ) ->
+            case model.videoUpdate of
+                -- the save fetches the next video by itself
+                Loading ->
+                    ( model, Cmd.none )
+
+                _ ->
+                    ( model, model.fetchNextVideo videoInfo.video GotRefreshedInfo )
+
+        -- e.g. the queue was empty and a video was imported
+        ( Refresh, Failure _ ) ->
+            ( { model | video = ReFetching model.video }, model.fetchVideo GotVideoInfo )
+
+        ( Refresh, _ ) ->
+            ( model, Cmd.none )
+
+        ( GotRefreshedInfo (Ok videoInfo), Success current ) ->
+            ( { model
+                | video = Success videoInfo
+                , formState =
+                    -- keep what is being typed unless the video changed
+                    if videoInfo.video == current.video then
+                        formState
+
+                    else
+                        newFormState videoInfo.video
+              }
+            , Cmd.none
+            )
+
+        ( GotRefreshedInfo (Err err), Success _ ) ->
+            ( { model | video = Failure (errorToString err) }, Cmd.none )
+
+        -- a save replaced the video while the refresh was running
+        ( GotRefreshedInfo _, _ ) ->
+            ( model, Cmd.none )
+
+        -- resume where the video was left
+        ( MetadataLoaded, Success videoInfo ) ->
+            case videoInfo.progress of
+                Just progress ->
+                    ( { model | savedPosition = progress.position }, Ports.seek "video" progress.position )
+
+                Nothing ->
+                    ( { model | savedPosition = 0 }, Cmd.none )
+
+        ( TimeUpdated position duration, Success videoInfo ) ->
+            if abs (position - model.savedPosition) >= progressInterval then
+                storeProgress model videoInfo.video position duration
+
+            else
+                ( model, Cmd.none )
+
+        ( Paused position duration, Success videoInfo ) ->
+            if position /= model.savedPosition then
+                storeProgress model videoInfo.video position duration
+
+            else
+                ( model, Cmd.none )
+
+        -- the player events of a video being replaced
+        ( MetadataLoaded, _ ) ->
+            ( model, Cmd.none )
+
+        ( TimeUpdated _ _, _ ) ->
+            ( model, Cmd.none )
+
+        ( Paused _ _, _ ) ->
+            ( model, Cmd.none )
+
+        -- losing a position only resumes the video a bit earlier
+        ( SavedProgress _, _ ) ->
+            ( model, Cmd.none )
+
         ( _, _ ) ->
             let
                 invalidStateLog _ =
@@ -183,6 +275,19 @@ update msg model =
             invalidStateLog <| Debug.log "UNHANDLED STATE" ( msg, model.video )
 
 
+storeProgress : Model -> Video -> Float -> Float -> ( Model, Cmd Msg )
+storeProgress model video position duration =
+    -- the position goes back to 0 while another video loads, and the server
+    -- refuses a position without a known duration
+    if position > 0 && position <= duration && not (isInfinite duration) then
+        ( { model | savedPosition = position }
+        , saveProgress video.id { position = position, duration = duration } SavedProgress
+        )
+
+    else
+        ( model, Cmd.none )
+
+
 view : Model -> Html Msg
 view model =
     let
@@ -271,6 +376,11 @@ videoView formState video =
                             Decode.fail "no message"
                     )
                 |> Decode.map (\msg -> { message = msg, stopPropagation = True, preventDefault = True })
+
+        playbackDecoder toMsg =
+            Decode.map2 toMsg
+                (Decode.at [ "target", "currentTime" ] Decode.float)
+                (Decode.at [ "target", "duration" ] Decode.float)
     in
     div []
         [ div [ class "flex justify-center my-8" ]
@@ -285,6 +395,9 @@ videoView formState video =
                 , controls True
                 , custom "wheel" volumeEventDecoder
                 , custom "keydown" fullscreenEventDecoder
+                , on "loadedmetadata" (Decode.succeed MetadataLoaded)
+                , on "timeupdate" (playbackDecoder TimeUpdated)
+                , on "pause" (playbackDecoder Paused)
                 ]
                 []
             ]
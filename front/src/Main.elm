@@ -12,6 +12,7 @@ import Pages.Home
 import Pages.NextVideo
 import Pages.SavedList
 import Pages.WatchVideo
+import Ports
 import Routes exposing (routeFromUrl)
 import Svg exposing (circle, svg)
 import Svg.Attributes exposing (cx, cy, d, fill, r, stroke, strokeDasharray, strokeLinecap, strokeLinejoin, strokeWidth, viewBox)
@@ -47,6 +48,8 @@ type Msg
     | SavedListMsg Pages.SavedList.Msg
     | GotLastUpdate (Result Http.Error (Maybe Time.Posix))
     | RunUpdate
+    | ScanStarted (Result Http.Error ())
+    | GotServerEvent String
 
 
 main : Program () Model Msg
@@ -107,11 +110,14 @@ fetchLastUpdate =
     Http.get { url = "/api/last-update", expect = Http.expectJson GotLastUpdate maybeTimeDecoder }
 
 
+{-| The scan runs in the background, the last update is fetched again once the
+server announces that it finished.
+-}
 sendRunUpdate : Cmd Msg
 sendRunUpdate =
     Http.post
         { url = "/api/video/scan"
-        , expect = Http.expectJson GotLastUpdate maybeTimeDecoder
+        , expect = Http.expectWhatever ScanStarted
         , body = Http.emptyBody
         }
 
@@ -176,6 +182,39 @@ update msg model =
             , sendRunUpdate
             )
 
+        ( _, ScanStarted res ) ->
+            case res of
+                Ok () ->
+                    ( model, Cmd.none )
+
+                Err err ->
+                    ( { model | lastUpdate = Failure <| Util.errorToString err }, Cmd.none )
+
+        ( _, GotServerEvent kind ) ->
+            if List.member kind [ "scan_finished", "video_updated", "video_imported" ] then
+                let
+                    ( newModel, pageCmd ) =
+                        case model.pageModel of
+                            HomePage _ ->
+                                update (HomeMsg Pages.Home.RefreshStats) model
+
+                            NextVideoPage _ ->
+                                update (NextVideoMsg Pages.NextVideo.Refresh) model
+
+                            WatchVideoPage _ ->
+                                update (WatchVideoMsg Pages.WatchVideo.Refresh) model
+
+                            SavedListPage _ ->
+                                update (SavedListMsg Pages.SavedList.Refresh) model
+
+                            ErrorPage ->
+                                ( model, Cmd.none )
+                in
+                ( newModel, Cmd.batch [ pageCmd, fetchLastUpdate ] )
+
+            else
+                ( model, Cmd.none )
+
         ( HomePage homeModel, HomeMsg homeMsg ) ->
             let
                 ( newModel, homeCmd ) =
@@ -217,7 +256,7 @@ update msg model =
 
 subscriptions : Model -> Sub Msg
 subscriptions _ =
-    Sub.none
+    Ports.serverEvent GotServerEvent
 
 
 view : Model -> Browser.Document Msg
@@ -30,6 +30,7 @@ type alias Model =
 type Msg
     = GotStats (Result Http.Error VideosInfo)
     | FetchStats
+    | RefreshStats
 
 
 videoStatsDecoder : Decoder VideosInfo
@@ -69,6 +70,24 @@ update msg model =
             , Cmd.none
             )
 
+        ( GotStats (Ok stats), ReFetching _ ) ->
+            ( { stats = Success stats }
+            , Cmd.none
+            )
+
+        ( GotStats (Err httpErr), ReFetching _ ) ->
+            ( { stats = Failure (errorToString httpErr) }
+            , Cmd.none
+            )
+
+        ( RefreshStats, Success _ ) ->
+            ( { stats = ReFetching model.stats }
+            , Http.get
+                { url = videoStatsUrl
+                , expect = Http.expectJson GotStats videoStatsDecoder
+                }
+            )
+
         ( FetchStats, Failure _ ) ->
             ( { stats = Loading }
             , Http.get
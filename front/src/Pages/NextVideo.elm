@@ -11,6 +11,7 @@ import Browser.Navigation as Navigation
 type alias Model = VideoViewer.Model
 type Msg =
     VideoViewerMsg VideoViewer.Msg
+    | Refresh
 
 
 init : Navigation.Key -> ( Model, Cmd Msg )
@@ -30,6 +31,9 @@ update msg model =
             in
             (newModel, Cmd.map VideoViewerMsg cmd)
 
+        Refresh ->
+            update (VideoViewerMsg VideoViewer.Refresh) model
+
 
 view : Model -> Browser.Document Msg
 view model =
@@ -1,28 +1,23 @@
 module Pages.SavedList exposing (..)
 
 import Browser
-import Html exposing (Html, button, div, h1, li, ol, span, text)
-import Html.Attributes exposing (class, disabled, href, start, title)
+import Html exposing (Html, button, div, h1, img, li, ol, span, text)
+import Html.Attributes exposing (alt, attribute, class, disabled, href, src, start, title)
 import Html.Events exposing (onClick)
 import Http
 import Ports exposing (updateQueryParams)
-import Util exposing (AsyncResource(..), listSlice, optionalList)
-import VideoRepository exposing (Video, listSavedVideos)
+import Util exposing (AsyncResource(..), errorToString, optionalList)
+import VideoRepository exposing (Video, VideoPage, listSavedVideos)
 
 
-type alias VideosPage =
+type alias SuccModel =
     { videos : List Video
     , page : Int
+    , pageSize : Int
     , maxPages : Int
     }
 
 
-type alias SuccModel =
-    { videos : List Video
-    , page : VideosPage
-    }
-
-
 type alias Model =
     { queryPageNum : Int
     , asyncModel : AsyncResource SuccModel String
@@ -30,56 +25,79 @@ type alias Model =
 
 
 type Msg
-    = GotVideoList (Result Http.Error (List Video))
+    = GotVideoList (Result Http.Error VideoPage)
     | ToPage Int
+    | Refresh
 
 
 init : Maybe Int -> ( Model, Cmd Msg )
 init page =
-    ( { queryPageNum = Maybe.withDefault 1 page
+    let
+        queryPageNum =
+            Basics.max 1 <| Maybe.withDefault 1 page
+    in
+    ( { queryPageNum = queryPageNum
       , asyncModel = Loading
       }
-    , listSavedVideos GotVideoList
+    , listSavedVideos queryPageNum GotVideoList
     )
 
 
 update : Msg -> Model -> ( Model, Cmd Msg )
 update msg mdl =
     let
-        createPage : List Video -> Int -> VideosPage
-        createPage videos page =
-            if List.length videos <= 50 then
-                { videos = videos, page = 1, maxPages = 1 }
-
-            else
-                { videos = listSlice ((page - 1) * 50) 50 videos
-                , page = page
-                , maxPages = (List.length videos + 49) // 50
-                }
-
-        actualPage : Int -> Int -> Int
-        actualPage page maxPages =
-            Basics.min maxPages <| Basics.max 1 page
+        createPage : VideoPage -> SuccModel
+        createPage videoPage =
+            { videos = videoPage.videos
+            , page = videoPage.page
+            , pageSize = videoPage.pageSize
+            , maxPages = Basics.max 1 <| (videoPage.total + videoPage.pageSize - 1) // videoPage.pageSize
+            }
 
         ( newModel, cmd ) =
             case ( mdl.asyncModel, msg ) of
-                ( Loading, GotVideoList (Ok videos) ) ->
-                    ( Success
-                        { videos = videos
-                        , page = createPage videos <| actualPage mdl.queryPageNum (List.length videos)
-                        }
-                    , Cmd.none
-                    )
+                ( _, GotVideoList (Ok videoPage) ) ->
+                    let
+                        page =
+                            createPage videoPage
+                    in
+                    -- the requested page went past the end of the list
+                    if page.page > page.maxPages then
+                        ( ReFetching (Success page)
+                        , Cmd.batch
+                            [ listSavedVideos page.maxPages GotVideoList
+                            , updateQueryParams [ ( "page", String.fromInt page.maxPages ) ]
+                            ]
+                        )
+
+                    else
+                        ( Success page, Cmd.none )
+
+                ( _, GotVideoList (Err err) ) ->
+                    ( Failure <| errorToString err, Cmd.none )
 
                 ( Success model, ToPage page ) ->
-                    ( Success
-                        { videos = model.videos
-                        , page = createPage model.videos <| actualPage page (List.length model.videos)
-                        }
-                    , updateQueryParams
-                        [ ( "page", String.fromInt (actualPage page (List.length model.videos)) ) ]
+                    let
+                        actualPage =
+                            Basics.min model.maxPages <| Basics.max 1 page
+                    in
+                    ( ReFetching (Success model)
+                    , Cmd.batch
+                        [ listSavedVideos actualPage GotVideoList
+                        , updateQueryParams [ ( "page", String.fromInt actualPage ) ]
+                        ]
                     )
 
+                -- the list changed on the server, the page is fetched again
+                ( Success model, Refresh ) ->
+                    ( ReFetching (Success model), listSavedVideos model.page GotVideoList )
+
+                ( Failure _, Refresh ) ->
+                    ( ReFetching mdl.asyncModel, listSavedVideos mdl.queryPageNum GotVideoList )
+
+                ( _, Refresh ) ->
+                    ( mdl.asyncModel, Cmd.none )
+
                 ( _, _ ) ->
                     let
                         invalidStateLog _ =
@@ -151,10 +169,10 @@ viewSuccess model =
     [ div [ class "mx-auto max-w-lg shadow-md p-6 rounded-lg" ]
         [ ol
             [ class "space-y-2 list-decimal list-inside"
-            , start ((model.page.page - 1) * 50)
+            , start ((model.page - 1) * model.pageSize + 1)
             ]
           <|
-            List.map videoListItem model.page.videos
+            List.map videoListItem model.videos
         ]
     , paginationControls model
     ]
@@ -180,7 +198,15 @@ videoListItem video =
             , class "text-blue-600 hover:text-blue-800 hover:underline"
             , title tooltip
             ]
-            [ text linkText ]
+            [ img
+                [ src ("/api/video/" ++ String.fromInt video.id ++ "/thumbnail")
+                , alt ""
+                , attribute "loading" "lazy"
+                , class "inline-block w-32 aspect-video object-cover rounded mr-2 align-middle"
+                ]
+                []
+            , text linkText
+            ]
         ]
 
 
@@ -188,10 +214,10 @@ paginationControls : SuccModel -> Html Msg
 paginationControls model =
     let
         currentPage =
-            model.page.page
+            model.page
 
         maxPages =
-            model.page.maxPages
+            model.maxPages
 
         manyItemsPlaceholder =
             span [ class "font-bold py-2" ] [ text "..." ]
@@ -200,14 +226,14 @@ paginationControls model =
         pageButton page =
             button
                 [ class
-                    (if page == model.page.page then
+                    (if page == model.page then
                         "bg-blue-700 text-white font-bold py-2 px-4 rounded"
 
                      else
                         "bg-blue-500 hover:bg-blue-700 text-white font-bold py-2 px-4 rounded"
                     )
                 , onClick (ToPage page)
-                , disabled (page == model.page.page)
+                , disabled (page == model.page)
                 ]
                 [ text (String.fromInt page) ]
     in
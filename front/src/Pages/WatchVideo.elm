@@ -15,6 +15,7 @@ type alias Model =
 
 type Msg
     = VideoViewerMsg VideoViewer.Msg
+    | Refresh
 
 
 fetchNext : Video -> (Result Http.Error VideoInfo -> msg) -> Cmd msg
@@ -43,6 +44,9 @@ update msg model =
             in
             ( {model | viewerModel = newModel }, Cmd.map VideoViewerMsg cmd )
 
+        Refresh ->
+            update (VideoViewerMsg VideoViewer.Refresh) model
+
 
 view : Model -> Browser.Document Msg
 view model =
@@ -1,4 +1,4 @@
-port module Ports exposing (setVolume, toggleFullscreen, togglePlayPause, updateQueryParams)
+port module Ports exposing (seek, serverEvent, setVolume, toggleFullscreen, togglePlayPause, updateQueryParams)
 
 import Json.Encode as Encode
 
@@ -12,9 +12,15 @@ port setVolumePort : Encode.Value -> Cmd msg
 port toggleFullscreenPort : String -> Cmd msg
 
 
+port seekPort : Encode.Value -> Cmd msg
+
+
 port updateQueryParamsPort : Encode.Value -> Cmd msg
 
 
+port serverEventPort : (String -> msg) -> Sub msg
+
+
 encodePayload : String -> Float -> Encode.Value
 encodePayload id volume =
     Encode.object
@@ -38,8 +44,22 @@ toggleFullscreen id =
     toggleFullscreenPort id
 
 
+seek : String -> Float -> Cmd msg
+seek id time =
+    seekPort <|
+        Encode.object
+            [ ( "id", Encode.string id )
+            , ( "time", Encode.float time )
+            ]
+
+
 updateQueryParams : List ( String, String ) -> Cmd msg
 updateQueryParams params =
     List.map (\( k, v ) -> ( k, Encode.string v )) params
         |> Encode.object
         |> updateQueryParamsPort
+
+
+serverEvent : (String -> msg) -> Sub msg
+serverEvent toMsg =
+    serverEventPort toMsg
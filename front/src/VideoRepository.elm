@@ -32,8 +32,23 @@ type alias VideoUpdatePayload =
     }
 
 
+type alias VideoPage =
+    { videos : List Video
+    , total : Int
+    , page : Int
+    , pageSize : Int
+    }
+
+
+type alias Progress =
+    { position : Float
+    , duration : Float
+    }
+
+
 type alias VideoInfo =
     { video : Video
+    , progress : Maybe Progress
     , next : Maybe Video
     }
 
@@ -72,11 +87,29 @@ updateVideo id video msg =
         }
 
 
-listSavedVideos : (Result Http.Error (List Video) -> msg) -> Cmd msg
-listSavedVideos msg =
+saveProgress : Int -> Progress -> (Result Http.Error () -> msg) -> Cmd msg
+saveProgress id progress msg =
+    Http.request
+        { method = "PUT"
+        , headers = []
+        , url = "/api/video/" ++ String.fromInt id ++ "/progress"
+        , body =
+            Http.jsonBody <|
+                Encode.object
+                    [ ( "position", Encode.float progress.position )
+                    , ( "duration", Encode.float progress.duration )
+                    ]
+        , expect = Http.expectWhatever msg
+        , timeout = Nothing
+        , tracker = Nothing
+        }
+
+
+listSavedVideos : Int -> (Result Http.Error VideoPage -> msg) -> Cmd msg
+listSavedVideos page msg =
     Http.get
-        { url = "/api/video/list"
-        , expect = Http.expectJson msg videoListDecoder
+        { url = "/api/video/list?page=" ++ String.fromInt page
+        , expect = Http.expectJson msg videoPageDecoder
         }
 
 
@@ -136,8 +169,25 @@ videoListDecoder =
         Decode.list videoDecoder
 
 
+videoPageDecoder : Decode.Decoder VideoPage
+videoPageDecoder =
+    Decode.map4 VideoPage
+        videoListDecoder
+        (Decode.field "total" Decode.int)
+        (Decode.field "page" Decode.int)
+        (Decode.field "page_size" Decode.int)
+
+
+progressDecoder : Decode.Decoder Progress
+progressDecoder =
+    Decode.map2 Progress
+        (Decode.field "position" Decode.float)
+        (Decode.field "duration" Decode.float)
+
+
 videoInfoDecoder : Decode.Decoder VideoInfo
 videoInfoDecoder =
-    Decode.map2 VideoInfo
+    Decode.map3 VideoInfo
         (Decode.field "video" videoDecoder)
+        (Decode.field "progress" (Decode.nullable progressDecoder))
         (Decode.field "next" (Decode.nullable videoDecoder))
@@ -1,16 +1,26 @@
 package internals
 
 import (
+	"context"
 	"go-video-viewer/cmd_args"
 	"log"
-	"os"
-	"path"
+	"sync"
 	"time"
 )
 
 type App struct {
-	Config Config
-	Repo   VideoRepository
+	Config    Config
+	Repo      VideoRepository
+	Events    *EventHub
+	Jobs      *JobManager
+	disposals *sync.Mutex
+	scans     *sync.Mutex
+	// extractions keeps a single pass over each video file, reading its
+	// subtitles or indexing its remux
+	extractions *videoLocks
+	// thumbnails keeps a single thumbnail of each video being generated
+	thumbnails *videoLocks
+	transcodes *transcoder
 }
 
 func NewApp() App {
@@ -24,7 +34,17 @@ func NewApp() App {
 		log.Fatalln("Failed to initialize repository", err)
 	}
 
-	return App{Config: config, Repo: repo}
+	return App{
+		Config:      config,
+		Repo:        repo,
+		Events:      NewEventHub(),
+		Jobs:        NewJobManager(),
+		disposals:   &sync.Mutex{},
+		scans:       &sync.Mutex{},
+		extractions: newVideoLocks(),
+		thumbnails:  newVideoLocks(),
+		transcodes:  newTranscoder(),
+	}
 }
 
 func (app App) Close() {
@@ -37,40 +57,102 @@ func (app App) Init() {
 		return
 	}
 
-	log.Println("importing json file...")
+	// the json file comes from the single folder version of the project
+	library := app.Repo.Libraries()[0]
+	log.Printf("importing json file into library \"%v\"...", library.Name)
 
-	err := app.Repo.ImportJsonFile(args.JsonFile)
+	err := app.Repo.ImportJsonFile(library.Id, args.JsonFile)
 	if err != nil {
 		log.Fatalln("Failed to read json file", err)
 	}
 }
 
 func (app App) UpdateVideo(video Video) error {
-	err := app.Repo.Update(video)
+	return app.updateVideo(video, 0)
+}
+
+// updateVideo saves the video, undoes is the id of the status event it
+// reverts, zero for a regular change.
+func (app App) updateVideo(video Video, undoes int64) error {
+	previous, err := app.Repo.FindById(video.Id)
 	if err != nil {
 		return err
 	}
 
-	if !video.Status.PersistFile() {
-		return os.Truncate(app.VideoPath(video), 0)
+	err = app.Repo.update(video, undoes)
+	if err != nil {
+		return err
+	}
+	app.publishVideoUpdate(video, previous)
+
+	// the playback position is only useful while the video is in the queue,
+	// undoing the change doesn't bring it back
+	if previous != nil && previous.Status == VideoUnwatched && video.Status != VideoUnwatched {
+		if err = app.Repo.DeleteProgress(video.Id); err != nil {
+			return err
+		}
+	}
+
+	if app.Config.DisposalFor(video.Status) != DisposalKeep {
+		return app.scheduleDisposal(video)
 	}
 
-	return nil
+	_, err = app.cancelDisposal(video.Id)
+	return err
 }
 
-func (app App) UpdateRepoFromFolder() error {
-	entries, err := app.Repo.ListDirVideos()
-	if err != nil {
-		return err
-	}
+// scanJobKey is shared by every scan, they write to the same tables so only
+// one runs at a time.
+const scanJobKey = "scan"
+
+// StartScan scans the folder of the library in the background, or of every
+// library when it is nil. If a scan is running, its job is returned instead.
+func (app App) StartScan(library *Library) (Job, bool) {
+	return app.Jobs.Start("scan", scanJobKey, func(ctx context.Context, progress func(any)) (any, error) {
+		var run ScanRun
+		var err error
+		if library == nil {
+			run, err = app.UpdateRepoFromFolder(ctx, progress)
+		} else {
+			run, err = app.UpdateLibraryFromFolder(ctx, *library, progress)
+		}
+
+		if err != nil {
+			return ScanResponse{Run: run}, err
+		}
+
+		if app.Config.VerifyChecksums {
+			app.StartVerification()
+		}
+		app.StartThumbnails()
+		app.StartRemuxIndexing()
+
+		date, err := app.LastFolderUpdate()
+		return ScanResponse{LastUpdate: date, Run: run}, err
+	})
+}
+
+// UpdateRepoFromFolder scans the folder of every library, the progress is
+// reported after each library.
+func (app App) UpdateRepoFromFolder(ctx context.Context, progress func(any)) (ScanRun, error) {
+	return app.scanLibraries(ctx, app.Repo.Libraries(), 0, progress)
+}
 
-	return app.Repo.ImportFsEntries(entries)
+func (app App) UpdateLibraryFromFolder(ctx context.Context, library Library, progress func(any)) (ScanRun, error) {
+	return app.scanLibraries(ctx, []Library{library}, library.Id, progress)
 }
 
 func (app App) LastFolderUpdate() (*time.Time, error) {
 	return app.Repo.LastFolderUpdate()
 }
 
-func (app App) VideoPath(video Video) string {
-	return path.Join(app.Config.VideoFolder, video.Filename)
+// VideoPath returns the path of the video file, failing when its filename
+// points outside of the video folder.
+func (app App) VideoPath(video Video) (string, error) {
+	library, err := app.Repo.FindLibrary(video.LibraryId)
+	if err != nil {
+		return "", err
+	}
+
+	return resolveInFolder(library.VideoFolder, video.Filename)
 }
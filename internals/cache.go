@@ -0,0 +1,110 @@
+package internals
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// videoLocks keeps a single worker on the cache of each video, while
+// different videos are handled at the same time.
+type videoLocks struct {
+	mutex *sync.Mutex
+	locks map[int32]*videoLock
+}
+
+type videoLock struct {
+	sync.Mutex
+	// waiting counts the holder and the workers waiting for the lock, it's
+	// dropped from the map once nobody needs it
+	waiting int
+}
+
+func newVideoLocks() *videoLocks {
+	return &videoLocks{mutex: &sync.Mutex{}, locks: map[int32]*videoLock{}}
+}
+
+// Lock waits for the lock of the video, the returned function releases it.
+func (locks *videoLocks) Lock(videoId int32) func() {
+	locks.mutex.Lock()
+	lock, found := locks.locks[videoId]
+	if !found {
+		lock = &videoLock{}
+		locks.locks[videoId] = lock
+	}
+	lock.waiting++
+	locks.mutex.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		locks.mutex.Lock()
+		lock.waiting--
+		if lock.waiting == 0 {
+			delete(locks.locks, videoId)
+		}
+		locks.mutex.Unlock()
+	}
+}
+
+// cacheKey names the cached files of a video after the size and modification
+// time of its file, so replaced files miss the cache.
+func cacheKey(videoId int32, stat os.FileInfo) string {
+	return fmt.Sprintf("%v-%v-%v", videoId, stat.Size(), stat.ModTime().UnixNano())
+}
+
+// removeStaleCache removes the files cached for older versions of the video
+// file, they won't be read again.
+func removeStaleCache(folder string, videoId int32, key string) {
+	stale, _ := filepath.Glob(filepath.Join(folder, fmt.Sprintf("%v-*", videoId)))
+	for _, file := range stale {
+		name := filepath.Base(file)
+		if !strings.HasPrefix(name, key+"-") && !strings.HasPrefix(name, key+".") {
+			os.Remove(file)
+		}
+	}
+}
+
+// writeFileAtomic writes the file under a temporary name first, so a reader
+// never finds it halfway written.
+func writeFileAtomic(filename string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err = temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+
+	if err = temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	return os.Rename(temp.Name(), filename)
+}
+
+// writeCacheFailure remembers why the file of a video couldn't be cached, so
+// broken files aren't read again until they change.
+func writeCacheFailure(folder string, key string, cause error) error {
+	return writeFileAtomic(filepath.Join(folder, key+".failed"), []byte(cause.Error()))
+}
+
+// readCacheFailure returns the failure written for the key, nil when there is
+// none.
+func readCacheFailure(folder string, key string) error {
+	data, err := os.ReadFile(filepath.Join(folder, key+".failed"))
+	if err != nil {
+		return nil
+	}
+
+	return errors.New(string(data))
+}
@@ -0,0 +1,220 @@
+package internals
+
+import (
+	"database/sql"
+	"errors"
+	"go-video-viewer/internals/probe"
+	"os"
+)
+
+// SkipKind is the part of an episode that a marker lets the player skip.
+type SkipKind string
+
+const (
+	SkipIntro SkipKind = "intro"
+	SkipOutro SkipKind = "outro"
+)
+
+// MarkerSource tells where a marker comes from. Markers saved on the video win
+// over the ones saved on its series, which win over the chapters.
+type MarkerSource string
+
+const (
+	MarkerFromChapter MarkerSource = "chapter"
+	MarkerFromSeries  MarkerSource = "series"
+	MarkerFromVideo   MarkerSource = "video"
+)
+
+var ErrInvalidMarker = errors.New("a marker must start at zero or later and end after it starts")
+
+type VideoChapter struct {
+	probe.Chapter
+	Skip SkipKind `json:"skip,omitempty"`
+}
+
+type SkipMarker struct {
+	Kind   SkipKind     `json:"kind"`
+	Start  float64      `json:"start"`
+	End    float64      `json:"end"`
+	Source MarkerSource `json:"source"`
+}
+
+type SkipMarkerPayload struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+type ChaptersResponse struct {
+	Chapters []VideoChapter `json:"chapters"`
+	Markers  []SkipMarker   `json:"markers"`
+}
+
+func ParseSkipKind(value string) (SkipKind, bool) {
+	switch kind := SkipKind(value); kind {
+	case SkipIntro, SkipOutro:
+		return kind, true
+	default:
+		return "", false
+	}
+}
+
+// ListSkipMarkers lists the markers saved on the video and on its series.
+func (repo VideoRepository) ListSkipMarkers(video Video) ([]SkipMarker, error) {
+	rows, err := repo.db.Query(
+		`
+		select
+			kind,
+			start_time,
+			end_time,
+			iif(video_id is null, ?, ?)
+		from
+			skip_markers
+		where
+			video_id = ?
+			or series_id = ?
+		`,
+		MarkerFromSeries,
+		MarkerFromVideo,
+		video.Id,
+		video.SeriesId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	markers := []SkipMarker{}
+	for rows.Next() {
+		var marker SkipMarker
+		if err = rows.Scan(&marker.Kind, &marker.Start, &marker.End, &marker.Source); err != nil {
+			return nil, err
+		}
+
+		markers = append(markers, marker)
+	}
+
+	return markers, rows.Err()
+}
+
+// SaveSkipMarker saves the marker of a video or of a series, replacing the one
+// of the same kind.
+func (repo VideoRepository) SaveSkipMarker(owner MarkerSource, ownerId int32, kind SkipKind, payload SkipMarkerPayload) error {
+	if payload.Start < 0 || payload.End <= payload.Start {
+		return ErrInvalidMarker
+	}
+
+	column, err := repo.markerOwner(owner, ownerId)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.db.Exec(
+		"insert or replace into skip_markers ("+column+", kind, start_time, end_time) values (?, ?, ?, ?)",
+		ownerId,
+		kind,
+		payload.Start,
+		payload.End,
+	)
+	return err
+}
+
+func (repo VideoRepository) DeleteSkipMarker(owner MarkerSource, ownerId int32, kind SkipKind) error {
+	column, err := repo.markerOwner(owner, ownerId)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.db.Exec("delete from skip_markers where "+column+" = ? and kind = ?", ownerId, kind)
+	return err
+}
+
+// markerOwner checks that the owner of a marker exists, returning the column
+// that references it.
+func (repo VideoRepository) markerOwner(owner MarkerSource, ownerId int32) (string, error) {
+	table, column, notFound := "videos", "video_id", ErrVideoNotFound
+	if owner == MarkerFromSeries {
+		table, column, notFound = "series", "series_id", ErrSeriesNotFound
+	}
+
+	var id int32
+	err := repo.db.QueryRow("select id from "+table+" where id = ?", ownerId).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", notFound
+	}
+
+	return column, err
+}
+
+// VideoChapters lists the chapters of the video, flagging the openings and
+// endings, along with the markers the player can skip. A missing file has no
+// chapters, like a truncated one, but its saved markers are still listed.
+func (app App) VideoChapters(video Video) (ChaptersResponse, error) {
+	response := ChaptersResponse{Chapters: []VideoChapter{}}
+
+	media, err := app.VideoMedia(video)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return response, err
+	}
+
+	if media != nil {
+		patterns := app.Config.ChapterPatterns
+		for _, chapter := range media.Chapters {
+			item := VideoChapter{Chapter: chapter}
+			switch {
+			case patterns.Opening.MatchString(chapter.Title):
+				item.Skip = SkipIntro
+			case patterns.Ending.MatchString(chapter.Title):
+				item.Skip = SkipOutro
+			}
+
+			response.Chapters = append(response.Chapters, item)
+		}
+	}
+
+	saved, err := app.Repo.ListSkipMarkers(video)
+	if err != nil {
+		return response, err
+	}
+
+	response.Markers = mergeSkipMarkers(response.Chapters, saved)
+	return response, nil
+}
+
+// mergeSkipMarkers keeps a single marker of each kind, from the most specific
+// source that has one.
+func mergeSkipMarkers(chapters []VideoChapter, saved []SkipMarker) []SkipMarker {
+	candidates := []SkipMarker{}
+	for _, chapter := range chapters {
+		if chapter.Skip != "" {
+			candidates = append(candidates, SkipMarker{
+				Kind:   chapter.Skip,
+				Start:  chapter.Start,
+				End:    chapter.End,
+				Source: MarkerFromChapter,
+			})
+		}
+	}
+	candidates = append(candidates, saved...)
+
+	rank := map[MarkerSource]int{MarkerFromChapter: 0, MarkerFromSeries: 1, MarkerFromVideo: 2}
+
+	markers := []SkipMarker{}
+	for _, kind := range []SkipKind{SkipIntro, SkipOutro} {
+		var chosen *SkipMarker
+		for i, candidate := range candidates {
+			if candidate.Kind != kind {
+				continue
+			}
+
+			if chosen == nil || rank[candidate.Source] > rank[chosen.Source] {
+				chosen = &candidates[i]
+			}
+		}
+
+		if chosen != nil {
+			markers = append(markers, *chosen)
+		}
+	}
+
+	return markers
+}
@@ -0,0 +1,287 @@
+package internals
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// ChecksumStatus is the result of comparing the CRC32 of a file with the one in
+// its filename.
+type ChecksumStatus string
+
+const (
+	ChecksumUnchecked  ChecksumStatus = "unchecked"
+	ChecksumVerified   ChecksumStatus = "verified"
+	ChecksumMismatch   ChecksumStatus = "mismatch"
+	ChecksumNoChecksum ChecksumStatus = "no_checksum"
+)
+
+// verifyJobKey keeps a single verification running.
+const verifyJobKey = "verify"
+
+// checksumChunk is how much is read between checks of the rate limit and of
+// the cancellation.
+const checksumChunk = 1 << 20
+
+type ChecksumStats struct {
+	Unchecked  int `json:"unchecked"`
+	Verified   int `json:"verified"`
+	Mismatch   int `json:"mismatch"`
+	NoChecksum int `json:"no_checksum"`
+}
+
+type VerifyProgress struct {
+	Checked  int    `json:"checked"`
+	Total    int    `json:"total"`
+	Filename string `json:"filename"`
+}
+
+type VerifyResult struct {
+	Checked    int     `json:"checked"`
+	Mismatched []Video `json:"mismatched"`
+}
+
+// PendingChecksums lists the videos whose file wasn't checked yet. Videos
+// without a checksum in their filename are marked right away, truncated and
+// missing files wait until they are back.
+func (repo VideoRepository) PendingChecksums() ([]Video, error) {
+	_, err := repo.db.Exec(
+		"update videos set checksum_status = ? where checksum_status is null and crc32 is null",
+		ChecksumNoChecksum,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.queryVideos(
+		`
+		select
+			` + videoColumns + `
+		from
+			videos
+		where
+			checksum_status is null
+			and file_size > 0
+			and missing_since is null
+		order by
+			id
+		`,
+	)
+}
+
+func (repo VideoRepository) SaveChecksumStatus(videoId int32, status ChecksumStatus) error {
+	_, err := repo.db.Exec(
+		"update videos set checksum_status = ?, checksum_checked_at = ? where id = ?",
+		status,
+		time.Now().UTC(),
+		videoId,
+	)
+	return err
+}
+
+func (repo VideoRepository) QueryChecksumStats() (ChecksumStats, error) {
+	rows, err := repo.db.Query(`
+		select
+			coalesce(checksum_status, ?),
+			count(id)
+		from
+			videos
+		group by
+			1
+	`, ChecksumUnchecked)
+	if err != nil {
+		return ChecksumStats{}, err
+	}
+	defer rows.Close()
+
+	var stats ChecksumStats
+	for rows.Next() {
+		var status ChecksumStatus
+		var quantity int
+
+		if err = rows.Scan(&status, &quantity); err != nil {
+			return ChecksumStats{}, err
+		}
+
+		switch status {
+		case ChecksumUnchecked:
+			stats.Unchecked = quantity
+		case ChecksumVerified:
+			stats.Verified = quantity
+		case ChecksumMismatch:
+			stats.Mismatch = quantity
+		case ChecksumNoChecksum:
+			stats.NoChecksum = quantity
+		}
+	}
+
+	return stats, rows.Err()
+}
+
+// StartVerification checks the CRC32 of the files that weren't checked yet in
+// the background. Every result is saved as soon as it's known, so a cancelled
+// verification continues where it stopped.
+func (app App) StartVerification() (Job, bool) {
+	return app.Jobs.Start("verify", verifyJobKey, func(ctx context.Context, progress func(any)) (any, error) {
+		videos, err := app.Repo.PendingChecksums()
+		if err != nil {
+			return nil, err
+		}
+
+		result := VerifyResult{Mismatched: []Video{}}
+		for _, video := range videos {
+			progress(VerifyProgress{Checked: result.Checked, Total: len(videos), Filename: video.Filename})
+
+			status, err := app.verifyChecksum(ctx, video)
+			if err != nil {
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+
+				// the file may be replaced or come back later
+				log.Printf("Failed to verify the checksum of '%v': %v", video.Filename, err)
+				continue
+			}
+
+			if status == ChecksumUnchecked {
+				continue
+			}
+
+			if err = app.Repo.SaveChecksumStatus(video.Id, status); err != nil {
+				return result, err
+			}
+
+			result.Checked++
+			if status == ChecksumMismatch {
+				video.ChecksumStatus = status
+				result.Mismatched = append(result.Mismatched, video)
+			}
+		}
+
+		return result, nil
+	})
+}
+
+func (app App) verifyChecksum(ctx context.Context, video Video) (ChecksumStatus, error) {
+	path, err := app.VideoPath(video)
+	if err != nil {
+		return "", err
+	}
+
+	// disposed files are truncated, there's nothing left to check
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	if info.Size() == 0 {
+		return ChecksumUnchecked, nil
+	}
+
+	checksum, err := fileCrc32(ctx, path, app.Config.VerifyRate)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.EqualFold(checksum, video.Release.CRC32) {
+		return ChecksumVerified, nil
+	}
+
+	return ChecksumMismatch, nil
+}
+
+// fileCrc32 streams the file through CRC32, reading at most rate megabytes per
+// second when rate is positive.
+func fileCrc32(ctx context.Context, path string, rate int) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := crc32.NewIEEE()
+	buf := make([]byte, checksumChunk)
+	limiter := newReadLimiter(ctx, rate)
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return "", err
+		}
+
+		n, err := file.Read(buf)
+		hash.Write(buf[:n])
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if err = limiter.wait(n); err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%08X", hash.Sum32()), nil
+}
+
+// readLimiter paces the reads of a background job to at most rate megabytes
+// per second, no limit when rate isn't positive.
+type readLimiter struct {
+	ctx     context.Context
+	rate    int
+	started time.Time
+	read    int64
+}
+
+func newReadLimiter(ctx context.Context, rate int) *readLimiter {
+	return &readLimiter{ctx: ctx, rate: rate, started: time.Now()}
+}
+
+// wait counts n more bytes read and sleeps until the rate allows them, failing
+// when the context ends first.
+func (limiter *readLimiter) wait(n int) error {
+	limiter.read += int64(n)
+	if limiter.rate <= 0 {
+		return nil
+	}
+
+	expected := time.Duration(float64(limiter.read) / float64(limiter.rate<<20) * float64(time.Second))
+	if wait := expected - time.Since(limiter.started); wait > 0 {
+		select {
+		case <-limiter.ctx.Done():
+			return limiter.ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	return nil
+}
+
+// limitedReader paces the reads of a file with a readLimiter, and stops them
+// once its context ends.
+type limitedReader struct {
+	io.ReadSeeker
+	limiter *readLimiter
+}
+
+func (reader limitedReader) Read(p []byte) (int, error) {
+	if err := reader.limiter.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := reader.ReadSeeker.Read(p)
+	if waitErr := reader.limiter.wait(n); waitErr != nil {
+		return n, waitErr
+	}
+
+	return n, err
+}
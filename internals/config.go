@@ -5,18 +5,61 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/ini.v1"
 )
 
+// DisposalMode is what happens to the file of a video once it is marked with a
+// status that doesn't keep it.
+type DisposalMode string
+
+const (
+	DisposalTruncate DisposalMode = "truncate"
+	DisposalDelete   DisposalMode = "delete"
+	DisposalArchive  DisposalMode = "archive"
+	DisposalKeep     DisposalMode = "keep"
+)
+
 type Config struct {
-	Database    string `ini:"database"`
-	VideoFolder string `ini:"video_folder"`
-	Address     string `ini:"address"`
-	Port        string `ini:"port"`
+	Database          string          `ini:"database"`
+	VideoFolder       string          `ini:"video_folder"`
+	Address           string          `ini:"address"`
+	Port              string          `ini:"port"`
+	HoldingFolder     string          `ini:"holding_folder"`
+	GracePeriod       time.Duration   `ini:"grace_period"`
+	Disposal          DisposalMode    `ini:"disposal"`
+	DisposalUnwatched DisposalMode    `ini:"disposal_unwatched"`
+	DisposalWatched   DisposalMode    `ini:"disposal_watched"`
+	DisposalLiked     DisposalMode    `ini:"disposal_liked"`
+	ArchiveFolder     string          `ini:"archive_folder"`
+	RecursiveScan     bool            `ini:"recursive_scan"`
+	MaxDepth          int             `ini:"max_depth"`
+	Watch             bool            `ini:"watch"`
+	RescanInterval    time.Duration   `ini:"rescan_interval"`
+	QueueOrder        QueueOrder      `ini:"queue_order"`
+	VerifyChecksums   bool            `ini:"verify_checksums"`
+	VerifyRate        int             `ini:"verify_rate"`
+	IndexRate         int             `ini:"index_rate"`
+	OpeningPattern    string          `ini:"opening_pattern"`
+	EndingPattern     string          `ini:"ending_pattern"`
+	CacheFolder       string          `ini:"cache_folder"`
+	FFmpeg            string          `ini:"ffmpeg"`
+	TranscodeLimit    int             `ini:"transcode_limit"`
+	TranscodeIdle     time.Duration   `ini:"transcode_idle"`
+	Libraries         []Library       `ini:"-"`
+	ChapterPatterns   ChapterPatterns `ini:"-"`
+}
+
+// ChapterPatterns match the titles of the chapters that can be skipped.
+type ChapterPatterns struct {
+	Opening *regexp.Regexp
+	Ending  *regexp.Regexp
 }
 
 func LoadConfig() (Config, error) {
@@ -34,6 +77,15 @@ func LoadConfig() (Config, error) {
 		VideoFolder: "",
 		Address:     "127.0.0.1",
 		Port:        "3000",
+		Disposal:    DisposalTruncate,
+		QueueOrder:  QueueByCreatedAt,
+		VerifyRate:  20,
+		IndexRate:   20,
+
+		TranscodeLimit: 2,
+
+		OpeningPattern: `^\s*(opening|op|intro)(\s*\d+)?\b`,
+		EndingPattern:  `^\s*(ending|ed|outro|credits)(\s*\d+)?\b`,
 	}
 
 	err = cfg.MapTo(&pathConfig)
@@ -41,11 +93,38 @@ func LoadConfig() (Config, error) {
 		return Config{}, err
 	}
 
+	// ini ignores zero durations, so the default can't be set beforehand
+	if !cfg.Section("").HasKey("grace_period") {
+		pathConfig.GracePeriod = 10 * time.Minute
+	}
+
+	if !cfg.Section("").HasKey("transcode_idle") {
+		pathConfig.TranscodeIdle = 5 * time.Minute
+	}
+
+	if pathConfig.CacheFolder == "" && pathConfig.Database != "" {
+		pathConfig.CacheFolder = filepath.Join(filepath.Dir(pathConfig.Database), "cache")
+	}
+
+	if pathConfig.Watch && !cfg.Section("").HasKey("rescan_interval") {
+		pathConfig.RescanInterval = time.Hour
+	}
+
+	pathConfig.Libraries, err = loadLibraries(cfg, pathConfig)
+	if err != nil {
+		return Config{}, err
+	}
+
 	err = pathConfig.validate()
 	if err != nil {
 		return Config{}, err
 	}
 
+	pathConfig.ChapterPatterns = ChapterPatterns{
+		Opening: regexp.MustCompile("(?i)" + pathConfig.OpeningPattern),
+		Ending:  regexp.MustCompile("(?i)" + pathConfig.EndingPattern),
+	}
+
 	return pathConfig, nil
 }
 
@@ -54,17 +133,179 @@ func (cfg Config) validate() error {
 		return errors.New("\"database\" config was not set. Should be the path of the database file")
 	}
 
-	if cfg.VideoFolder == "" {
-		return errors.New("\"video_folder\" config was not set. Should be the path of the folder that contains the videos")
+	if len(cfg.Libraries) == 0 {
+		return errors.New("\"video_folder\" config was not set. Should be the path of the folder that contains the videos, or add [library.<name>] sections with their own video_folder")
+	}
+
+	for _, library := range cfg.Libraries {
+		if library.VideoFolder == "" {
+			return fmt.Errorf("\"video_folder\" config of library \"%v\" was not set. Should be the path of the folder that contains the videos", library.Name)
+		}
+
+		if library.MaxDepth < 0 {
+			return fmt.Errorf("\"max_depth\" config of library \"%v\" was not properly set. Should be 0 for no limit or a positive number", library.Name)
+		}
 	}
 
 	if v, err := strconv.Atoi(cfg.Port); err != nil || v <= 0 || v > 65535 {
 		return errors.New("\"port\" config was not properly set. Should be a number in the range [1, 65535]")
 	}
 
+	if !cfg.Disposal.valid() {
+		return errors.New("\"disposal\" config was not properly set. Should be one of truncate, delete, archive or keep")
+	}
+
+	overrides := map[string]DisposalMode{
+		"disposal_unwatched": cfg.DisposalUnwatched,
+		"disposal_watched":   cfg.DisposalWatched,
+		"disposal_liked":     cfg.DisposalLiked,
+	}
+
+	usesArchive := cfg.Disposal == DisposalArchive
+	for key, mode := range overrides {
+		if mode != "" && !mode.valid() {
+			return fmt.Errorf("\"%v\" config was not properly set. Should be one of truncate, delete, archive or keep", key)
+		}
+
+		usesArchive = usesArchive || mode == DisposalArchive
+	}
+
+	if usesArchive && cfg.ArchiveFolder == "" {
+		return errors.New("\"archive_folder\" config was not set. Should be the path of the folder that receives the archived videos")
+	}
+
+	if cfg.MaxDepth < 0 {
+		return errors.New("\"max_depth\" config was not properly set. Should be 0 for no limit or a positive number")
+	}
+
+	if cfg.GracePeriod < 0 {
+		return errors.New("\"grace_period\" config was not properly set. Should be a positive duration, like 10m or 1h30m")
+	}
+
+	if !cfg.QueueOrder.valid() {
+		return errors.New("\"queue_order\" config was not properly set. Should be created_at or series")
+	}
+
+	if cfg.VerifyRate < 0 {
+		return errors.New("\"verify_rate\" config was not properly set. Should be 0 for no limit or a positive number of megabytes per second")
+	}
+
+	if cfg.IndexRate < 0 {
+		return errors.New("\"index_rate\" config was not properly set. Should be 0 for no limit or a positive number of megabytes per second")
+	}
+
+	if cfg.RescanInterval < 0 {
+		return errors.New("\"rescan_interval\" config was not properly set. Should be a positive duration, like 30m or 1h")
+	}
+
+	if cfg.FFmpeg != "" {
+		if _, err := exec.LookPath(cfg.FFmpeg); err != nil {
+			return errors.New("\"ffmpeg\" config was not properly set. Should be the path of the ffmpeg executable")
+		}
+	}
+
+	if cfg.TranscodeLimit <= 0 {
+		return errors.New("\"transcode_limit\" config was not properly set. Should be a positive number")
+	}
+
+	if cfg.TranscodeIdle <= 0 {
+		return errors.New("\"transcode_idle\" config was not properly set. Should be a positive duration, like 5m or 1h")
+	}
+
+	patterns := map[string]string{
+		"opening_pattern": cfg.OpeningPattern,
+		"ending_pattern":  cfg.EndingPattern,
+	}
+
+	for key, pattern := range patterns {
+		if _, err := regexp.Compile("(?i)" + pattern); err != nil {
+			return fmt.Errorf("\"%v\" config was not properly set. Should be a regular expression: %v", key, err)
+		}
+	}
+
 	return nil
 }
 
+// loadLibraries reads the [library.<name>] sections, the top level video_folder
+// becomes the default library. Libraries inherit the top level scan configs.
+func loadLibraries(file *ini.File, cfg Config) ([]Library, error) {
+	var libraries []Library
+
+	if cfg.VideoFolder != "" {
+		libraries = append(libraries, Library{
+			Name:          DefaultLibraryName,
+			VideoFolder:   cfg.VideoFolder,
+			RecursiveScan: cfg.RecursiveScan,
+			MaxDepth:      cfg.MaxDepth,
+		})
+	}
+
+	for _, section := range file.Sections() {
+		name, found := strings.CutPrefix(section.Name(), "library.")
+		if !found {
+			continue
+		}
+
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, "/?#%") {
+			return nil, fmt.Errorf("invalid library name \"%v\"", name)
+		}
+
+		for _, library := range libraries {
+			if strings.EqualFold(library.Name, name) {
+				return nil, fmt.Errorf("library \"%v\" was configured more than once", name)
+			}
+		}
+
+		library := Library{
+			Name:          name,
+			RecursiveScan: cfg.RecursiveScan,
+			MaxDepth:      cfg.MaxDepth,
+		}
+
+		if err := section.MapTo(&library); err != nil {
+			return nil, err
+		}
+
+		libraries = append(libraries, library)
+	}
+
+	return libraries, nil
+}
+
+// DisposalFor returns the disposal mode of a status, saved videos always keep
+// their files.
+func (cfg Config) DisposalFor(status VideoStatus) DisposalMode {
+	if status.PersistFile() {
+		return DisposalKeep
+	}
+
+	var override DisposalMode
+	switch status {
+	case VideoUnwatched:
+		override = cfg.DisposalUnwatched
+	case VideoWatched:
+		override = cfg.DisposalWatched
+	case VideoLiked:
+		override = cfg.DisposalLiked
+	}
+
+	if override != "" {
+		return override
+	}
+
+	return cfg.Disposal
+}
+
+func (mode DisposalMode) valid() bool {
+	switch mode {
+	case DisposalTruncate, DisposalDelete, DisposalArchive, DisposalKeep:
+		return true
+	default:
+		return false
+	}
+}
+
 func getIniPath() string {
 	exePath, err := os.Executable()
 	if err != nil {
@@ -0,0 +1,458 @@
+package internals
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrVideoNotFound = errors.New("video not found")
+	ErrNothingToUndo = errors.New("the video has no status change to undo")
+	ErrUndoExpired   = errors.New("the grace period is over, the file was already disposed")
+)
+
+// PendingDisposal is a video file waiting for its grace period to end before
+// being disposed. While it lasts the file is either untouched or moved to the
+// holding folder, so the status change can be undone.
+type PendingDisposal struct {
+	VideoId      int32
+	Mode         DisposalMode
+	OriginalPath string
+	HeldPath     NullString
+	DisposeAt    time.Time
+}
+
+func (repo VideoRepository) FindPendingDisposal(videoId int32) (*PendingDisposal, error) {
+	rows, err := repo.db.Query(
+		`
+		select
+			video_id,
+			mode,
+			original_path,
+			held_path,
+			dispose_at
+		from
+			pending_disposals
+		where
+			video_id = ?
+		`,
+		videoId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	disposals, err := readPendingDisposals(rows)
+	if err != nil || len(disposals) == 0 {
+		return nil, err
+	}
+
+	return &disposals[0], nil
+}
+
+func (repo VideoRepository) DueDisposals(now time.Time) ([]PendingDisposal, error) {
+	rows, err := repo.db.Query(
+		`
+		select
+			video_id,
+			mode,
+			original_path,
+			held_path,
+			dispose_at
+		from
+			pending_disposals
+		where
+			dispose_at <= ?
+		order by
+			dispose_at
+		`,
+		now.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return readPendingDisposals(rows)
+}
+
+func (repo VideoRepository) SavePendingDisposal(disposal PendingDisposal) error {
+	_, err := repo.db.Exec(
+		`
+		insert into pending_disposals
+			(video_id, mode, original_path, held_path, dispose_at)
+		values
+			(?, ?, ?, ?, ?)
+		on conflict (video_id) do update set
+			mode = excluded.mode,
+			original_path = excluded.original_path,
+			held_path = excluded.held_path,
+			dispose_at = excluded.dispose_at
+		`,
+		disposal.VideoId,
+		disposal.Mode,
+		disposal.OriginalPath,
+		sql.NullString(disposal.HeldPath),
+		disposal.DisposeAt.UTC(),
+	)
+
+	return err
+}
+
+func (repo VideoRepository) DeletePendingDisposal(videoId int32) error {
+	_, err := repo.db.Exec("delete from pending_disposals where video_id = ?", videoId)
+	return err
+}
+
+func readPendingDisposals(rows *sql.Rows) ([]PendingDisposal, error) {
+	defer rows.Close()
+
+	var disposals []PendingDisposal
+	for rows.Next() {
+		var disposal PendingDisposal
+		err := rows.Scan(
+			&disposal.VideoId,
+			&disposal.Mode,
+			&disposal.OriginalPath,
+			&disposal.HeldPath,
+			&disposal.DisposeAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		disposals = append(disposals, disposal)
+	}
+
+	return disposals, rows.Err()
+}
+
+// scheduleDisposal defers the disposal of a video file by the configured grace
+// period. Files that are already empty are left as they are, and files already
+// waiting wait again for the mode of the new status.
+func (app App) scheduleDisposal(video Video) error {
+	app.disposals.Lock()
+	defer app.disposals.Unlock()
+
+	mode := app.Config.DisposalFor(video.Status)
+
+	pending, err := app.Repo.FindPendingDisposal(video.Id)
+	if err != nil {
+		return err
+	}
+
+	if pending != nil {
+		if pending.Mode == mode {
+			return nil
+		}
+
+		pending.Mode = mode
+		pending.DisposeAt = time.Now().Add(app.Config.GracePeriod)
+		return app.Repo.SavePendingDisposal(*pending)
+	}
+
+	videoPath, err := app.VideoPath(video)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(videoPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	if info.Size() <= 0 {
+		return nil
+	}
+
+	disposal := PendingDisposal{
+		VideoId:      video.Id,
+		Mode:         mode,
+		OriginalPath: videoPath,
+		DisposeAt:    time.Now().Add(app.Config.GracePeriod),
+	}
+
+	if disposal.Mode == DisposalKeep {
+		return nil
+	}
+
+	if app.Config.GracePeriod <= 0 {
+		return app.disposeFile(disposal)
+	}
+
+	if app.Config.HoldingFolder != "" {
+		heldPath := filepath.Join(
+			app.Config.HoldingFolder,
+			fmt.Sprintf("%v-%v", video.Id, filepath.Base(video.Filename)),
+		)
+
+		if err = moveFile(videoPath, heldPath); err != nil {
+			return err
+		}
+		disposal.HeldPath = NullString{String: heldPath, Valid: true}
+	}
+
+	return app.Repo.SavePendingDisposal(disposal)
+}
+
+// cancelDisposal puts back a file that is waiting to be disposed. It reports
+// whether there was a pending disposal.
+func (app App) cancelDisposal(videoId int32) (bool, error) {
+	app.disposals.Lock()
+	defer app.disposals.Unlock()
+
+	pending, err := app.Repo.FindPendingDisposal(videoId)
+	if err != nil || pending == nil {
+		return false, err
+	}
+
+	if pending.HeldPath.Valid {
+		if err = moveFile(pending.HeldPath.String, pending.OriginalPath); err != nil {
+			return false, err
+		}
+	}
+
+	return true, app.Repo.DeletePendingDisposal(videoId)
+}
+
+// DisposeDueFiles disposes the files whose grace period is over.
+func (app App) DisposeDueFiles() error {
+	app.disposals.Lock()
+	defer app.disposals.Unlock()
+
+	due, err := app.Repo.DueDisposals(time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, disposal := range due {
+		if err = app.disposeFile(disposal); err != nil {
+			return err
+		}
+
+		if err = app.Repo.DeletePendingDisposal(disposal.VideoId); err != nil {
+			return err
+		}
+
+		log.Printf("Disposed file of video %v (%v)", disposal.VideoId, disposal.Mode)
+	}
+
+	return nil
+}
+
+// disposeFile applies the disposal mode to a file, wherever it is waiting.
+func (app App) disposeFile(disposal PendingDisposal) error {
+	current := disposal.OriginalPath
+	if disposal.HeldPath.Valid {
+		current = disposal.HeldPath.String
+	}
+
+	var err error
+	switch disposal.Mode {
+	case DisposalKeep:
+		if current != disposal.OriginalPath {
+			err = moveFile(current, disposal.OriginalPath)
+		}
+	case DisposalDelete:
+		err = os.Remove(current)
+	case DisposalArchive:
+		var target string
+		if target, err = app.archivePath(disposal); err == nil {
+			err = moveFile(current, target)
+		}
+	default:
+		if current != disposal.OriginalPath {
+			err = os.Remove(current)
+			if err == nil || errors.Is(err, os.ErrNotExist) {
+				// leave an empty file behind, so it is not downloaded again,
+				// unless it was downloaded again while it was held
+				err = createEmptyFile(disposal.OriginalPath)
+			}
+		} else {
+			err = os.Truncate(current, 0)
+		}
+	}
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// archivePath returns where the file is archived, at the same path relative to
+// the archive folder as the video has in its library. A number is added to the
+// name when another file is already there.
+func (app App) archivePath(disposal PendingDisposal) (string, error) {
+	relative := filepath.Base(disposal.OriginalPath)
+
+	video, err := app.Repo.FindById(disposal.VideoId)
+	if err != nil {
+		return "", err
+	}
+
+	if video != nil {
+		relative = filepath.FromSlash(video.Filename)
+	}
+
+	target := filepath.Join(app.Config.ArchiveFolder, relative)
+	extension := filepath.Ext(target)
+	name := strings.TrimSuffix(target, extension)
+
+	for number := 2; ; number++ {
+		if _, err = os.Lstat(target); err != nil {
+			return target, nil
+		}
+
+		target = fmt.Sprintf("%v (%v)%v", name, number, extension)
+	}
+}
+
+// createEmptyFile creates the file empty, leaving it alone when something
+// already exists at its path.
+func createEmptyFile(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// RunDisposals disposes the due files every interval, it never returns.
+func (app App) RunDisposals(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := app.DisposeDueFiles(); err != nil {
+			log.Println("DisposeDueFiles failed:", err)
+		}
+
+		<-ticker.C
+	}
+}
+
+// UndoVideo reverts the last status change of a video, restoring its file if
+// it is still within the grace period. The playback position is lost though,
+// it is deleted as soon as the video leaves the queue.
+func (app App) UndoVideo(videoId int32) (*Video, error) {
+	video, err := app.Repo.FindById(videoId)
+	if err != nil {
+		return nil, err
+	}
+
+	if video == nil {
+		return nil, ErrVideoNotFound
+	}
+
+	event, err := app.Repo.LastStatusChange(videoId)
+	if err != nil {
+		return nil, err
+	}
+
+	if event == nil || !event.OldValue.Valid {
+		return nil, ErrNothingToUndo
+	}
+
+	previousStatus, err := StatusFromStringValue(event.OldValue.String)
+	if err != nil {
+		return nil, err
+	}
+
+	current := *video
+	video.Status = previousStatus
+
+	// a video only loses its file after it leaves the queue
+	if app.Config.DisposalFor(previousStatus) != DisposalKeep && previousStatus != VideoUnwatched {
+		if err = app.updateVideo(*video, event.Id); err != nil {
+			return nil, err
+		}
+
+		return video, nil
+	}
+
+	restored, err := app.cancelDisposal(videoId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !restored {
+		videoPath, err := app.VideoPath(*video)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := os.Stat(videoPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		if err != nil || info.Size() <= 0 {
+			return nil, ErrUndoExpired
+		}
+	}
+
+	if err = app.Repo.update(*video, event.Id); err != nil {
+		return nil, err
+	}
+	app.publishVideoUpdate(*video, &current)
+
+	return video, nil
+}
+
+// moveFile renames a file, falling back to copying it when the destination is
+// on another device.
+func moveFile(source string, destination string) error {
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return err
+	}
+
+	err := os.Rename(source, destination)
+	if err == nil {
+		return nil
+	}
+
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) {
+		return err
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(destination)
+		return err
+	}
+
+	if err = out.Close(); err != nil {
+		os.Remove(destination)
+		return err
+	}
+
+	in.Close()
+	return os.Remove(source)
+}
@@ -0,0 +1,80 @@
+//go:build sqlite_fts5
+
+package internals
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestStatusChangesUpdateThePendingDisposal(t *testing.T) {
+	folder := t.TempDir()
+	if err := os.WriteFile(filepath.Join(folder, "video.mkv"), []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{
+		Database:        filepath.Join(t.TempDir(), "videos.db"),
+		Libraries:       []Library{{Name: DefaultLibraryName, VideoFolder: folder}},
+		GracePeriod:     time.Hour,
+		DisposalWatched: DisposalTruncate,
+		DisposalLiked:   DisposalArchive,
+		ArchiveFolder:   t.TempDir(),
+	}
+
+	repo, err := NewRepository(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	app := App{Config: config, Repo: repo, Events: NewEventHub(), disposals: &sync.Mutex{}}
+
+	_, err = repo.ImportFsEntries(repo.Libraries()[0].Id, []VideoFsEntry{{Filename: "video.mkv", LastModifiedTime: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	video, err := repo.FindById(1)
+	if err != nil || video == nil {
+		t.Fatalf("FindById = %v, %v, want the imported video", video, err)
+	}
+
+	steps := []struct {
+		name   string
+		status VideoStatus
+		undo   bool
+		want   DisposalMode
+	}{
+		{name: "liked", status: VideoLiked, want: DisposalArchive},
+		{name: "watched", status: VideoWatched, want: DisposalTruncate},
+		{name: "undo", undo: true, want: DisposalArchive},
+	}
+
+	for _, step := range steps {
+		if step.undo {
+			_, err = app.UndoVideo(video.Id)
+		} else {
+			video.Status = step.status
+			err = app.UpdateVideo(*video)
+		}
+
+		if err != nil {
+			t.Fatalf("%v: %v", step.name, err)
+		}
+
+		pending, err := repo.FindPendingDisposal(video.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pending == nil || pending.Mode != step.want {
+			t.Errorf("%v: pending disposal = %+v, want mode %v", step.name, pending, step.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package internals
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+type EventKind string
+
+const (
+	EventScanStarted   EventKind = "scan_started"
+	EventScanProgress  EventKind = "scan_progress"
+	EventScanFinished  EventKind = "scan_finished"
+	EventVideoUpdated  EventKind = "video_updated"
+	EventVideoImported EventKind = "video_imported"
+)
+
+// eventBuffer is how many events a subscriber can fall behind before it starts
+// missing them.
+const eventBuffer = 64
+
+type Event struct {
+	Id   int64     `json:"id"`
+	Kind EventKind `json:"kind"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+type ScanStartedEvent struct {
+	LibraryId *int32 `json:"library_id"`
+	Libraries int    `json:"libraries"`
+}
+
+type ScanProgressEvent struct {
+	LibraryId int32       `json:"library_id"`
+	Library   string      `json:"library"`
+	Done      int         `json:"done"`
+	Total     int         `json:"total"`
+	Summary   ScanSummary `json:"summary"`
+}
+
+type VideoUpdatedEvent struct {
+	Video          Video        `json:"video"`
+	PreviousStatus *VideoStatus `json:"previous_status"`
+}
+
+// EventHub hands the published events to every subscriber. Publishing never
+// blocks, subscribers that don't keep up lose events.
+type EventHub struct {
+	mu          *sync.Mutex
+	lastId      int64
+	subscribers map[chan Event]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{mu: &sync.Mutex{}, subscribers: map[chan Event]struct{}{}}
+}
+
+// Subscribe returns the channel of the events published from now on, and the
+// function that stops them.
+func (hub *EventHub) Subscribe() (<-chan Event, func()) {
+	events := make(chan Event, eventBuffer)
+
+	hub.mu.Lock()
+	hub.subscribers[events] = struct{}{}
+	hub.mu.Unlock()
+
+	unsubscribe := func() {
+		hub.mu.Lock()
+		delete(hub.subscribers, events)
+		hub.mu.Unlock()
+	}
+
+	return events, unsubscribe
+}
+
+func (hub *EventHub) Publish(kind EventKind, data any) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.lastId++
+	event := Event{Id: hub.lastId, Kind: kind, Time: time.Now(), Data: data}
+
+	for events := range hub.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+func (app App) publishVideoUpdate(video Video, previous *Video) {
+	event := VideoUpdatedEvent{Video: video}
+	if previous != nil {
+		event.PreviousStatus = &previous.Status
+	}
+
+	app.Events.Publish(EventVideoUpdated, event)
+}
+
+// publishImports announces every video a scan or the watcher imported.
+func (app App) publishImports(result ScanResult) {
+	for _, entry := range slices.Concat(result.Added, result.Truncated) {
+		app.Events.Publish(EventVideoImported, entry)
+	}
+}
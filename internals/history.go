@@ -0,0 +1,364 @@
+package internals
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type VideoEventKind string
+
+const (
+	EventStatus   VideoEventKind = "status"
+	EventNickname VideoEventKind = "nickname"
+	EventTags     VideoEventKind = "tags"
+)
+
+type VideoEvent struct {
+	Id       int64          `json:"id"`
+	VideoId  int32          `json:"video_id"`
+	Filename string         `json:"filename"`
+	Kind     VideoEventKind `json:"kind"`
+	OldValue NullString     `json:"old_value"`
+	NewValue NullString     `json:"new_value"`
+	// Undoes is the status event this one reverted
+	Undoes    *int64    `json:"undoes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type HistoryQuery struct {
+	From     *time.Time
+	To       *time.Time
+	Page     int
+	PageSize int
+}
+
+type VideoHistoryResponse struct {
+	Events   []VideoEvent `json:"events"`
+	Total    int          `json:"total"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+}
+
+// ParseHistoryQuery reads the "from" and "to" date filters, which accept either
+// a RFC 3339 timestamp or a plain date, in which case "to" includes the whole
+// day. The paging parameters are the same as the video lists.
+func ParseHistoryQuery(values url.Values) (HistoryQuery, error) {
+	list, err := ParseVideoListQuery(url.Values{
+		"page":      values["page"],
+		"page_size": values["page_size"],
+	})
+	if err != nil {
+		return HistoryQuery{}, err
+	}
+
+	query := HistoryQuery{Page: list.Page, PageSize: list.PageSize}
+
+	if value := values.Get("from"); value != "" {
+		from, _, err := parseHistoryDate(value)
+		if err != nil {
+			return HistoryQuery{}, fmt.Errorf("invalid value for from \"%v\"", value)
+		}
+		query.From = &from
+	}
+
+	if value := values.Get("to"); value != "" {
+		to, dateOnly, err := parseHistoryDate(value)
+		if err != nil {
+			return HistoryQuery{}, fmt.Errorf("invalid value for to \"%v\"", value)
+		}
+
+		if dateOnly {
+			to = to.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		query.To = &to
+	}
+
+	return query, nil
+}
+
+func parseHistoryDate(value string) (time.Time, bool, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date.UTC(), true, nil
+	}
+
+	date, err := time.Parse(time.RFC3339, value)
+	return date.UTC(), false, err
+}
+
+// ListHistory lists the events of a video, or of every video when the id is
+// zero, from the newest to the oldest.
+func (repo VideoRepository) ListHistory(videoId int32, query HistoryQuery) ([]VideoEvent, int, error) {
+	where := "1 = 1"
+	var args []any
+
+	if videoId != 0 {
+		where += " and video_events.video_id = ?"
+		args = append(args, videoId)
+	}
+
+	if query.From != nil {
+		where += " and video_events.created_at >= ?"
+		args = append(args, *query.From)
+	}
+
+	if query.To != nil {
+		where += " and video_events.created_at <= ?"
+		args = append(args, *query.To)
+	}
+
+	return repo.listEvents(where, args, query.PageSize, (query.Page-1)*query.PageSize)
+}
+
+func (repo VideoRepository) listEvents(where string, args []any, limit int, offset int) ([]VideoEvent, int, error) {
+	var total int
+	err := repo.db.QueryRow("select count(id) from video_events where "+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := repo.db.Query(
+		`
+		select
+			video_events.id,
+			video_events.video_id,
+			videos.filename,
+			video_events.kind,
+			video_events.old_value,
+			video_events.new_value,
+			video_events.undoes,
+			video_events.created_at
+		from
+			video_events
+			join videos on videos.id = video_events.video_id
+		where
+			`+where+`
+		order by
+			video_events.created_at desc,
+			video_events.id desc
+		limit ? offset ?
+		`,
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []VideoEvent{}
+	for rows.Next() {
+		var event VideoEvent
+		err = rows.Scan(
+			&event.Id,
+			&event.VideoId,
+			&event.Filename,
+			&event.Kind,
+			&event.OldValue,
+			&event.NewValue,
+			&event.Undoes,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, event)
+	}
+
+	return events, total, rows.Err()
+}
+
+// LastStatusChange returns the newest status change of the video that can be
+// undone, undos and the changes they reverted are skipped so undoing again
+// goes further back.
+func (repo VideoRepository) LastStatusChange(videoId int32) (*VideoEvent, error) {
+	events, _, err := repo.listEvents(
+		`
+		video_events.video_id = ?
+		and video_events.kind = ?
+		and video_events.undoes is null
+		and not exists (select 1 from video_events as undo where undo.undoes = video_events.id)
+		`,
+		[]any{videoId, EventStatus},
+		1,
+		0,
+	)
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+
+	return &events[0], nil
+}
+
+// recordVideoChanges compares the stored video with its new version and logs
+// an event for each changed field, the status event is linked to the event it
+// undoes when not zero. It must run before the update statement.
+func recordVideoChanges(tx *sql.Tx, video Video, undoes int64) error {
+	rows, err := tx.Query("select "+videoColumns+" from videos where id = ?", video.Id)
+	if err != nil {
+		return err
+	}
+
+	if !rows.Next() {
+		rows.Close()
+		return rows.Err()
+	}
+
+	current, err := readVideoFromRow(rows)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	insert := func(kind VideoEventKind, oldValue any, newValue any, undoes sql.NullInt64) error {
+		return insertVideoEvent(tx, video.Id, kind, oldValue, newValue, undoes, now)
+	}
+
+	if current.Status != video.Status {
+		err = insert(
+			EventStatus,
+			strconv.Itoa(int(current.Status)),
+			strconv.Itoa(int(video.Status)),
+			sql.NullInt64{Int64: undoes, Valid: undoes != 0},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	oldNickname := nullableNickname(current.Nickname)
+	newNickname := nullableNickname(video.Nickname)
+	if oldNickname != newNickname {
+		if err = insert(EventNickname, oldNickname, newNickname, sql.NullInt64{}); err != nil {
+			return err
+		}
+	}
+
+	newTags := video.Tags
+	if newTags == nil {
+		newTags = []string{}
+	}
+
+	if !slices.Equal(current.Tags, newTags) {
+		oldValue, err := json.Marshal(current.Tags)
+		if err != nil {
+			return err
+		}
+
+		newValue, err := json.Marshal(newTags)
+		if err != nil {
+			return err
+		}
+
+		if err = insert(EventTags, string(oldValue), string(newValue), sql.NullInt64{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// videoTagLists reads the tags of the videos matching the filter, so the tag
+// events of changes made straight to the tag tables can be recorded.
+func videoTagLists(tx *sql.Tx, filter string, args ...any) (map[int32][]string, error) {
+	rows, err := tx.Query(
+		`
+		select
+			videos.id,
+			(
+				select
+					json_group_array(tags.name order by video_tags.rowid)
+				from
+					video_tags
+					join tags on tags.id = video_tags.tag_id
+				where
+					video_tags.video_id = videos.id
+			)
+		from
+			videos
+		where
+			`+filter,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := map[int32][]string{}
+	for rows.Next() {
+		var id int32
+		var tags string
+		if err = rows.Scan(&id, &tags); err != nil {
+			return nil, err
+		}
+
+		var list []string
+		if err = json.Unmarshal([]byte(tags), &list); err != nil {
+			return nil, err
+		}
+		lists[id] = list
+	}
+
+	return lists, rows.Err()
+}
+
+// recordTagChanges logs a tag event for each video whose tags changed since
+// they were read by videoTagLists.
+func recordTagChanges(tx *sql.Tx, before map[int32][]string) error {
+	now := time.Now().UTC()
+	for videoId, oldTags := range before {
+		current, err := videoTagLists(tx, "id = ?", videoId)
+		if err != nil {
+			return err
+		}
+
+		newTags := current[videoId]
+		if newTags == nil {
+			newTags = []string{}
+		}
+
+		if slices.Equal(oldTags, newTags) {
+			continue
+		}
+
+		oldValue, err := json.Marshal(oldTags)
+		if err != nil {
+			return err
+		}
+
+		newValue, err := json.Marshal(newTags)
+		if err != nil {
+			return err
+		}
+
+		err = insertVideoEvent(tx, videoId, EventTags, string(oldValue), string(newValue), sql.NullInt64{}, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func insertVideoEvent(tx *sql.Tx, videoId int32, kind VideoEventKind, oldValue any, newValue any, undoes sql.NullInt64, createdAt time.Time) error {
+	_, err := tx.Exec(
+		`
+		insert into video_events
+			(video_id, kind, old_value, new_value, undoes, created_at)
+		values
+			(?, ?, ?, ?, ?, ?)
+		`,
+		videoId,
+		kind,
+		oldValue,
+		newValue,
+		undoes,
+		createdAt,
+	)
+	return err
+}
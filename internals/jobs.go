@@ -0,0 +1,177 @@
+package internals
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// jobRetention is how long a finished job can still be looked up.
+const jobRetention = time.Hour
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job already finished")
+)
+
+type Job struct {
+	Id         int64      `json:"id"`
+	Kind       string     `json:"kind"`
+	State      JobState   `json:"state"`
+	Progress   any        `json:"progress"`
+	Result     any        `json:"result"`
+	Error      NullString `json:"error"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+type JobResponse struct {
+	Job Job `json:"job"`
+}
+
+// JobFunc does the work of a job. It should stop when the context is cancelled,
+// and can report its progress as often as it likes.
+type JobFunc func(ctx context.Context, progress func(any)) (any, error)
+
+type jobEntry struct {
+	job    Job
+	key    string
+	cancel context.CancelFunc
+}
+
+// JobManager runs long operations in the background. Jobs that share a key
+// never run at the same time.
+type JobManager struct {
+	mu     *sync.Mutex
+	lastId int64
+	jobs   map[int64]*jobEntry
+	keys   map[string]int64
+}
+
+func NewJobManager() *JobManager {
+	return &JobManager{
+		mu:   &sync.Mutex{},
+		jobs: map[int64]*jobEntry{},
+		keys: map[string]int64{},
+	}
+}
+
+// Start runs the job in the background, unless a job with the same key is
+// running, in which case that job is returned and started is false.
+func (manager *JobManager) Start(kind string, key string, run JobFunc) (job Job, started bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.prune()
+
+	if id, found := manager.keys[key]; found && key != "" {
+		return manager.jobs[id].job, false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	manager.lastId++
+	entry := &jobEntry{
+		job: Job{
+			Id:        manager.lastId,
+			Kind:      kind,
+			State:     JobRunning,
+			StartedAt: time.Now(),
+		},
+		key:    key,
+		cancel: cancel,
+	}
+
+	manager.jobs[entry.job.Id] = entry
+	if key != "" {
+		manager.keys[key] = entry.job.Id
+	}
+
+	go manager.run(ctx, entry, run)
+
+	return entry.job, true
+}
+
+func (manager *JobManager) run(ctx context.Context, entry *jobEntry, run JobFunc) {
+	result, err := run(ctx, func(progress any) {
+		manager.mu.Lock()
+		entry.job.Progress = progress
+		manager.mu.Unlock()
+	})
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	finishedAt := time.Now()
+	entry.job.FinishedAt = &finishedAt
+	entry.job.Result = result
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		entry.job.State = JobCancelled
+	case err != nil:
+		entry.job.State = JobFailed
+		entry.job.Error = NullString{String: err.Error(), Valid: true}
+	default:
+		entry.job.State = JobSucceeded
+	}
+
+	if entry.key != "" {
+		delete(manager.keys, entry.key)
+	}
+
+	entry.cancel()
+}
+
+func (manager *JobManager) Find(id int64) (*Job, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	entry, found := manager.jobs[id]
+	if !found {
+		return nil, ErrJobNotFound
+	}
+
+	job := entry.job
+	return &job, nil
+}
+
+// Cancel asks a running job to stop, it is marked as cancelled once its
+// function returns.
+func (manager *JobManager) Cancel(id int64) (*Job, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	entry, found := manager.jobs[id]
+	if !found {
+		return nil, ErrJobNotFound
+	}
+
+	if entry.job.State != JobRunning {
+		return nil, ErrJobFinished
+	}
+
+	entry.cancel()
+
+	job := entry.job
+	return &job, nil
+}
+
+// prune forgets the jobs that finished a while ago, the lock must be held.
+func (manager *JobManager) prune() {
+	for id, entry := range manager.jobs {
+		if entry.job.FinishedAt != nil && time.Since(*entry.job.FinishedAt) > jobRetention {
+			delete(manager.jobs, id)
+		}
+	}
+}
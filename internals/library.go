@@ -0,0 +1,116 @@
+package internals
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultLibraryName is the library of the top level video_folder config, it
+// also holds every video scanned before libraries existed.
+const DefaultLibraryName = "default"
+
+var ErrLibraryNotFound = errors.New("library not found")
+
+// Library is a video folder with its own queue. They are configured in the ini
+// file with a [library.<name>] section, the id is assigned by the repository.
+type Library struct {
+	Id            int32  `json:"id" ini:"-"`
+	Name          string `json:"name" ini:"-"`
+	VideoFolder   string `json:"-" ini:"video_folder"`
+	RecursiveScan bool   `json:"-" ini:"recursive_scan"`
+	MaxDepth      int    `json:"-" ini:"max_depth"`
+}
+
+type LibraryListResponse struct {
+	Libraries []Library `json:"libraries"`
+}
+
+func (repo VideoRepository) Libraries() []Library {
+	return repo.libraries
+}
+
+func (repo VideoRepository) FindLibrary(id int32) (*Library, error) {
+	for _, library := range repo.libraries {
+		if library.Id == id {
+			return &library, nil
+		}
+	}
+
+	return nil, ErrLibraryNotFound
+}
+
+func (repo VideoRepository) FindLibraryByName(name string) (*Library, error) {
+	for _, library := range repo.libraries {
+		if strings.EqualFold(library.Name, name) {
+			return &library, nil
+		}
+	}
+
+	return nil, ErrLibraryNotFound
+}
+
+// syncLibraries makes sure every configured library has a row, and fills in
+// their ids.
+func (repo *VideoRepository) syncLibraries(libraries []Library) error {
+	for _, library := range libraries {
+		_, err := repo.db.Exec(
+			"insert into libraries (name) values (?) on conflict (name) do nothing",
+			library.Name,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = repo.db.QueryRow("select id from libraries where name = ?", library.Name).Scan(&library.Id)
+		if err != nil {
+			return err
+		}
+
+		repo.libraries = append(repo.libraries, library)
+	}
+
+	return repo.checkDroppedLibraries()
+}
+
+// checkDroppedLibraries fails when a library that is no longer configured
+// still has videos, their files couldn't be found while they stay in the
+// queues.
+func (repo VideoRepository) checkDroppedLibraries() error {
+	rows, err := repo.db.Query(`
+		select
+			libraries.id,
+			libraries.name,
+			count(videos.id)
+		from
+			libraries
+			join videos on videos.library_id = libraries.id
+		group by
+			libraries.id
+		order by
+			libraries.id
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int32
+		var name string
+		var quantity int
+		if err = rows.Scan(&id, &name, &quantity); err != nil {
+			return err
+		}
+
+		if _, err = repo.FindLibrary(id); errors.Is(err, ErrLibraryNotFound) {
+			if name == DefaultLibraryName {
+				return fmt.Errorf("library \"%v\" still has %v videos but is not configured. Set the top level video_folder or add a [library.%v] section with its folder", name, quantity, name)
+			}
+
+			return fmt.Errorf("library \"%v\" still has %v videos but is not configured. Add a [library.%v] section with its folder", name, quantity, name)
+		}
+	}
+
+	return rows.Err()
+}
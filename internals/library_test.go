@@ -0,0 +1,63 @@
+//go:build sqlite_fts5
+
+package internals
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestNewRepositoryRejectsDroppedLibrary(t *testing.T) {
+	database := filepath.Join(t.TempDir(), "videos.db")
+	folder := t.TempDir()
+
+	repo, err := NewRepository(Config{
+		Database:  database,
+		Libraries: []Library{{Name: DefaultLibraryName, VideoFolder: folder}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.ImportFsEntries(repo.Libraries()[0].Id, []VideoFsEntry{
+		{Filename: "Show - 01.mkv", LastModifiedTime: time.Now()},
+	})
+	repo.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err = NewRepository(Config{
+		Database: database,
+		Libraries: []Library{
+			{Name: DefaultLibraryName, VideoFolder: folder},
+			{Name: "movies", VideoFolder: t.TempDir()},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	// an empty library can be dropped, one with videos can't
+	repo, err = NewRepository(Config{
+		Database:  database,
+		Libraries: []Library{{Name: DefaultLibraryName, VideoFolder: folder}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	repo, err = NewRepository(Config{
+		Database:  database,
+		Libraries: []Library{{Name: "anime", VideoFolder: folder}},
+	})
+	if err == nil {
+		repo.Close()
+		t.Fatal("NewRepository accepted a config without the library of the videos")
+	}
+}
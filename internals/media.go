@@ -0,0 +1,162 @@
+package internals
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"go-video-viewer/internals/probe"
+	"log"
+	"os"
+	"time"
+)
+
+// mediaRecord is a cached probe of a video file. It is stale once the size or
+// modification time of the file changes. Files that couldn't be probed keep
+// the error, so they aren't probed again until they change.
+type mediaRecord struct {
+	FileSize   int64
+	ModifiedAt time.Time
+	Info       *probe.Info
+	Error      sql.NullString
+}
+
+func (repo VideoRepository) FindMedia(videoId int32) (*mediaRecord, error) {
+	var record mediaRecord
+	var info sql.NullString
+
+	err := repo.db.QueryRow(
+		"select file_size, modified_at, info, error from video_media where video_id = ?",
+		videoId,
+	).Scan(&record.FileSize, &record.ModifiedAt, &info, &record.Error)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Valid {
+		if err = json.Unmarshal([]byte(info.String), &record.Info); err != nil {
+			return nil, err
+		}
+	}
+
+	return &record, nil
+}
+
+func (repo VideoRepository) SaveMedia(videoId int32, record mediaRecord) error {
+	var info sql.NullString
+	if record.Info != nil {
+		data, err := json.Marshal(record.Info)
+		if err != nil {
+			return err
+		}
+
+		info = sql.NullString{String: string(data), Valid: true}
+	}
+
+	_, err := repo.db.Exec(
+		`
+		insert or replace into video_media
+			(video_id, file_size, modified_at, probed_at, info, error)
+		values
+			(?, ?, ?, ?, ?, ?)
+		`,
+		videoId,
+		record.FileSize,
+		record.ModifiedAt.UTC(),
+		time.Now().UTC(),
+		info,
+		record.Error,
+	)
+	return err
+}
+
+// PendingMedia lists the videos of the library whose file wasn't probed yet or
+// changed size since. Truncated and missing files are left out.
+func (repo VideoRepository) PendingMedia(libraryId int32) ([]Video, error) {
+	return repo.queryVideos(
+		`
+		select
+			`+videoColumns+`
+		from
+			videos
+			left join video_media on video_media.video_id = videos.id
+		where
+			videos.library_id = ?
+			and videos.file_size > 0
+			and videos.missing_since is null
+			and (video_media.video_id is null or video_media.file_size != videos.file_size)
+		order by
+			videos.id
+		`,
+		libraryId,
+	)
+}
+
+// VideoMedia returns the probe of the video file, probing it when the cache is
+// missing or stale. It is nil when the file is truncated or isn't a container
+// the probe understands.
+func (app App) VideoMedia(video Video) (*probe.Info, error) {
+	path, err := app.VideoPath(video)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if stat.Size() == 0 {
+		return nil, nil
+	}
+
+	cached, err := app.Repo.FindMedia(video.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached != nil && cached.FileSize == stat.Size() && cached.ModifiedAt.Equal(stat.ModTime()) {
+		return cached.Info, nil
+	}
+
+	record := mediaRecord{FileSize: stat.Size(), ModifiedAt: stat.ModTime()}
+	info, err := probe.File(path)
+	if err != nil {
+		log.Printf("Failed to probe '%v': %v", video.Filename, err)
+		record.Error = sql.NullString{String: err.Error(), Valid: true}
+	} else {
+		record.Info = &info
+	}
+
+	if err = app.Repo.SaveMedia(video.Id, record); err != nil {
+		return nil, err
+	}
+
+	return record.Info, nil
+}
+
+// probeLibraryMedia probes the files of the library that weren't probed yet,
+// so the media of the videos is ready before they are opened.
+func (app App) probeLibraryMedia(ctx context.Context, library Library) error {
+	videos, err := app.Repo.PendingMedia(library.Id)
+	if err != nil {
+		return err
+	}
+
+	for _, video := range videos {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		if _, err = app.VideoMedia(video); err != nil {
+			// the file may be gone by now, the next scan will notice
+			log.Printf("Failed to read the media of '%v': %v", video.Filename, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package internals
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var ErrPathOutsideFolder = errors.New("path is outside of the video folder")
+
+// resolveInFolder joins a slash separated relative name to the folder, refusing
+// names that climb out of it, either with ".." or through symlinks. Files that
+// don't exist can only be checked lexically.
+func resolveInFolder(folder string, name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if !filepath.IsLocal(name) {
+		return "", ErrPathOutsideFolder
+	}
+
+	joined := filepath.Join(folder, name)
+
+	resolved, err := filepath.EvalSymlinks(joined)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return joined, nil
+		}
+
+		return "", err
+	}
+
+	root, err := filepath.EvalSymlinks(folder)
+	if err != nil {
+		return "", err
+	}
+
+	relative, err := filepath.Rel(root, resolved)
+	if err != nil || !filepath.IsLocal(relative) {
+		return "", ErrPathOutsideFolder
+	}
+
+	return joined, nil
+}
@@ -0,0 +1,88 @@
+package internals
+
+import (
+	"fmt"
+	"go-video-viewer/internals/probe"
+	"go-video-viewer/internals/remux"
+	"path"
+	"slices"
+	"strings"
+)
+
+// PlaybackMode is how the browser gets to play a video.
+type PlaybackMode string
+
+const (
+	// PlaybackDirect serves the file as it is
+	PlaybackDirect PlaybackMode = "direct"
+	// PlaybackRemux rewrites Matroska files as MP4 while they play
+	PlaybackRemux PlaybackMode = "remux"
+	// PlaybackTranscode converts the file to HLS with ffmpeg while it plays
+	PlaybackTranscode PlaybackMode = "transcode"
+	// PlaybackUnsupported means the browser likely can't play the file and
+	// ffmpeg isn't configured, it's served as it is anyway
+	PlaybackUnsupported PlaybackMode = "unsupported"
+)
+
+// Playback tells the player how to play a video and where from.
+type Playback struct {
+	Mode PlaybackMode `json:"mode"`
+	Url  string       `json:"url"`
+}
+
+// directContainers are the extensions of the files browsers play, as long as
+// their codecs are supported too.
+var directContainers = []string{".mp4", ".m4v", ".mov", ".webm", ".mkv", ".ogv"}
+
+var directVideoCodecs = []string{"h264", "vp8", "vp9", "av1", "theora"}
+
+var directAudioCodecs = []string{"aac", "mp3", "opus", "vorbis", "flac"}
+
+// VideoPlayback decides whether the video plays directly or needs to be
+// remuxed or transcoded, from its extension and the codecs of its media.
+// Matroska files are remuxed when they can be, since not every browser plays
+// them, once their stream is indexed. Until then they play like other files.
+func (app App) VideoPlayback(video Video, media *probe.Info) Playback {
+	if media != nil && media.Container == "matroska" && remux.Supported(*media) && app.remuxReady(video) {
+		return Playback{Mode: PlaybackRemux, Url: fmt.Sprintf("/api/video/%v/stream.mp4", video.Id)}
+	}
+
+	if directPlayable(video.Filename, media) {
+		return Playback{Mode: PlaybackDirect, Url: fmt.Sprintf("/api/video/%v/serve", video.Id)}
+	}
+
+	if app.Config.FFmpeg != "" {
+		return Playback{Mode: PlaybackTranscode, Url: fmt.Sprintf("/api/video/%v/hls/index.m3u8", video.Id)}
+	}
+
+	return Playback{Mode: PlaybackUnsupported, Url: fmt.Sprintf("/api/video/%v/serve", video.Id)}
+}
+
+// directPlayable checks the codecs of the main video and audio tracks. Files
+// the probe couldn't read are left to the browser.
+func directPlayable(filename string, media *probe.Info) bool {
+	if !slices.Contains(directContainers, strings.ToLower(path.Ext(filename))) {
+		return false
+	}
+
+	if media == nil {
+		return true
+	}
+
+	if media.VideoCodec != "" && !slices.Contains(directVideoCodecs, media.VideoCodec) {
+		return false
+	}
+
+	var audio *probe.Track
+	for i, track := range media.Tracks {
+		if track.Type != probe.TrackAudio {
+			continue
+		}
+
+		if audio == nil || (track.Default && !audio.Default) {
+			audio = &media.Tracks[i]
+		}
+	}
+
+	return audio == nil || slices.Contains(directAudioCodecs, audio.Codec)
+}
@@ -0,0 +1,124 @@
+package probe
+
+import (
+	"errors"
+	"io"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	idAttachedFile = 0x61A7
+	idFileName     = 0x466E
+	idFileMimeType = 0x4660
+	idFileData     = 0x465C
+)
+
+var ErrNoCoverArt = errors.New("the file has no cover art")
+
+// coverNames are the names the Matroska specification gives to cover art, from
+// the most to the least fitting for a thumbnail.
+var coverNames = []string{"cover_land", "small_cover_land", "cover", "small_cover"}
+
+// attachment is a file attached to a Matroska file, its data is only read
+// once it's picked.
+type attachment struct {
+	name     string
+	mimeType string
+	data     ebmlElement
+}
+
+// CoverArt returns the cover art attached to a Matroska file and its mime type.
+// Attachments named like covers come first, then any other image, while fonts
+// and the rest are skipped without being read.
+func CoverArt(r io.ReadSeeker, size int64) ([]byte, string, error) {
+	segment, err := readSegment(r, size)
+	if err != nil {
+		return nil, "", err
+	}
+
+	position, found := segment.positions[idAttachments]
+	if !found {
+		return nil, "", ErrNoCoverArt
+	}
+
+	reader := segment.reader
+	element, err := reader.headerAt(position)
+	if err != nil || element.id != idAttachments || element.size == unknownSize {
+		return nil, "", ErrNoCoverArt
+	}
+
+	var chosen *attachment
+	rank := len(coverNames) + 1
+
+	for offset := element.offset; offset < element.end(); {
+		child, err := reader.headerAt(offset)
+		if err != nil || child.size == unknownSize {
+			break
+		}
+		offset = child.end()
+
+		if child.id != idAttachedFile {
+			continue
+		}
+
+		file, err := readAttachment(reader, child)
+		if err != nil || !strings.HasPrefix(file.mimeType, "image/") {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSuffix(file.name, path.Ext(file.name)))
+		fileRank := slices.Index(coverNames, name)
+		if fileRank < 0 {
+			fileRank = len(coverNames)
+		}
+
+		if fileRank < rank {
+			chosen, rank = &file, fileRank
+		}
+	}
+
+	if chosen == nil {
+		return nil, "", ErrNoCoverArt
+	}
+
+	data, err := reader.data(chosen.data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, chosen.mimeType, nil
+}
+
+// readAttachment reads the name and mime type of an attached file, along with
+// where its data is.
+func readAttachment(reader *seekReader, element ebmlElement) (attachment, error) {
+	var file attachment
+
+	for offset := element.offset; offset < element.end(); {
+		child, err := reader.headerAt(offset)
+		if err != nil || child.size == unknownSize {
+			return file, errInvalidBlock
+		}
+		offset = child.end()
+
+		switch child.id {
+		case idFileName, idFileMimeType:
+			data, err := reader.read(child.size)
+			if err != nil {
+				return file, err
+			}
+
+			if child.id == idFileName {
+				file.name = ebmlString(data)
+			} else {
+				file.mimeType = strings.ToLower(ebmlString(data))
+			}
+		case idFileData:
+			file.data = child
+		}
+	}
+
+	return file, nil
+}
@@ -0,0 +1,88 @@
+package probe
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+const (
+	idCuePoint           = 0xBB
+	idCueTime            = 0xB3
+	idCueTrackPositions  = 0xB7
+	idCueTrack           = 0xF7
+	idCueClusterPosition = 0xF1
+)
+
+// CuePoint is a keyframe of a track the file can be played from, in the
+// cluster at the offset.
+type CuePoint struct {
+	Time    time.Duration
+	Track   int
+	Cluster int64
+}
+
+// Cues reads the index of the keyframes, sorted by time. Files without one
+// have no cues.
+func (demuxer *Demuxer) Cues() []CuePoint {
+	position, found := demuxer.segment.positions[idCues]
+	if !found {
+		return nil
+	}
+
+	element, err := demuxer.reader.headerAt(position)
+	if err != nil || element.id != idCues || element.size == unknownSize {
+		return nil
+	}
+
+	data, err := demuxer.reader.data(element)
+	if err != nil {
+		return nil
+	}
+
+	var cues []CuePoint
+
+	points, _ := ebmlChildren(data)
+	for _, point := range points {
+		if point.id != idCuePoint {
+			continue
+		}
+
+		var ticks int64
+		var positions []CuePoint
+
+		fields, _ := ebmlChildren(point.data)
+		for _, field := range fields {
+			switch field.id {
+			case idCueTime:
+				ticks = int64(ebmlUint(field.data))
+			case idCueTrackPositions:
+				cue := CuePoint{Cluster: -1}
+				values, _ := ebmlChildren(field.data)
+				for _, value := range values {
+					switch value.id {
+					case idCueTrack:
+						cue.Track = int(ebmlUint(value.data))
+					case idCueClusterPosition:
+						cue.Cluster = demuxer.segment.offset + int64(ebmlUint(value.data))
+					}
+				}
+
+				if cue.Cluster >= 0 {
+					positions = append(positions, cue)
+				}
+			}
+		}
+
+		for _, cue := range positions {
+			cue.Time = time.Duration(ticks) * demuxer.scale
+			cues = append(cues, cue)
+		}
+	}
+
+	slices.SortStableFunc(cues, func(a, b CuePoint) int {
+		return cmp.Compare(a.Time, b.Time)
+	})
+
+	return cues
+}
@@ -0,0 +1,460 @@
+package probe
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	errInvalidBlock      = errors.New("invalid Matroska block")
+	errEncryptedTrack    = errors.New("encrypted Matroska tracks aren't supported")
+	errUnknownCompressed = errors.New("unknown Matroska track compression")
+)
+
+// Matroska compression algorithms of the tracks.
+const (
+	compressionZlib   = 0
+	compressionHeader = 3
+)
+
+// TrackEntry is a Matroska track along with what's needed to read its frames.
+type TrackEntry struct {
+	Track
+	CodecPrivate []byte
+	// DefaultDuration is the duration of each frame, when they all last the same
+	DefaultDuration time.Duration
+	encodings       []contentEncoding
+}
+
+// contentEncoding is a transformation applied to the frames of a track, only
+// compression is supported.
+type contentEncoding struct {
+	encrypted   bool
+	algorithm   uint64
+	settings    []byte
+	framesScope bool
+}
+
+// Block holds the frames of a track that start at the same time. Laced blocks
+// have more than one frame.
+type Block struct {
+	Track int
+	// Cluster is the offset of the cluster holding the block
+	Cluster  int64
+	Time     time.Duration
+	Duration time.Duration
+	Keyframe bool
+	Frames   [][]byte
+}
+
+// Demuxer reads the blocks of a Matroska file in the order they are stored.
+type Demuxer struct {
+	segment *matroskaSegment
+	reader  *seekReader
+	scale   time.Duration
+	tracks  map[int]TrackEntry
+	// selected is nil when every track is read
+	selected    map[int]bool
+	offset      int64
+	inCluster   bool
+	cluster     int64
+	clusterEnd  int64
+	clusterTime int64
+}
+
+// OpenMatroska reads the headers of a Matroska file, leaving the demuxer at its
+// first cluster.
+func OpenMatroska(r io.ReadSeeker, size int64) (*Demuxer, error) {
+	segment, err := readSegment(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	scale, _ := readSegmentInfo(segment.headers[idInfo])
+	demuxer := &Demuxer{
+		segment: segment,
+		reader:  segment.reader,
+		scale:   time.Duration(scale),
+		tracks:  map[int]TrackEntry{},
+		offset:  segment.firstCluster,
+	}
+
+	for _, entry := range readTracks(segment.headers[idTracks]) {
+		demuxer.tracks[entry.Number] = entry
+	}
+
+	if demuxer.offset < 0 {
+		demuxer.offset = segment.end
+	}
+
+	return demuxer, nil
+}
+
+func (demuxer *Demuxer) Tracks() []TrackEntry {
+	return readTracks(demuxer.segment.headers[idTracks])
+}
+
+// Duration is the duration of the segment, zero when it isn't known.
+func (demuxer *Demuxer) Duration() time.Duration {
+	_, duration := readSegmentInfo(demuxer.segment.headers[idInfo])
+	return time.Duration(duration * float64(demuxer.scale))
+}
+
+// SeekCluster moves the demuxer to the cluster at the offset, like the ones of
+// the cues.
+func (demuxer *Demuxer) SeekCluster(cluster int64) {
+	demuxer.offset = cluster
+	demuxer.inCluster = false
+}
+
+// Select limits the blocks to the ones of the tracks, the others are skipped
+// without being read.
+func (demuxer *Demuxer) Select(tracks ...int) {
+	demuxer.selected = map[int]bool{}
+	for _, track := range tracks {
+		demuxer.selected[track] = true
+	}
+}
+
+// Next returns the next block of the selected tracks, or io.EOF after the last
+// one.
+func (demuxer *Demuxer) Next() (Block, error) {
+	for {
+		if demuxer.offset >= demuxer.segment.end {
+			return Block{}, io.EOF
+		}
+
+		if demuxer.inCluster && demuxer.offset >= demuxer.clusterEnd {
+			demuxer.inCluster = false
+		}
+
+		element, err := demuxer.reader.headerAt(demuxer.offset)
+		if err != nil {
+			// a truncated file ends where its data does
+			return Block{}, io.EOF
+		}
+
+		// clusters of unknown size end where the next top level element starts
+		if isTopLevel(element.id) {
+			demuxer.inCluster = false
+		}
+
+		if !demuxer.inCluster {
+			if element.id != idCluster {
+				if element.size == unknownSize {
+					return Block{}, io.EOF
+				}
+
+				demuxer.offset = element.end()
+				continue
+			}
+
+			demuxer.inCluster = true
+			demuxer.cluster = demuxer.offset
+			demuxer.clusterTime = 0
+			demuxer.clusterEnd = demuxer.segment.end
+			if element.size != unknownSize {
+				demuxer.clusterEnd = min(element.end(), demuxer.segment.end)
+			}
+
+			demuxer.offset = element.offset
+			continue
+		}
+
+		if element.size == unknownSize {
+			return Block{}, errInvalidBlock
+		}
+		demuxer.offset = element.end()
+
+		switch element.id {
+		case idTimestamp:
+			data, err := demuxer.reader.read(element.size)
+			if err != nil {
+				return Block{}, err
+			}
+			demuxer.clusterTime = int64(ebmlUint(data))
+		case idSimpleBlock:
+			block, wanted, err := demuxer.readBlock(element.size, true)
+			if err != nil {
+				return Block{}, err
+			}
+
+			if wanted {
+				return block, nil
+			}
+		case idBlockGroup:
+			block, wanted, err := demuxer.readBlockGroup(element)
+			if err != nil {
+				return Block{}, err
+			}
+
+			if wanted {
+				return block, nil
+			}
+		}
+	}
+}
+
+func isTopLevel(id uint32) bool {
+	switch id {
+	case idCluster, idCues, idAttachments, idTags, idChapters, idSeekHead, idInfo, idTracks:
+		return true
+	default:
+		return false
+	}
+}
+
+// readBlock reads a block of the given size at the position of the reader. The
+// data of the tracks that weren't selected isn't read.
+func (demuxer *Demuxer) readBlock(size int64, simple bool) (Block, bool, error) {
+	track, trackLength, err := readVint(demuxer.reader, false)
+	if err != nil {
+		return Block{}, false, err
+	}
+
+	entry, found := demuxer.tracks[int(track)]
+	if !found || (demuxer.selected != nil && !demuxer.selected[int(track)]) {
+		return Block{}, false, nil
+	}
+
+	data, err := demuxer.reader.read(size - int64(trackLength))
+	if err != nil {
+		return Block{}, false, err
+	}
+
+	if len(data) < 3 {
+		return Block{}, false, errInvalidBlock
+	}
+
+	relative := int16(binary.BigEndian.Uint16(data))
+	flags := data[2]
+
+	block := Block{
+		Track:    int(track),
+		Cluster:  demuxer.cluster,
+		Time:     time.Duration(demuxer.clusterTime+int64(relative)) * demuxer.scale,
+		Keyframe: !simple || flags&0x80 != 0,
+	}
+
+	block.Frames, err = unlace(data[3:], flags)
+	if err != nil {
+		return Block{}, false, err
+	}
+
+	for i, frame := range block.Frames {
+		if block.Frames[i], err = entry.decode(frame); err != nil {
+			return Block{}, false, err
+		}
+	}
+
+	if entry.DefaultDuration > 0 {
+		block.Duration = entry.DefaultDuration * time.Duration(len(block.Frames))
+	}
+
+	return block, true, nil
+}
+
+// readBlockGroup reads the block of a group along with its duration. Blocks
+// that reference others aren't keyframes.
+func (demuxer *Demuxer) readBlockGroup(group ebmlElement) (Block, bool, error) {
+	var block Block
+	var wanted bool
+	var duration int64 = -1
+	references := false
+
+	for offset := group.offset; offset < group.end(); {
+		element, err := demuxer.reader.headerAt(offset)
+		if err != nil || element.size == unknownSize {
+			return Block{}, false, errInvalidBlock
+		}
+		offset = element.end()
+
+		switch element.id {
+		case idBlock:
+			block, wanted, err = demuxer.readBlock(element.size, false)
+			if err != nil {
+				return Block{}, false, err
+			}
+
+			if !wanted {
+				return Block{}, false, nil
+			}
+		case idBlockDuration:
+			data, err := demuxer.reader.read(element.size)
+			if err != nil {
+				return Block{}, false, err
+			}
+			duration = int64(ebmlUint(data))
+		case idReferenceBlock:
+			references = true
+		}
+	}
+
+	if duration >= 0 {
+		block.Duration = time.Duration(duration) * demuxer.scale
+	}
+	block.Keyframe = !references
+
+	return block, wanted, nil
+}
+
+// unlace splits the frames of a block according to its lacing, which is Xiph,
+// fixed size or EBML.
+func unlace(data []byte, flags byte) ([][]byte, error) {
+	lacing := flags >> 1 & 0x03
+	if lacing == 0 {
+		return [][]byte{data}, nil
+	}
+
+	if len(data) < 1 {
+		return nil, errInvalidBlock
+	}
+
+	count := int(data[0]) + 1
+	data = data[1:]
+	sizes := make([]int, count)
+
+	switch lacing {
+	case 1:
+		for i := 0; i < count-1; i++ {
+			for {
+				if len(data) == 0 {
+					return nil, errInvalidBlock
+				}
+
+				value := data[0]
+				data = data[1:]
+				sizes[i] += int(value)
+				if value != 255 {
+					break
+				}
+			}
+		}
+	case 2:
+		for i := range sizes {
+			sizes[i] = len(data) / count
+		}
+	case 3:
+		reader := bytes.NewReader(data)
+		first, _, err := readVint(reader, false)
+		if err != nil {
+			return nil, errInvalidBlock
+		}
+
+		sizes[0] = int(first)
+		for i := 1; i < count-1; i++ {
+			value, length, err := readVint(reader, false)
+			if err != nil {
+				return nil, errInvalidBlock
+			}
+
+			// the differences are signed, stored with a bias
+			difference := int64(value) - (int64(1)<<(7*length-1) - 1)
+			sizes[i] = sizes[i-1] + int(difference)
+		}
+
+		data = data[len(data)-reader.Len():]
+	}
+
+	if lacing != 2 {
+		used := 0
+		for _, size := range sizes[:count-1] {
+			if size < 0 {
+				return nil, errInvalidBlock
+			}
+			used += size
+		}
+
+		sizes[count-1] = len(data) - used
+		if sizes[count-1] < 0 {
+			return nil, errInvalidBlock
+		}
+	}
+
+	frames := make([][]byte, count)
+	for i, size := range sizes {
+		if size > len(data) {
+			return nil, errInvalidBlock
+		}
+
+		frames[i] = data[:size]
+		data = data[size:]
+	}
+
+	return frames, nil
+}
+
+func readContentEncodings(data []byte) []contentEncoding {
+	var encodings []contentEncoding
+
+	elements, _ := ebmlChildren(data)
+	for _, element := range elements {
+		if element.id != idContentEncoding {
+			continue
+		}
+
+		encoding := contentEncoding{framesScope: true}
+		fields, _ := ebmlChildren(element.data)
+		for _, field := range fields {
+			switch field.id {
+			case idContentEncodingScope:
+				encoding.framesScope = ebmlUint(field.data)&1 != 0
+			case idContentEncodingType:
+				encoding.encrypted = ebmlUint(field.data) == 1
+			case idContentCompression:
+				settings, _ := ebmlChildren(field.data)
+				for _, setting := range settings {
+					switch setting.id {
+					case idContentCompressionAlgo:
+						encoding.algorithm = ebmlUint(setting.data)
+					case idContentCompressionExtra:
+						encoding.settings = setting.data
+					}
+				}
+			}
+		}
+
+		encodings = append(encodings, encoding)
+	}
+
+	return encodings
+}
+
+// decode undoes the compression of a frame, the encodings being applied in
+// the reverse order they are listed.
+func (entry TrackEntry) decode(frame []byte) ([]byte, error) {
+	for i := len(entry.encodings) - 1; i >= 0; i-- {
+		encoding := entry.encodings[i]
+		if !encoding.framesScope {
+			continue
+		}
+
+		if encoding.encrypted {
+			return nil, errEncryptedTrack
+		}
+
+		switch encoding.algorithm {
+		case compressionZlib:
+			reader, err := zlib.NewReader(bytes.NewReader(frame))
+			if err != nil {
+				return nil, err
+			}
+
+			frame, err = io.ReadAll(io.LimitReader(reader, maxElementSize))
+			reader.Close()
+			if err != nil {
+				return nil, err
+			}
+		case compressionHeader:
+			frame = append(append([]byte{}, encoding.settings...), frame...)
+		default:
+			return nil, errUnknownCompressed
+		}
+	}
+
+	return frame, nil
+}
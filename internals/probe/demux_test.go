@@ -0,0 +1,204 @@
+package probe
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// simpleBlock writes a block of a track below 127, at a time relative to its
+// cluster.
+func simpleBlock(id uint32, track byte, relative int16, flags byte, data ...byte) []byte {
+	return element(id, append([]byte{0x80 | track, byte(relative >> 8), byte(relative), flags}, data...))
+}
+
+func TestDemuxer(t *testing.T) {
+	head := bytes.Join([][]byte{
+		element(idInfo, uintElement(idTimestampScale, 1000000)),
+		testTracks(),
+	}, nil)
+
+	// the first cluster has no size, it ends where the second one starts
+	first := unknownElement(idCluster,
+		uintElement(idTimestamp, 1000),
+		simpleBlock(idSimpleBlock, 1, 0, 0x80, 'k', 'e', 'y'),
+		// Xiph lacing of three frames, the sizes of the first two are written
+		simpleBlock(idSimpleBlock, 2, 10, 0x02, 2, 2, 3, 'a', 'a', 'b', 'b', 'b', 'c'),
+		element(idBlockGroup,
+			simpleBlock(idBlock, 1, 40, 0x00, 'p'),
+			uintElement(idBlockDuration, 40),
+			element(idReferenceBlock, []byte{0xD8}),
+		),
+	)
+	second := element(idCluster,
+		uintElement(idTimestamp, 2000),
+		simpleBlock(idSimpleBlock, 3, -500, 0x80, 'l', 'o', 'g', 'u', 'e'),
+	)
+
+	header := ebmlHeader("matroska")
+	// the segment id and its unknown size
+	segmentHeader := 12
+	firstOffset := int64(len(header) + segmentHeader + len(head))
+	secondOffset := firstOffset + int64(len(first))
+
+	file := bytes.Join([][]byte{header, unknownElement(idSegment, head, first, second)}, nil)
+
+	want := []Block{
+		{Track: 1, Cluster: firstOffset, Time: time.Second, Duration: 40 * time.Millisecond, Keyframe: true, Frames: [][]byte{[]byte("key")}},
+		{Track: 2, Cluster: firstOffset, Time: 1010 * time.Millisecond, Frames: [][]byte{[]byte("aa"), []byte("bbb"), []byte("c")}},
+		{Track: 1, Cluster: firstOffset, Time: 1040 * time.Millisecond, Duration: 40 * time.Millisecond, Frames: [][]byte{[]byte("p")}},
+		{Track: 3, Cluster: secondOffset, Time: 1500 * time.Millisecond, Keyframe: true, Frames: [][]byte{[]byte("Dialogue")}},
+	}
+
+	tests := []struct {
+		name     string
+		selected []int
+		want     []Block
+	}{
+		{name: "every track", want: want},
+		{name: "selected tracks", selected: []int{1, 3}, want: []Block{want[0], want[2], want[3]}},
+		{name: "subtitles", selected: []int{3}, want: want[3:]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			demuxer, err := OpenMatroska(bytes.NewReader(file), int64(len(file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.selected != nil {
+				demuxer.Select(test.selected...)
+			}
+
+			var blocks []Block
+			for {
+				block, err := demuxer.Next()
+				if err == io.EOF {
+					break
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				blocks = append(blocks, block)
+			}
+
+			if !reflect.DeepEqual(blocks, test.want) {
+				t.Errorf("Next\n got %+v\nwant %+v", blocks, test.want)
+			}
+		})
+	}
+
+	demuxer, err := OpenMatroska(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	demuxer.SeekCluster(secondOffset)
+	if block, err := demuxer.Next(); err != nil || !reflect.DeepEqual(block, want[3]) {
+		t.Errorf("Next after SeekCluster = %+v, %v, want %+v", block, err, want[3])
+	}
+}
+
+func TestUnlace(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 300)
+
+	tests := []struct {
+		name  string
+		flags byte
+		data  []byte
+		want  [][]byte
+		err   error
+	}{
+		{
+			name: "no lacing",
+			data: []byte("frame"),
+			want: [][]byte{[]byte("frame")},
+		},
+		{
+			name:  "xiph",
+			flags: 0x02,
+			data:  append([]byte{2, 1, 3}, "abbbcc"...),
+			want:  [][]byte{[]byte("a"), []byte("bbb"), []byte("cc")},
+		},
+		{
+			name:  "xiph size over 255",
+			flags: 0x02,
+			data:  bytes.Join([][]byte{{1, 255, 45}, long, []byte("end")}, nil),
+			want:  [][]byte{long, []byte("end")},
+		},
+		{
+			name:  "xiph empty frame",
+			flags: 0x02,
+			data:  append([]byte{1, 0}, "ab"...),
+			want:  [][]byte{{}, []byte("ab")},
+		},
+		{
+			name:  "fixed size",
+			flags: 0x04,
+			data:  append([]byte{2}, "aabbcc"...),
+			want:  [][]byte{[]byte("aa"), []byte("bb"), []byte("cc")},
+		},
+		{
+			name:  "ebml",
+			flags: 0x06,
+			// 3 bytes, then 3 + 2 and 5 - 3, the differences biased by 63
+			data: append([]byte{3, 0x83, 0x80 | 65, 0x80 | 60}, "aaabbbbbcccd"...),
+			want: [][]byte{[]byte("aaa"), []byte("bbbbb"), []byte("cc"), []byte("cd")},
+		},
+		{
+			name:  "ebml two byte difference",
+			flags: 0x06,
+			// 1 byte, then 1 + 299 biased by 8191
+			data: bytes.Join([][]byte{{2, 0x81, 0x40 | (8191+299)>>8, (8191 + 299) & 0xFF}, []byte("a"), long, []byte("z")}, nil),
+			want: [][]byte{[]byte("a"), long, []byte("z")},
+		},
+		{
+			name:  "missing count",
+			flags: 0x02,
+			data:  []byte{},
+			err:   errInvalidBlock,
+		},
+		{
+			name:  "xiph sizes past the data",
+			flags: 0x02,
+			data:  []byte{1, 255},
+			err:   errInvalidBlock,
+		},
+		{
+			name:  "xiph frames bigger than the block",
+			flags: 0x02,
+			data:  append([]byte{1, 10}, "abc"...),
+			err:   errInvalidBlock,
+		},
+		{
+			name:  "ebml negative size",
+			flags: 0x06,
+			data:  append([]byte{2, 0x81, 0x80 | 10}, "abc"...),
+			err:   errInvalidBlock,
+		},
+		{
+			name:  "ebml invalid vint",
+			flags: 0x06,
+			data:  []byte{1, 0x00},
+			err:   errInvalidBlock,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frames, err := unlace(test.data, test.flags)
+			if err != test.err {
+				t.Fatalf("unlace error = %v, want %v", err, test.err)
+			}
+
+			if !reflect.DeepEqual(frames, test.want) {
+				t.Errorf("unlace = %q, want %q", frames, test.want)
+			}
+		})
+	}
+}
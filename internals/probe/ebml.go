@@ -0,0 +1,299 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// Matroska element ids, see https://www.matroska.org/technical/elements.html
+const (
+	idEBML         = 0x1A45DFA3
+	idDocType      = 0x4282
+	idSegment      = 0x18538067
+	idSeekHead     = 0x114D9B74
+	idSeek         = 0x4DBB
+	idSeekId       = 0x53AB
+	idSeekPosition = 0x53AC
+
+	idInfo           = 0x1549A966
+	idTimestampScale = 0x2AD7B1
+	idDuration       = 0x4489
+
+	idTracks            = 0x1654AE6B
+	idTrackEntry        = 0xAE
+	idTrackNumber       = 0xD7
+	idTrackType         = 0x83
+	idFlagDefault       = 0x88
+	idFlagForced        = 0x55AA
+	idName              = 0x536E
+	idLanguage          = 0x22B59C
+	idLanguageBCP47     = 0x22B59D
+	idCodecId           = 0x86
+	idVideo             = 0xE0
+	idPixelWidth        = 0xB0
+	idPixelHeight       = 0xBA
+	idAudio             = 0xE1
+	idSamplingFrequency = 0xB5
+	idChannels          = 0x9F
+
+	idChapters           = 0x1043A770
+	idEditionEntry       = 0x45B9
+	idEditionFlagDefault = 0x45DB
+	idEditionFlagHidden  = 0x45BD
+	idChapterAtom        = 0xB6
+	idChapterTimeStart   = 0x91
+	idChapterTimeEnd     = 0x92
+	idChapterFlagHidden  = 0x98
+	idChapterFlagEnabled = 0x4598
+	idChapterDisplay     = 0x80
+	idChapString         = 0x85
+	idChapLanguage       = 0x437C
+
+	idCodecPrivate            = 0x63A2
+	idDefaultDuration         = 0x23E383
+	idContentEncodings        = 0x6D80
+	idContentEncoding         = 0x6240
+	idContentEncodingScope    = 0x5032
+	idContentEncodingType     = 0x5033
+	idContentCompression      = 0x5034
+	idContentCompressionAlgo  = 0x4254
+	idContentCompressionExtra = 0x4255
+
+	idCluster        = 0x1F43B675
+	idTimestamp      = 0xE7
+	idSimpleBlock    = 0xA3
+	idBlockGroup     = 0xA0
+	idBlock          = 0xA1
+	idBlockDuration  = 0x9B
+	idReferenceBlock = 0xFB
+
+	idCues        = 0x1C53BB6B
+	idAttachments = 0x1941A469
+	idTags        = 0x1254C367
+)
+
+var (
+	errInvalidVint    = errors.New("invalid EBML variable size integer")
+	errElementTooBig  = errors.New("EBML element is too big")
+	errTruncatedChild = errors.New("EBML child element overflows its parent")
+)
+
+// unknownSize marks elements whose size isn't written, which last until the
+// end of their parent.
+const unknownSize = -1
+
+type ebmlElement struct {
+	id     uint32
+	size   int64
+	offset int64
+}
+
+func (element ebmlElement) end() int64 {
+	return element.offset + element.size
+}
+
+// readVint reads a variable size integer. Ids keep their length marker, sizes
+// don't, and a size with every bit set is unknown.
+func readVint(r io.ByteReader, isId bool) (uint64, int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	length := bits.LeadingZeros8(first) + 1
+	if length > 8 || (isId && length > 4) {
+		return 0, 0, errInvalidVint
+	}
+
+	value := uint64(first)
+	if !isId {
+		value &= (1 << (8 - length)) - 1
+	}
+
+	allOnes := value == (1<<(8-length))-1
+	for i := 1; i < length; i++ {
+		next, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		allOnes = allOnes && next == 0xFF
+		value = value<<8 | uint64(next)
+	}
+
+	if !isId && allOnes {
+		return math.MaxUint64, length, nil
+	}
+
+	return value, length, nil
+}
+
+// readElementHeader reads the id and size of an element, starting at offset.
+func readElementHeader(r io.ByteReader, offset int64) (ebmlElement, error) {
+	id, idLength, err := readVint(r, true)
+	if err != nil {
+		return ebmlElement{}, err
+	}
+
+	size, sizeLength, err := readVint(r, false)
+	if err != nil {
+		return ebmlElement{}, err
+	}
+
+	element := ebmlElement{
+		id:     uint32(id),
+		size:   int64(size),
+		offset: offset + int64(idLength+sizeLength),
+	}
+
+	if size == math.MaxUint64 {
+		element.size = unknownSize
+	} else if size > math.MaxInt64/2 {
+		return ebmlElement{}, errElementTooBig
+	}
+
+	return element, nil
+}
+
+// seekReader reads a file through a buffer while keeping track of the
+// position, so element headers don't cost a read each.
+type seekReader struct {
+	r   io.ReadSeeker
+	buf *bufio.Reader
+	pos int64
+}
+
+func newSeekReader(r io.ReadSeeker) *seekReader {
+	return &seekReader{r: r, buf: bufio.NewReaderSize(r, 64<<10), pos: -1}
+}
+
+func (reader *seekReader) ReadByte() (byte, error) {
+	b, err := reader.buf.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	reader.pos++
+	return b, nil
+}
+
+func (reader *seekReader) seek(offset int64) error {
+	if offset == reader.pos {
+		return nil
+	}
+
+	// short jumps forward stay in the buffer
+	if distance := offset - reader.pos; reader.pos >= 0 && distance > 0 && distance <= int64(reader.buf.Buffered()) {
+		reader.buf.Discard(int(distance))
+		reader.pos = offset
+		return nil
+	}
+
+	if _, err := reader.r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader.buf.Reset(reader.r)
+	reader.pos = offset
+	return nil
+}
+
+func (reader *seekReader) headerAt(offset int64) (ebmlElement, error) {
+	if err := reader.seek(offset); err != nil {
+		return ebmlElement{}, err
+	}
+
+	return readElementHeader(reader, offset)
+}
+
+// read reads the next n bytes.
+func (reader *seekReader) read(n int64) ([]byte, error) {
+	if n < 0 || n > maxElementSize {
+		return nil, errElementTooBig
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(reader.buf, data); err != nil {
+		return nil, err
+	}
+
+	reader.pos += n
+	return data, nil
+}
+
+// data reads the whole content of the element.
+func (reader *seekReader) data(element ebmlElement) ([]byte, error) {
+	if element.size == unknownSize {
+		return nil, errElementTooBig
+	}
+
+	if err := reader.seek(element.offset); err != nil {
+		return nil, err
+	}
+
+	return reader.read(element.size)
+}
+
+// ebmlChild is an element read from the content of its parent.
+type ebmlChild struct {
+	id   uint32
+	data []byte
+}
+
+// ebmlChildren splits the content of a master element into its children.
+func ebmlChildren(data []byte) ([]ebmlChild, error) {
+	var children []ebmlChild
+	reader := bytes.NewReader(data)
+
+	for reader.Len() > 0 {
+		offset := int64(len(data) - reader.Len())
+		element, err := readElementHeader(reader, offset)
+		if err != nil {
+			return children, err
+		}
+
+		end := element.end()
+		if element.size == unknownSize {
+			end = int64(len(data))
+		}
+
+		if end > int64(len(data)) {
+			return children, errTruncatedChild
+		}
+
+		children = append(children, ebmlChild{id: element.id, data: data[element.offset:end]})
+		reader.Seek(end, io.SeekStart)
+	}
+
+	return children, nil
+}
+
+func ebmlUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+
+	return value
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	default:
+		return 0
+	}
+}
+
+func ebmlString(data []byte) string {
+	return strings.TrimRight(string(data), "\x00")
+}
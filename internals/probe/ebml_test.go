@@ -0,0 +1,218 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// ebmlId writes an element id, which keeps its length marker.
+func ebmlId(id uint32) []byte {
+	var data []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(data) > 0 {
+			data = append(data, b)
+		}
+	}
+
+	return data
+}
+
+// ebmlSize writes a size in the shortest variable size integer.
+func ebmlSize(size int) []byte {
+	length := 1
+	for size >= 1<<(7*length)-1 {
+		length++
+	}
+
+	data := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		data[i] = byte(size)
+		size >>= 8
+	}
+	data[0] |= 1 << (8 - length)
+
+	return data
+}
+
+func element(id uint32, children ...[]byte) []byte {
+	data := bytes.Join(children, nil)
+	return bytes.Join([][]byte{ebmlId(id), ebmlSize(len(data)), data}, nil)
+}
+
+// unknownElement writes an element without its size, like live recordings.
+func unknownElement(id uint32, children ...[]byte) []byte {
+	data := bytes.Join(children, nil)
+	return bytes.Join([][]byte{ebmlId(id), {0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, data}, nil)
+}
+
+func uintElement(id uint32, value uint64) []byte {
+	data := []byte{byte(value)}
+	for value >>= 8; value > 0; value >>= 8 {
+		data = append([]byte{byte(value)}, data...)
+	}
+
+	return element(id, data)
+}
+
+func stringElement(id uint32, value string) []byte {
+	return element(id, []byte(value))
+}
+
+func floatElement(id uint32, value float64) []byte {
+	return element(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(value)))
+}
+
+func TestReadVint(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		isId   bool
+		value  uint64
+		length int
+		err    error
+	}{
+		{name: "one byte", data: []byte{0x81}, value: 1, length: 1},
+		{name: "zero", data: []byte{0x80}, value: 0, length: 1},
+		{name: "two bytes", data: []byte{0x40, 0x02}, value: 2, length: 2},
+		{name: "largest one byte", data: []byte{0xFE}, value: 126, length: 1},
+		{name: "not all ones", data: []byte{0x7F, 0xFE}, value: 0x3FFE, length: 2},
+		{name: "eight bytes", data: []byte{0x01, 0, 0, 0, 0, 0, 0x01, 0x00}, value: 256, length: 8},
+		{name: "unknown one byte", data: []byte{0xFF}, value: math.MaxUint64, length: 1},
+		{name: "unknown two bytes", data: []byte{0x7F, 0xFF}, value: math.MaxUint64, length: 2},
+		{name: "unknown eight bytes", data: []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, value: math.MaxUint64, length: 8},
+		{name: "no length marker", data: []byte{0x00, 0x01}, err: errInvalidVint},
+		{name: "truncated", data: []byte{0x40}, err: io.EOF},
+		{name: "empty", data: []byte{}, err: io.EOF},
+		{name: "id keeps its marker", data: []byte{0x1A, 0x45, 0xDF, 0xA3}, isId: true, value: idEBML, length: 4},
+		{name: "one byte id", data: []byte{0xA3}, isId: true, value: idSimpleBlock, length: 1},
+		{name: "id with every bit set", data: []byte{0xFF}, isId: true, value: 0xFF, length: 1},
+		{name: "id longer than four bytes", data: []byte{0x08, 0, 0, 0, 0}, isId: true, err: errInvalidVint},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, length, err := readVint(bytes.NewReader(test.data), test.isId)
+			if err != test.err {
+				t.Fatalf("readVint(% X) error = %v, want %v", test.data, err, test.err)
+			}
+
+			if err == nil && (value != test.value || length != test.length) {
+				t.Errorf("readVint(% X) = %v, %v, want %v, %v", test.data, value, length, test.value, test.length)
+			}
+		})
+	}
+}
+
+func TestEbmlSize(t *testing.T) {
+	// sizes with every bit set are unknown, so they take one more byte
+	for _, size := range []int{0, 1, 126, 127, 128, 16382, 16383, 1 << 20} {
+		data := ebmlSize(size)
+		value, length, err := readVint(bytes.NewReader(data), false)
+		if err != nil || value != uint64(size) || length != len(data) {
+			t.Errorf("readVint(ebmlSize(%v)) = %v, %v, %v", size, value, length, err)
+		}
+	}
+}
+
+func TestReadElementHeader(t *testing.T) {
+	header, err := readElementHeader(bytes.NewReader(element(idTracks, make([]byte, 300))), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ebmlElement{id: idTracks, size: 300, offset: 10 + 4 + 2}
+	if header != want {
+		t.Errorf("readElementHeader = %+v, want %+v", header, want)
+	}
+
+	header, err = readElementHeader(bytes.NewReader(unknownElement(idCluster)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.size != unknownSize || header.offset != 12 {
+		t.Errorf("readElementHeader of an unknown size = %+v, want size %v at 12", header, unknownSize)
+	}
+}
+
+func TestEbmlChildren(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []ebmlChild
+		err  error
+	}{
+		{
+			name: "children",
+			data: bytes.Join([][]byte{uintElement(idTrackNumber, 2), stringElement(idCodecId, "A_OPUS")}, nil),
+			want: []ebmlChild{{id: idTrackNumber, data: []byte{2}}, {id: idCodecId, data: []byte("A_OPUS")}},
+		},
+		{
+			name: "empty child",
+			data: element(idName),
+			want: []ebmlChild{{id: idName, data: []byte{}}},
+		},
+		{
+			name: "unknown size lasts until the end of the parent",
+			data: bytes.Join([][]byte{uintElement(idTimestamp, 5), unknownElement(idBlockGroup, uintElement(idBlockDuration, 1))}, nil),
+			want: []ebmlChild{
+				{id: idTimestamp, data: []byte{5}},
+				{id: idBlockGroup, data: uintElement(idBlockDuration, 1)},
+			},
+		},
+		{
+			name: "child overflowing its parent",
+			data: bytes.Join([][]byte{uintElement(idTrackNumber, 1), element(idName, []byte("name"))[:4]}, nil),
+			want: []ebmlChild{{id: idTrackNumber, data: []byte{1}}},
+			err:  errTruncatedChild,
+		},
+		{
+			name: "truncated header",
+			data: []byte{0x53},
+			err:  io.EOF,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			children, err := ebmlChildren(test.data)
+			if err != test.err {
+				t.Fatalf("ebmlChildren error = %v, want %v", err, test.err)
+			}
+
+			if !reflect.DeepEqual(children, test.want) {
+				t.Errorf("ebmlChildren = %+v, want %+v", children, test.want)
+			}
+		})
+	}
+}
+
+func TestEbmlValues(t *testing.T) {
+	if got := ebmlUint([]byte{0x01, 0x00, 0x02}); got != 0x010002 {
+		t.Errorf("ebmlUint = %v, want %v", got, 0x010002)
+	}
+
+	if got := ebmlUint(nil); got != 0 {
+		t.Errorf("ebmlUint of nothing = %v, want 0", got)
+	}
+
+	if got := ebmlFloat(binary.BigEndian.AppendUint32(nil, math.Float32bits(48000))); got != 48000 {
+		t.Errorf("ebmlFloat of 4 bytes = %v, want 48000", got)
+	}
+
+	if got := ebmlFloat(binary.BigEndian.AppendUint64(nil, math.Float64bits(1.5))); got != 1.5 {
+		t.Errorf("ebmlFloat of 8 bytes = %v, want 1.5", got)
+	}
+
+	if got := ebmlFloat([]byte{1, 2}); got != 0 {
+		t.Errorf("ebmlFloat of 2 bytes = %v, want 0", got)
+	}
+
+	if got := ebmlString([]byte("eng\x00\x00")); got != "eng" {
+		t.Errorf("ebmlString = %q, want %q", got, "eng")
+	}
+}
@@ -0,0 +1,401 @@
+package probe
+
+import (
+	"io"
+	"time"
+)
+
+// matroskaHeaders are the top level elements the probe reads.
+var matroskaHeaders = []uint32{idInfo, idTracks, idChapters}
+
+// matroskaSegment is what the top level of a Matroska file tells about it.
+type matroskaSegment struct {
+	reader  *seekReader
+	docType string
+	// offset is where the content of the segment starts, the positions of the
+	// seek head are relative to it
+	offset       int64
+	end          int64
+	firstCluster int64
+	headers      map[uint32][]byte
+	// positions are the offsets of the top level elements, from the seek head
+	// or from the walk over them
+	positions map[uint32]int64
+}
+
+// readMatroska reads the headers of the segment into the info.
+func readMatroska(r io.ReadSeeker, size int64) (Info, error) {
+	segment, err := readSegment(r, size)
+	if err != nil {
+		return Info{}, err
+	}
+
+	info := Info{Container: "matroska"}
+	if segment.docType == "webm" {
+		info.Container = "webm"
+	}
+
+	scale, duration := readSegmentInfo(segment.headers[idInfo])
+	info.Duration = duration * float64(scale) / 1e9
+
+	for _, entry := range readTracks(segment.headers[idTracks]) {
+		info.Tracks = append(info.Tracks, entry.Track)
+	}
+	info.Chapters = readChapters(segment.headers[idChapters])
+
+	return info, nil
+}
+
+// readSegment walks the top level elements of the segment until it finds the
+// first cluster, then jumps to the headers that the seek head places after
+// the clusters.
+func readSegment(r io.ReadSeeker, size int64) (*matroskaSegment, error) {
+	reader := newSeekReader(r)
+
+	header, err := reader.headerAt(0)
+	if err != nil || header.id != idEBML {
+		return nil, ErrNotMatroska
+	}
+
+	data, err := reader.data(header)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &matroskaSegment{
+		reader:       reader,
+		firstCluster: -1,
+		headers:      map[uint32][]byte{},
+		positions:    map[uint32]int64{},
+	}
+	children, _ := ebmlChildren(data)
+	for _, child := range children {
+		if child.id == idDocType {
+			result.docType = ebmlString(child.data)
+		}
+	}
+
+	segment, err := reader.headerAt(header.end())
+	if err != nil || segment.id != idSegment {
+		return nil, ErrNotMatroska
+	}
+
+	result.offset = segment.offset
+	result.end = size
+	if segment.size != unknownSize && segment.end() < size {
+		result.end = segment.end()
+	}
+
+	positions := map[uint32]int64{}
+
+	for offset := segment.offset; offset < result.end; {
+		element, err := reader.headerAt(offset)
+		if err != nil {
+			break
+		}
+
+		if element.id == idCluster && result.firstCluster < 0 {
+			result.firstCluster = offset
+		}
+
+		if _, found := result.positions[element.id]; !found && element.id != idCluster {
+			result.positions[element.id] = offset
+		}
+
+		// live recordings and truncated downloads end abruptly
+		if element.size == unknownSize {
+			break
+		}
+
+		switch element.id {
+		case idSeekHead:
+			if data, err := reader.data(element); err == nil {
+				readSeekHead(data, segment.offset, positions)
+			}
+		case idInfo, idTracks, idChapters:
+			data, err := reader.data(element)
+			if err != nil {
+				return nil, err
+			}
+			result.headers[element.id] = data
+		}
+
+		if element.id == idCluster && headersLocated(result.headers, positions) {
+			break
+		}
+
+		offset = element.end()
+	}
+
+	for _, id := range matroskaHeaders {
+		position, found := positions[id]
+		if _, done := result.headers[id]; done || !found {
+			continue
+		}
+
+		element, err := reader.headerAt(position)
+		if err != nil || element.id != id {
+			continue
+		}
+
+		if data, err := reader.data(element); err == nil {
+			result.headers[id] = data
+		}
+	}
+
+	for id, position := range positions {
+		if _, found := result.positions[id]; !found {
+			result.positions[id] = position
+		}
+	}
+
+	return result, nil
+}
+
+// headersLocated tells if every header was either read or has a known position.
+func headersLocated(parsed map[uint32][]byte, positions map[uint32]int64) bool {
+	for _, id := range matroskaHeaders {
+		_, done := parsed[id]
+		_, found := positions[id]
+
+		// chapters are optional, without a seek entry they aren't expected
+		if !done && !found && (id != idChapters || len(positions) == 0) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func readSeekHead(data []byte, segmentOffset int64, positions map[uint32]int64) {
+	seeks, _ := ebmlChildren(data)
+	for _, seek := range seeks {
+		if seek.id != idSeek {
+			continue
+		}
+
+		var id uint32
+		var position int64 = -1
+
+		fields, _ := ebmlChildren(seek.data)
+		for _, field := range fields {
+			switch field.id {
+			case idSeekId:
+				id = uint32(ebmlUint(field.data))
+			case idSeekPosition:
+				position = int64(ebmlUint(field.data))
+			}
+		}
+
+		if _, found := positions[id]; !found && position >= 0 {
+			positions[id] = segmentOffset + position
+		}
+	}
+}
+
+// readSegmentInfo reads the timestamp scale, the nanoseconds of a tick, and the
+// duration of the segment in ticks.
+func readSegmentInfo(data []byte) (uint64, float64) {
+	scale := uint64(1000000)
+	var duration float64
+
+	fields, _ := ebmlChildren(data)
+	for _, field := range fields {
+		switch field.id {
+		case idTimestampScale:
+			if value := ebmlUint(field.data); value > 0 {
+				scale = value
+			}
+		case idDuration:
+			duration = ebmlFloat(field.data)
+		}
+	}
+
+	return scale, duration
+}
+
+func readTracks(data []byte) []TrackEntry {
+	var tracks []TrackEntry
+
+	elements, _ := ebmlChildren(data)
+	for _, element := range elements {
+		if element.id != idTrackEntry {
+			continue
+		}
+
+		entry := TrackEntry{Track: Track{Default: true, Language: "eng"}}
+		track := &entry.Track
+		var bcp47 string
+
+		fields, _ := ebmlChildren(element.data)
+		for _, field := range fields {
+			switch field.id {
+			case idTrackNumber:
+				track.Number = int(ebmlUint(field.data))
+			case idTrackType:
+				track.Type = matroskaTrackType(ebmlUint(field.data))
+			case idFlagDefault:
+				track.Default = ebmlUint(field.data) == 1
+			case idFlagForced:
+				track.Forced = ebmlUint(field.data) == 1
+			case idName:
+				track.Name = ebmlString(field.data)
+			case idLanguage:
+				track.Language = ebmlString(field.data)
+			case idLanguageBCP47:
+				bcp47 = ebmlString(field.data)
+			case idCodecId:
+				track.CodecId = ebmlString(field.data)
+			case idCodecPrivate:
+				entry.CodecPrivate = field.data
+			case idDefaultDuration:
+				entry.DefaultDuration = time.Duration(ebmlUint(field.data))
+			case idContentEncodings:
+				entry.encodings = readContentEncodings(field.data)
+			case idVideo:
+				readVideoTrack(field.data, track)
+			case idAudio:
+				readAudioTrack(field.data, track)
+			}
+		}
+
+		if bcp47 != "" {
+			track.Language = bcp47
+		}
+
+		if track.Language == "und" {
+			track.Language = ""
+		}
+
+		track.Codec = matroskaCodec(track.CodecId)
+		tracks = append(tracks, entry)
+	}
+
+	return tracks
+}
+
+func matroskaTrackType(value uint64) TrackType {
+	switch value {
+	case 1:
+		return TrackVideo
+	case 2:
+		return TrackAudio
+	case 0x11:
+		return TrackSubtitle
+	default:
+		return TrackOther
+	}
+}
+
+func readVideoTrack(data []byte, track *Track) {
+	fields, _ := ebmlChildren(data)
+	for _, field := range fields {
+		switch field.id {
+		case idPixelWidth:
+			track.Width = int(ebmlUint(field.data))
+		case idPixelHeight:
+			track.Height = int(ebmlUint(field.data))
+		}
+	}
+}
+
+func readAudioTrack(data []byte, track *Track) {
+	track.Channels = 1
+	track.SampleRate = 8000
+
+	fields, _ := ebmlChildren(data)
+	for _, field := range fields {
+		switch field.id {
+		case idChannels:
+			track.Channels = int(ebmlUint(field.data))
+		case idSamplingFrequency:
+			track.SampleRate = ebmlFloat(field.data)
+		}
+	}
+}
+
+// readChapters reads the visible chapters of the default edition, or of the
+// first one when none is the default.
+func readChapters(data []byte) []Chapter {
+	editions, _ := ebmlChildren(data)
+
+	var chosen []byte
+	for _, edition := range editions {
+		if edition.id != idEditionEntry {
+			continue
+		}
+
+		isDefault, hidden := false, false
+		fields, _ := ebmlChildren(edition.data)
+		for _, field := range fields {
+			switch field.id {
+			case idEditionFlagDefault:
+				isDefault = ebmlUint(field.data) == 1
+			case idEditionFlagHidden:
+				hidden = ebmlUint(field.data) == 1
+			}
+		}
+
+		if hidden {
+			continue
+		}
+
+		if chosen == nil || isDefault {
+			chosen = edition.data
+		}
+
+		if isDefault {
+			break
+		}
+	}
+
+	var chapters []Chapter
+
+	atoms, _ := ebmlChildren(chosen)
+	for _, atom := range atoms {
+		if atom.id != idChapterAtom {
+			continue
+		}
+
+		if chapter, visible := readChapterAtom(atom.data); visible {
+			chapters = append(chapters, chapter)
+		}
+	}
+
+	return chapters
+}
+
+func readChapterAtom(data []byte) (Chapter, bool) {
+	var chapter Chapter
+	visible := true
+
+	fields, _ := ebmlChildren(data)
+	for _, field := range fields {
+		switch field.id {
+		case idChapterTimeStart:
+			chapter.Start = float64(ebmlUint(field.data)) / 1e9
+		case idChapterTimeEnd:
+			chapter.End = float64(ebmlUint(field.data)) / 1e9
+		case idChapterFlagHidden:
+			visible = visible && ebmlUint(field.data) == 0
+		case idChapterFlagEnabled:
+			visible = visible && ebmlUint(field.data) == 1
+		case idChapterDisplay:
+			if chapter.Title != "" {
+				continue
+			}
+
+			display, _ := ebmlChildren(field.data)
+			for _, item := range display {
+				switch item.id {
+				case idChapString:
+					chapter.Title = ebmlString(item.data)
+				case idChapLanguage:
+					chapter.Language = ebmlString(item.data)
+				}
+			}
+		}
+	}
+
+	return chapter, visible
+}
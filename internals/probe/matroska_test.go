@@ -0,0 +1,184 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func ebmlHeader(docType string) []byte {
+	return element(idEBML, uintElement(0x4286, 1), stringElement(idDocType, docType))
+}
+
+func testTracks() []byte {
+	return element(idTracks,
+		element(idTrackEntry,
+			uintElement(idTrackNumber, 1),
+			uintElement(idTrackType, 1),
+			stringElement(idCodecId, "V_MPEG4/ISO/AVC"),
+			uintElement(idDefaultDuration, 40000000),
+			element(idVideo, uintElement(idPixelWidth, 1920), uintElement(idPixelHeight, 1080)),
+		),
+		element(idTrackEntry,
+			uintElement(idTrackNumber, 2),
+			uintElement(idTrackType, 2),
+			stringElement(idCodecId, "A_AAC"),
+			stringElement(idLanguage, "jpn"),
+			element(idAudio, uintElement(idChannels, 2), floatElement(idSamplingFrequency, 48000)),
+		),
+		element(idTrackEntry,
+			uintElement(idTrackNumber, 3),
+			uintElement(idTrackType, 0x11),
+			stringElement(idCodecId, "S_TEXT/ASS"),
+			stringElement(idName, "Signs\x00"),
+			stringElement(idLanguage, "por"),
+			stringElement(idLanguageBCP47, "pt-BR"),
+			uintElement(idFlagDefault, 0),
+			uintElement(idFlagForced, 1),
+			element(idContentEncodings, element(idContentEncoding,
+				element(idContentCompression,
+					uintElement(idContentCompressionAlgo, compressionHeader),
+					stringElement(idContentCompressionExtra, "Dia"),
+				),
+			)),
+		),
+	)
+}
+
+var testTrackList = []Track{
+	{Number: 1, Type: TrackVideo, Codec: "h264", CodecId: "V_MPEG4/ISO/AVC", Language: "eng", Default: true, Width: 1920, Height: 1080},
+	{Number: 2, Type: TrackAudio, Codec: "aac", CodecId: "A_AAC", Language: "jpn", Default: true, Channels: 2, SampleRate: 48000},
+	{Number: 3, Type: TrackSubtitle, Codec: "ass", CodecId: "S_TEXT/ASS", Language: "pt-BR", Name: "Signs", Forced: true},
+}
+
+func testChapters() []byte {
+	chapter := func(start uint64, title string, fields ...[]byte) []byte {
+		return element(idChapterAtom, append([][]byte{
+			uintElement(idChapterTimeStart, start),
+			element(idChapterDisplay, stringElement(idChapString, title), stringElement(idChapLanguage, "eng")),
+		}, fields...)...)
+	}
+
+	return element(idChapters,
+		element(idEditionEntry, uintElement(idEditionFlagHidden, 1), chapter(0, "Hidden edition")),
+		element(idEditionEntry, chapter(0, "Not the default edition")),
+		element(idEditionEntry,
+			uintElement(idEditionFlagDefault, 1),
+			chapter(0, "Opening", uintElement(idChapterTimeEnd, 90e9)),
+			chapter(30e9, "Hidden", uintElement(idChapterFlagHidden, 1)),
+			chapter(45e9, "Disabled", uintElement(idChapterFlagEnabled, 0)),
+			chapter(90e9, "Part A"),
+			chapter(300e9, "Ending"),
+		),
+	)
+}
+
+var testChapterList = []Chapter{
+	{Start: 0, End: 90, Title: "Opening", Language: "eng"},
+	{Start: 90, End: 300, Title: "Part A", Language: "eng"},
+	{Start: 300, End: 600, Title: "Ending", Language: "eng"},
+}
+
+func TestReadMatroska(t *testing.T) {
+	file := bytes.Join([][]byte{
+		ebmlHeader("matroska"),
+		unknownElement(idSegment,
+			element(idInfo, uintElement(idTimestampScale, 1000000), floatElement(idDuration, 600000)),
+			testTracks(),
+			testChapters(),
+			unknownElement(idCluster, uintElement(idTimestamp, 0)),
+		),
+	}, nil)
+
+	info, err := Read(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Info{
+		Container:  "matroska",
+		Duration:   600,
+		Width:      1920,
+		Height:     1080,
+		VideoCodec: "h264",
+		Tracks:     testTrackList,
+		Chapters:   testChapterList,
+	}
+
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("Read\n got %+v\nwant %+v", info, want)
+	}
+}
+
+// TestReadMatroskaSeekHead reads the headers that the seek head places after
+// the clusters.
+func TestReadMatroskaSeekHead(t *testing.T) {
+	segmentInfo := element(idInfo, uintElement(idTimestampScale, 1000), floatElement(idDuration, 2.5e6))
+	cluster := element(idCluster, uintElement(idTimestamp, 0), element(idSimpleBlock, []byte{0x81, 0, 0, 0x80, 1}))
+	tracks := testTracks()
+
+	seekHead := func(position uint64) []byte {
+		return element(idSeekHead, element(idSeek,
+			element(idSeekId, ebmlId(idTracks)),
+			element(idSeekPosition, binary.BigEndian.AppendUint64(nil, position)),
+		))
+	}
+
+	// the position is relative to the content of the segment
+	position := len(seekHead(0)) + len(segmentInfo) + len(cluster)
+	content := bytes.Join([][]byte{seekHead(uint64(position)), segmentInfo, cluster, tracks}, nil)
+	file := append(ebmlHeader("webm"), element(idSegment, content)...)
+
+	info, err := Read(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Container != "webm" || info.Duration != 2.5 {
+		t.Errorf("Read = %v of %v seconds, want webm of 2.5 seconds", info.Container, info.Duration)
+	}
+
+	if !reflect.DeepEqual(info.Tracks, testTrackList) {
+		t.Errorf("Read tracks\n got %+v\nwant %+v", info.Tracks, testTrackList)
+	}
+
+	if len(info.Chapters) != 0 {
+		t.Errorf("Read chapters = %+v, want none", info.Chapters)
+	}
+}
+
+func TestReadMatroskaTruncated(t *testing.T) {
+	file := bytes.Join([][]byte{
+		ebmlHeader("matroska"),
+		element(idSegment,
+			element(idInfo, floatElement(idDuration, 1000)),
+			testTracks(),
+			element(idCluster, make([]byte, 1000)),
+		),
+	}, nil)
+
+	// a download that stopped in the middle of the first cluster
+	file = file[:len(file)-900]
+
+	info, err := Read(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Duration != 1 || len(info.Tracks) != 3 {
+		t.Errorf("Read = %v seconds and %v tracks, want 1 second and 3 tracks", info.Duration, len(info.Tracks))
+	}
+}
+
+func TestReadNotMatroska(t *testing.T) {
+	file := append(ebmlHeader("matroska"), element(idTracks)...)
+	if _, err := Read(bytes.NewReader(file), int64(len(file))); err != ErrNotMatroska {
+		t.Errorf("Read without a segment = %v, want %v", err, ErrNotMatroska)
+	}
+
+	file = []byte("not a video file")
+	if _, err := Read(bytes.NewReader(file), int64(len(file))); err != ErrUnknownFormat {
+		t.Errorf("Read of text = %v, want %v", err, ErrUnknownFormat)
+	}
+}
@@ -0,0 +1,307 @@
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var errNoMovieBox = errors.New("MP4 file without a moov box")
+
+type mp4Box struct {
+	kind string
+	data []byte
+}
+
+// readMP4 finds the moov box among the top level boxes and reads the movie
+// header and the tracks from it.
+func readMP4(r io.ReadSeeker, size int64) (Info, error) {
+	info := Info{Container: "mp4"}
+	header := make([]byte, 16)
+
+	var moov []byte
+	for offset := int64(0); offset+8 <= size; {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return Info{}, err
+		}
+
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			break
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(header))
+		kind := string(header[4:8])
+		headerSize := int64(8)
+
+		switch boxSize {
+		case 0:
+			boxSize = size - offset
+		case 1:
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return Info{}, err
+			}
+
+			boxSize = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+
+		if boxSize < headerSize {
+			break
+		}
+
+		switch kind {
+		case "ftyp":
+			brand := make([]byte, 4)
+			if _, err := io.ReadFull(r, brand); err == nil && string(brand) == "qt  " {
+				info.Container = "mov"
+			}
+		case "moov":
+			if boxSize-headerSize > maxElementSize {
+				return Info{}, errElementTooBig
+			}
+
+			moov = make([]byte, boxSize-headerSize)
+			if _, err := io.ReadFull(r, moov); err != nil {
+				return Info{}, err
+			}
+		}
+
+		if moov != nil {
+			break
+		}
+
+		offset += boxSize
+	}
+
+	if moov == nil {
+		return Info{}, errNoMovieBox
+	}
+
+	for _, box := range mp4Boxes(moov) {
+		switch box.kind {
+		case "mvhd":
+			info.Duration = readMovieHeader(box.data)
+		case "trak":
+			if track, ok := readMP4Track(box.data); ok {
+				info.Tracks = append(info.Tracks, track)
+			}
+		case "udta":
+			info.Chapters = readNeroChapters(box.data)
+		}
+	}
+
+	return info, nil
+}
+
+// mp4Boxes splits the content of a box into its children.
+func mp4Boxes(data []byte) []mp4Box {
+	var boxes []mp4Box
+
+	for len(data) >= 8 {
+		size := int64(binary.BigEndian.Uint32(data))
+		kind := string(data[4:8])
+		headerSize := int64(8)
+
+		switch size {
+		case 0:
+			size = int64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return boxes
+			}
+
+			size = int64(binary.BigEndian.Uint64(data[8:]))
+			headerSize = 16
+		}
+
+		if size < headerSize || size > int64(len(data)) {
+			return boxes
+		}
+
+		boxes = append(boxes, mp4Box{kind: kind, data: data[headerSize:size]})
+		data = data[size:]
+	}
+
+	return boxes
+}
+
+func findMP4Box(data []byte, path ...string) []byte {
+	for _, kind := range path {
+		var found []byte
+		for _, box := range mp4Boxes(data) {
+			if box.kind == kind {
+				found = box.data
+				break
+			}
+		}
+
+		if found == nil {
+			return nil
+		}
+
+		data = found
+	}
+
+	return data
+}
+
+// readTimes reads the timescale and duration of mvhd and mdhd boxes, which
+// share the same layout up to them.
+func readTimes(data []byte) (timescale uint32, duration uint64, rest []byte) {
+	if len(data) < 1 {
+		return 0, 0, nil
+	}
+
+	if data[0] == 1 {
+		if len(data) < 32 {
+			return 0, 0, nil
+		}
+
+		return binary.BigEndian.Uint32(data[20:]), binary.BigEndian.Uint64(data[24:]), data[32:]
+	}
+
+	if len(data) < 20 {
+		return 0, 0, nil
+	}
+
+	return binary.BigEndian.Uint32(data[12:]), uint64(binary.BigEndian.Uint32(data[16:])), data[20:]
+}
+
+func readMovieHeader(data []byte) float64 {
+	timescale, duration, _ := readTimes(data)
+	if timescale == 0 {
+		return 0
+	}
+
+	return float64(duration) / float64(timescale)
+}
+
+func readMP4Track(data []byte) (Track, bool) {
+	track := Track{Default: true}
+
+	tkhd := findMP4Box(data, "tkhd")
+	if len(tkhd) < 4 {
+		return Track{}, false
+	}
+
+	// track_enabled
+	track.Default = tkhd[3]&1 == 1
+
+	idOffset, sizeOffset := 12, 76
+	if tkhd[0] == 1 {
+		idOffset, sizeOffset = 20, 88
+	}
+
+	if len(tkhd) >= idOffset+4 {
+		track.Number = int(binary.BigEndian.Uint32(tkhd[idOffset:]))
+	}
+
+	if len(tkhd) >= sizeOffset+8 {
+		track.Width = int(binary.BigEndian.Uint32(tkhd[sizeOffset:]) >> 16)
+		track.Height = int(binary.BigEndian.Uint32(tkhd[sizeOffset+4:]) >> 16)
+	}
+
+	if _, _, rest := readTimes(findMP4Box(data, "mdia", "mdhd")); len(rest) >= 2 {
+		track.Language = mp4Language(binary.BigEndian.Uint16(rest))
+	}
+
+	if hdlr := findMP4Box(data, "mdia", "hdlr"); len(hdlr) >= 12 {
+		track.Type = mp4TrackType(string(hdlr[8:12]))
+	}
+
+	// the sample description starts after the version, flags and entry count
+	stsd := findMP4Box(data, "mdia", "minf", "stbl", "stsd")
+	if len(stsd) >= 8 {
+		entries := mp4Boxes(stsd[8:])
+		if len(entries) > 0 {
+			track.CodecId = entries[0].kind
+			readSampleEntry(entries[0].data, &track)
+		}
+	}
+
+	track.Codec = mp4Codec(track.CodecId)
+	return track, true
+}
+
+func readSampleEntry(data []byte, track *Track) {
+	switch track.Type {
+	case TrackVideo:
+		if len(data) >= 28 {
+			track.Width = int(binary.BigEndian.Uint16(data[24:]))
+			track.Height = int(binary.BigEndian.Uint16(data[26:]))
+		}
+	case TrackAudio:
+		if len(data) >= 28 {
+			track.Channels = int(binary.BigEndian.Uint16(data[16:]))
+			track.SampleRate = float64(binary.BigEndian.Uint32(data[24:]) >> 16)
+		}
+	}
+}
+
+func mp4TrackType(handler string) TrackType {
+	switch handler {
+	case "vide":
+		return TrackVideo
+	case "soun":
+		return TrackAudio
+	case "sbtl", "subt", "text", "clcp":
+		return TrackSubtitle
+	default:
+		return TrackOther
+	}
+}
+
+// mp4Language unpacks the three letters of an ISO 639-2 code.
+func mp4Language(packed uint16) string {
+	letters := []byte{
+		byte(packed>>10&0x1F) + 0x60,
+		byte(packed>>5&0x1F) + 0x60,
+		byte(packed&0x1F) + 0x60,
+	}
+
+	language := string(letters)
+	if language == "und" || packed == 0 {
+		return ""
+	}
+
+	return language
+}
+
+// readNeroChapters reads the chpl box that many tools write inside udta, with
+// its times in units of 100 nanoseconds.
+func readNeroChapters(udta []byte) []Chapter {
+	chpl := findMP4Box(udta, "chpl")
+	if len(chpl) < 5 {
+		return nil
+	}
+
+	// the version 1 box has 4 reserved bytes after its version and flags
+	offset := 4
+	if chpl[0] == 1 {
+		offset = 8
+	}
+
+	if len(chpl) <= offset {
+		return nil
+	}
+
+	count := int(chpl[offset])
+	data := chpl[offset+1:]
+
+	var chapters []Chapter
+	for i := 0; i < count && len(data) >= 9; i++ {
+		start := binary.BigEndian.Uint64(data)
+		length := int(data[8])
+		if len(data) < 9+length {
+			break
+		}
+
+		chapters = append(chapters, Chapter{
+			Start: float64(start) / 1e7,
+			Title: string(data[9 : 9+length]),
+		})
+		data = data[9+length:]
+	}
+
+	return chapters
+}
@@ -0,0 +1,252 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func box(kind string, children ...[]byte) []byte {
+	data := bytes.Join(children, nil)
+	return bytes.Join([][]byte{binary.BigEndian.AppendUint32(nil, uint32(8+len(data))), []byte(kind), data}, nil)
+}
+
+// largeBox writes a box with its size in 64 bits.
+func largeBox(kind string, children ...[]byte) []byte {
+	data := bytes.Join(children, nil)
+	header := binary.BigEndian.AppendUint32(nil, 1)
+	header = append(header, kind...)
+	header = binary.BigEndian.AppendUint64(header, uint64(16+len(data)))
+	return append(header, data...)
+}
+
+func uint16s(values ...uint16) []byte {
+	var data []byte
+	for _, value := range values {
+		data = binary.BigEndian.AppendUint16(data, value)
+	}
+
+	return data
+}
+
+func uint32s(values ...uint32) []byte {
+	var data []byte
+	for _, value := range values {
+		data = binary.BigEndian.AppendUint32(data, value)
+	}
+
+	return data
+}
+
+// movieHeader writes the start of a mvhd or mdhd box, up to its duration.
+func movieHeader(version byte, timescale uint32, duration uint64) []byte {
+	if version == 1 {
+		// the creation and modification times take 64 bits too
+		return bytes.Join([][]byte{{1, 0, 0, 0}, make([]byte, 16), uint32s(timescale), binary.BigEndian.AppendUint64(nil, duration)}, nil)
+	}
+
+	return bytes.Join([][]byte{{0, 0, 0, 0}, uint32s(0, 0, timescale, uint32(duration))}, nil)
+}
+
+// neroChapters writes a chpl box, its times are in units of 100 nanoseconds.
+func neroChapters(version byte, chapters ...Chapter) []byte {
+	data := []byte{version, 0, 0, 0}
+	if version == 1 {
+		data = append(data, 0, 0, 0, 0)
+	}
+
+	data = append(data, byte(len(chapters)))
+	for _, chapter := range chapters {
+		data = append(data, neroChapter(chapter)...)
+	}
+
+	return box("chpl", data)
+}
+
+func neroChapter(chapter Chapter) []byte {
+	data := binary.BigEndian.AppendUint64(nil, uint64(chapter.Start*1e7))
+	data = append(data, byte(len(chapter.Title)))
+	return append(data, chapter.Title...)
+}
+
+// mp4Track writes a trak box, the sample entry follows the 8 bytes shared by
+// every kind of entry.
+func mp4Track(id uint32, enabled bool, handler string, language uint16, format string, entry []byte) []byte {
+	flags := byte(0)
+	if enabled {
+		flags = 1
+	}
+
+	tkhd := bytes.Join([][]byte{{0, 0, 0, flags}, uint32s(0, 0, id), make([]byte, 60), uint32s(640<<16, 360<<16)}, nil)
+	hdlr := bytes.Join([][]byte{make([]byte, 8), []byte(handler), make([]byte, 12)}, nil)
+	stsd := bytes.Join([][]byte{uint32s(0, 1), box(format, make([]byte, 8), entry)}, nil)
+
+	return box("trak",
+		box("tkhd", tkhd),
+		box("mdia",
+			box("mdhd", movieHeader(0, 1000, 0), uint16s(language, 0)),
+			box("hdlr", hdlr),
+			box("minf", box("stbl", box("stsd", stsd))),
+		),
+	)
+}
+
+// packed ISO 639-2 codes of the mdhd boxes
+const (
+	languageJpn = 10<<10 | 16<<5 | 14
+	languageUnd = 21<<10 | 14<<5 | 4
+)
+
+func TestReadMP4(t *testing.T) {
+	video := mp4Track(1, true, "vide", languageJpn, "avc1", bytes.Join([][]byte{make([]byte, 16), uint16s(1920, 1080)}, nil))
+	audio := mp4Track(2, false, "soun", languageUnd, "mp4a", bytes.Join([][]byte{make([]byte, 8), uint16s(2, 16, 0, 0), uint32s(48000 << 16)}, nil))
+	subtitle := mp4Track(3, true, "sbtl", 0, "tx3g", nil)
+
+	moov := box("moov",
+		box("mvhd", movieHeader(0, 600, 360000)),
+		video,
+		audio,
+		subtitle,
+		box("udta", neroChapters(1, Chapter{Start: 0, Title: "Opening"}, Chapter{Start: 90, Title: "Part A"})),
+	)
+
+	// the moov box comes after the media data, which has a 64 bit size
+	file := bytes.Join([][]byte{box("ftyp", []byte("isom"), make([]byte, 8)), largeBox("mdat", make([]byte, 100)), moov}, nil)
+
+	info, err := Read(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Info{
+		Container:  "mp4",
+		Duration:   600,
+		Width:      1920,
+		Height:     1080,
+		VideoCodec: "h264",
+		Tracks: []Track{
+			{Number: 1, Type: TrackVideo, Codec: "h264", CodecId: "avc1", Language: "jpn", Default: true, Width: 1920, Height: 1080},
+			{Number: 2, Type: TrackAudio, Codec: "aac", CodecId: "mp4a", Width: 640, Height: 360, Channels: 2, SampleRate: 48000},
+			{Number: 3, Type: TrackSubtitle, Codec: "mov_text", CodecId: "tx3g", Default: true, Width: 640, Height: 360},
+		},
+		Chapters: []Chapter{
+			{Start: 0, End: 90, Title: "Opening"},
+			{Start: 90, End: 600, Title: "Part A"},
+		},
+	}
+
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("Read\n got %+v\nwant %+v", info, want)
+	}
+}
+
+func TestReadMP4Container(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		want string
+		err  error
+	}{
+		{
+			name: "quicktime",
+			file: bytes.Join([][]byte{box("ftyp", []byte("qt  "), make([]byte, 4)), box("moov", box("mvhd", movieHeader(0, 1, 1)))}, nil),
+			want: "mov",
+		},
+		{
+			name: "without ftyp",
+			file: box("moov", box("mvhd", movieHeader(0, 1, 1))),
+			want: "mp4",
+		},
+		{
+			name: "without moov",
+			file: bytes.Join([][]byte{box("ftyp", []byte("isom"), make([]byte, 4)), box("mdat", make([]byte, 10))}, nil),
+			err:  errNoMovieBox,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := Read(bytes.NewReader(test.file), int64(len(test.file)))
+			if err != test.err {
+				t.Fatalf("Read error = %v, want %v", err, test.err)
+			}
+
+			if info.Container != test.want {
+				t.Errorf("Read container = %q, want %q", info.Container, test.want)
+			}
+		})
+	}
+}
+
+func TestReadMovieHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want float64
+	}{
+		{name: "version 0", data: movieHeader(0, 1000, 1500), want: 1.5},
+		{name: "version 1", data: movieHeader(1, 90000, 1<<33), want: float64(uint64(1)<<33) / 90000},
+		{name: "zero timescale", data: movieHeader(0, 0, 100), want: 0},
+		{name: "truncated version 0", data: movieHeader(0, 1000, 1500)[:19], want: 0},
+		{name: "truncated version 1", data: movieHeader(1, 1000, 1500)[:31], want: 0},
+		{name: "empty", data: nil, want: 0},
+	}
+
+	for _, test := range tests {
+		if got := readMovieHeader(test.data); got != test.want {
+			t.Errorf("readMovieHeader(%v) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReadNeroChapters(t *testing.T) {
+	chapters := []Chapter{{Start: 0, Title: "Opening"}, {Start: 95.5, Title: "Part A"}, {Start: 1200, Title: ""}}
+
+	tests := []struct {
+		name string
+		udta []byte
+		want []Chapter
+	}{
+		{name: "version 0", udta: neroChapters(0, chapters...), want: chapters},
+		{name: "version 1", udta: neroChapters(1, chapters...), want: chapters},
+		{name: "no chapters", udta: neroChapters(1), want: nil},
+		{
+			name: "truncated title",
+			udta: box("chpl", []byte{0, 0, 0, 0, 2}, neroChapter(chapters[0]), neroChapter(chapters[1])[:12]),
+			want: chapters[:1],
+		},
+		{
+			name: "count past the chapters",
+			udta: box("chpl", []byte{0, 0, 0, 0, 5}, neroChapter(chapters[0])),
+			want: chapters[:1],
+		},
+		{name: "only the header", udta: box("chpl", []byte{1, 0, 0, 0, 0, 0, 0, 0}), want: nil},
+		{name: "without chpl", udta: box("meta"), want: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := readNeroChapters(test.udta); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("readNeroChapters = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMP4Language(t *testing.T) {
+	tests := []struct {
+		packed uint16
+		want   string
+	}{
+		{packed: languageJpn, want: "jpn"},
+		{packed: languageUnd, want: ""},
+		{packed: 0, want: ""},
+	}
+
+	for _, test := range tests {
+		if got := mp4Language(test.packed); got != test.want {
+			t.Errorf("mp4Language(%#x) = %q, want %q", test.packed, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Package probe reads the headers of Matroska/WebM and MP4/MOV files to find
+// out their duration, tracks and chapters, without decoding any media.
+package probe
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+type TrackType string
+
+const (
+	TrackVideo    TrackType = "video"
+	TrackAudio    TrackType = "audio"
+	TrackSubtitle TrackType = "subtitle"
+	TrackOther    TrackType = "other"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown container format")
+	ErrNotMatroska   = errors.New("not a Matroska file")
+)
+
+// Info describes a media file, durations and times are in seconds.
+type Info struct {
+	Container  string    `json:"container"`
+	Duration   float64   `json:"duration"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	VideoCodec string    `json:"video_codec"`
+	Tracks     []Track   `json:"tracks"`
+	Chapters   []Chapter `json:"chapters"`
+}
+
+type Track struct {
+	Number     int       `json:"number"`
+	Type       TrackType `json:"type"`
+	Codec      string    `json:"codec"`
+	CodecId    string    `json:"codec_id"`
+	Language   string    `json:"language,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Default    bool      `json:"default"`
+	Forced     bool      `json:"forced"`
+	Width      int       `json:"width,omitempty"`
+	Height     int       `json:"height,omitempty"`
+	Channels   int       `json:"channels,omitempty"`
+	SampleRate float64   `json:"sample_rate,omitempty"`
+}
+
+type Chapter struct {
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Title    string  `json:"title"`
+	Language string  `json:"language,omitempty"`
+}
+
+// maxElementSize limits how much of a header element is read into memory.
+const maxElementSize = 64 << 20
+
+// File probes the file at the path.
+func File(path string) (Info, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return Info{}, err
+	}
+
+	return Read(file, stat.Size())
+}
+
+// Read probes a media file of the given size, detecting its container by its
+// first bytes.
+func Read(r io.ReadSeeker, size int64) (Info, error) {
+	magic := make([]byte, 12)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return Info{}, ErrUnknownFormat
+	}
+
+	var info Info
+	var err error
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		info, err = readMatroska(r, size)
+	case string(magic[4:8]) == "ftyp" || string(magic[4:8]) == "moov" || string(magic[4:8]) == "mdat":
+		info, err = readMP4(r, size)
+	default:
+		return Info{}, ErrUnknownFormat
+	}
+
+	if err != nil {
+		return Info{}, err
+	}
+
+	info.fillVideo()
+	info.fillChapterEnds()
+
+	return info, nil
+}
+
+// fillVideo copies the resolution and codec of the main video track.
+func (info *Info) fillVideo() {
+	if info.Tracks == nil {
+		info.Tracks = []Track{}
+	}
+
+	if info.Chapters == nil {
+		info.Chapters = []Chapter{}
+	}
+
+	var main *Track
+	for i, track := range info.Tracks {
+		if track.Type != TrackVideo {
+			continue
+		}
+
+		if main == nil || (track.Default && !main.Default) {
+			main = &info.Tracks[i]
+		}
+	}
+
+	if main != nil {
+		info.Width = main.Width
+		info.Height = main.Height
+		info.VideoCodec = main.Codec
+	}
+}
+
+// fillChapterEnds ends the chapters without an end where the next one starts.
+func (info *Info) fillChapterEnds() {
+	for i := range info.Chapters {
+		if info.Chapters[i].End > info.Chapters[i].Start {
+			continue
+		}
+
+		if i+1 < len(info.Chapters) {
+			info.Chapters[i].End = info.Chapters[i+1].Start
+		} else {
+			info.Chapters[i].End = info.Duration
+		}
+	}
+}
+
+var matroskaCodecs = map[string]string{
+	"V_MPEG4/ISO/AVC":  "h264",
+	"V_MPEGH/ISO/HEVC": "hevc",
+	"V_AV1":            "av1",
+	"V_VP8":            "vp8",
+	"V_VP9":            "vp9",
+	"V_MPEG4/ISO/ASP":  "mpeg4",
+	"V_MPEG4/ISO/SP":   "mpeg4",
+	"V_MPEG2":          "mpeg2",
+	"V_MPEG1":          "mpeg1",
+	"V_THEORA":         "theora",
+	"V_MS/VFW/FOURCC":  "vfw",
+	"A_OPUS":           "opus",
+	"A_VORBIS":         "vorbis",
+	"A_FLAC":           "flac",
+	"A_AC3":            "ac3",
+	"A_EAC3":           "eac3",
+	"A_TRUEHD":         "truehd",
+	"A_MPEG/L3":        "mp3",
+	"A_MPEG/L2":        "mp2",
+	"S_TEXT/UTF8":      "srt",
+	"S_TEXT/ASS":       "ass",
+	"S_TEXT/SSA":       "ssa",
+	"S_ASS":            "ass",
+	"S_SSA":            "ssa",
+	"S_TEXT/WEBVTT":    "webvtt",
+	"S_HDMV/PGS":       "pgs",
+	"S_VOBSUB":         "vobsub",
+	"S_DVBSUB":         "dvbsub",
+}
+
+var mp4Codecs = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp08": "vp8",
+	"vp09": "vp9",
+	"mp4v": "mpeg4",
+	"mp4a": "aac",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	"Opus": "opus",
+	"fLaC": "flac",
+	".mp3": "mp3",
+	"tx3g": "mov_text",
+	"wvtt": "webvtt",
+	"c608": "eia608",
+}
+
+func matroskaCodec(codecId string) string {
+	if codec, found := matroskaCodecs[codecId]; found {
+		return codec
+	}
+
+	switch {
+	case strings.HasPrefix(codecId, "A_AAC"):
+		return "aac"
+	case strings.HasPrefix(codecId, "A_DTS"):
+		return "dts"
+	case strings.HasPrefix(codecId, "A_PCM"):
+		return "pcm"
+	}
+
+	return strings.ToLower(codecId)
+}
+
+func mp4Codec(format string) string {
+	if codec, found := mp4Codecs[format]; found {
+		return codec
+	}
+
+	return strings.TrimSpace(strings.ToLower(format))
+}
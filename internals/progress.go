@@ -0,0 +1,75 @@
+package internals
+
+import (
+	"database/sql"
+	"time"
+)
+
+type VideoProgress struct {
+	Position  float64   `json:"position"`
+	Duration  float64   `json:"duration"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type VideoProgressPayload struct {
+	Position float64 `json:"position"`
+	Duration float64 `json:"duration"`
+}
+
+func (payload VideoProgressPayload) Valid() bool {
+	return payload.Duration > 0 &&
+		payload.Position >= 0 &&
+		payload.Position <= payload.Duration
+}
+
+func (repo VideoRepository) SaveProgress(id int32, position float64, duration float64) error {
+	_, err := repo.db.Exec(
+		`
+		insert into video_progress
+			(video_id, position, duration, updated_at)
+		values
+			(?, ?, ?, ?)
+		on conflict (video_id) do update set
+			position = excluded.position,
+			duration = excluded.duration,
+			updated_at = excluded.updated_at
+		`,
+		id,
+		position,
+		duration,
+		time.Now().UTC(),
+	)
+
+	return err
+}
+
+func (repo VideoRepository) FindProgress(id int32) (*VideoProgress, error) {
+	var progress VideoProgress
+	err := repo.db.QueryRow(
+		`
+		select
+			position,
+			duration,
+			updated_at
+		from
+			video_progress
+		where
+			video_id = ?
+		`,
+		id,
+	).Scan(&progress.Position, &progress.Duration, &progress.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &progress, nil
+}
+
+func (repo VideoRepository) DeleteProgress(id int32) error {
+	_, err := repo.db.Exec("delete from video_progress where video_id = ?", id)
+	return err
+}
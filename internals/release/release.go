@@ -0,0 +1,321 @@
+// Package release reads the metadata carried by the names of released videos,
+// like "[Group] Show Title - 05v2 (1080p) [ABCD1234].mkv" or
+// "Show.Title.S01E05.1080p.WEB-DL.x264-GROUP.mkv".
+package release
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ParserVersion is stored with the parsed videos, bumping it whenever Parse
+// changes its results makes them parsed again.
+const ParserVersion = 1
+
+// Info is what could be read from a filename, unknown values are left empty.
+type Info struct {
+	Group      string `json:"group,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Season     *int   `json:"season,omitempty"`
+	Episode    *int   `json:"episode,omitempty"`
+	EpisodeEnd *int   `json:"episode_end,omitempty"`
+	// Special is an episode released between Episode and the next one, like
+	// "12.5".
+	Special    bool   `json:"special,omitempty"`
+	Version    *int   `json:"version,omitempty"`
+	Resolution string `json:"resolution,omitempty"`
+	Source     string `json:"source,omitempty"`
+	CRC32      string `json:"crc32,omitempty"`
+}
+
+var (
+	leadingGroupPattern = regexp.MustCompile(`^\s*[\[【]([^\]】]+)[\]】]`)
+	bracketPattern      = regexp.MustCompile(`[\[\(\{【]([^\[\]\(\)\{\}【】]*)[\]\)\}】]`)
+	wordSplitPattern    = regexp.MustCompile(`[\s,_+]+`)
+	sceneGroupPattern   = regexp.MustCompile(`-([A-Za-z0-9]+)$`)
+
+	crcPattern        = regexp.MustCompile(`^[0-9A-Fa-f]{8}$`)
+	resolutionPattern = regexp.MustCompile(`(?i)^(\d{3,4})[pi]$`)
+	dimensionsPattern = regexp.MustCompile(`(?i)^\d{3,4}x(\d{3,4})$`)
+	versionPattern    = regexp.MustCompile(`(?i)^v(\d{1,2})$`)
+	yearPattern       = regexp.MustCompile(`^(19|20)\d{2}$`)
+
+	// the episode patterns, from the most to the least specific
+	seasonEpisodePattern   = regexp.MustCompile(`(?i)\bS(\d{1,2})[ ._-]?E(\d{1,4})(?:-?E(\d{1,4})|-(\d{1,4}))?(?:v(\d{1,2}))?\b`)
+	crossEpisodePattern    = regexp.MustCompile(`(?i)\b(\d{1,2})x(\d{2,3})\b`)
+	dashEpisodePattern     = regexp.MustCompile(`(?i)\s-\s(\d{1,4})(?:\.(\d))?(?:\s?[-~]\s?(\d{1,4}))?(?:v(\d{1,2}))?(?:\s|$)`)
+	namedEpisodePattern    = regexp.MustCompile(`(?i)\b(?:E|EP|Ep\.|Episode)\s?(\d{1,4})(?:\.(\d))?(?:v(\d{1,2}))?\b`)
+	trailingEpisodePattern = regexp.MustCompile(`(?i)\s(\d{1,3})(?:\.(\d))?(?:v(\d{1,2}))?$`)
+
+	titleSeasonPattern = regexp.MustCompile(`(?i)[\s-]+(?:S(\d{1,2})|Season\s?(\d{1,2})|(\d{1,2})(?:st|nd|rd|th)\s+Season)$`)
+)
+
+var sources = map[string]string{
+	"bd":      "BD",
+	"bdrip":   "BD",
+	"bdremux": "BD",
+	"bluray":  "BD",
+	"blu-ray": "BD",
+	"bdmv":    "BD",
+	"web":     "WEB",
+	"web-dl":  "WEB",
+	"webdl":   "WEB",
+	"webrip":  "WEB",
+	"web-rip": "WEB",
+	"hdtv":    "TV",
+	"tv":      "TV",
+	"tvrip":   "TV",
+	"dvd":     "DVD",
+	"dvdrip":  "DVD",
+	"dvd-rip": "DVD",
+}
+
+// ambiguousSources are only trusted inside brackets, as they also show up in
+// titles.
+var ambiguousSources = map[string]bool{"bd": true, "web": true, "tv": true, "dvd": true}
+
+// knownTags are recognized but not kept.
+var knownTags = map[string]bool{
+	"x264": true, "x265": true, "h264": true, "h265": true, "h.264": true, "h.265": true,
+	"avc": true, "hevc": true, "av1": true, "xvid": true, "divx": true, "vp9": true,
+	"8bit": true, "8-bit": true, "10bit": true, "10-bit": true, "hi10p": true, "hi10": true, "hdr": true,
+	"aac": true, "aac2.0": true, "flac": true, "opus": true, "ac3": true, "eac3": true, "dts": true, "mp3": true,
+	"ddp5.1": true, "dd5.1": true, "truehd": true, "atmos": true,
+	"dual-audio": true, "dual": true, "audio": true, "multi-sub": true, "multi-subs": true, "multisub": true,
+	"eng": true, "sub": true, "subs": true, "raw": true, "batch": true, "complete": true, "uncensored": true,
+	"remux": true, "proper": true, "repack": true, "end": true, "final": true,
+}
+
+// Parse reads the release information of a filename, the folders and the
+// extension of the filename are ignored.
+func Parse(filename string) Info {
+	var info Info
+
+	name := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+
+	if match := leadingGroupPattern.FindStringSubmatch(name); match != nil {
+		metadata := info
+		if classifyAll(&metadata, match[1], true) {
+			info = metadata
+		} else {
+			info.Group = strings.TrimSpace(match[1])
+		}
+
+		name = name[len(match[0]):]
+	}
+
+	name = bracketPattern.ReplaceAllStringFunc(name, func(bracket string) string {
+		classifyAll(&info, bracketPattern.FindStringSubmatch(bracket)[1], true)
+		return " "
+	})
+
+	// scene releases separate words with dots, fansubs with spaces
+	name = strings.ReplaceAll(name, "_", " ")
+	if !strings.Contains(strings.TrimSpace(name), " ") {
+		name = strings.ReplaceAll(name, ".", " ")
+	}
+
+	words := strings.Fields(name)
+
+	// the scene group is appended to the last tag, like "x264-GROUP", unless
+	// the hyphen belongs to the tag, like "WEB-DL"
+	tags := append([]string(nil), words...)
+	var sceneGroup string
+	if last := len(tags) - 1; last > 0 && !classifyWord(&Info{}, tags[last], false) {
+		if match := sceneGroupPattern.FindStringSubmatchIndex(tags[last]); match != nil {
+			sceneGroup = tags[last][match[2]:match[3]]
+			tags[last] = tags[last][:match[0]]
+		}
+	}
+
+	for i, tag := range tags {
+		metadata := info
+		if !classifyWord(&metadata, tag, false) {
+			continue
+		}
+
+		if info.Group == "" {
+			info.Group = sceneGroup
+		}
+
+		// what follows the first tag is trusted like the inside of brackets
+		for _, tag := range tags[i:] {
+			classifyWord(&info, tag, true)
+		}
+
+		words = words[:i]
+		break
+	}
+
+	parseEpisode(&info, strings.Join(words, " "))
+	parseTitleSeason(&info)
+
+	return info
+}
+
+// parseEpisode finds the season and episode in the text, the title is what
+// comes before them.
+func parseEpisode(info *Info, text string) {
+	if match := seasonEpisodePattern.FindStringSubmatchIndex(text); match != nil {
+		info.Season = intGroup(text, match, 1)
+		info.Episode = intGroup(text, match, 2)
+		info.EpisodeEnd = intGroup(text, match, 3)
+		if info.EpisodeEnd == nil {
+			info.EpisodeEnd = intGroup(text, match, 4)
+		}
+		setVersion(info, intGroup(text, match, 5))
+		info.Title = cleanTitle(text[:match[0]])
+		return
+	}
+
+	if match := crossEpisodePattern.FindStringSubmatchIndex(text); match != nil {
+		info.Season = intGroup(text, match, 1)
+		info.Episode = intGroup(text, match, 2)
+		info.Title = cleanTitle(text[:match[0]])
+		return
+	}
+
+	if match := dashEpisodePattern.FindStringSubmatchIndex(text); match != nil {
+		info.Episode = intGroup(text, match, 1)
+		setSpecial(info, intGroup(text, match, 2))
+		info.EpisodeEnd = intGroup(text, match, 3)
+		setVersion(info, intGroup(text, match, 4))
+		info.Title = cleanTitle(text[:match[0]])
+		return
+	}
+
+	if match := namedEpisodePattern.FindStringSubmatchIndex(text); match != nil {
+		info.Episode = intGroup(text, match, 1)
+		setSpecial(info, intGroup(text, match, 2))
+		setVersion(info, intGroup(text, match, 3))
+		info.Title = cleanTitle(text[:match[0]])
+		return
+	}
+
+	if match := trailingEpisodePattern.FindStringSubmatchIndex(text); match != nil {
+		info.Episode = intGroup(text, match, 1)
+		setSpecial(info, intGroup(text, match, 2))
+		setVersion(info, intGroup(text, match, 3))
+		info.Title = cleanTitle(text[:match[0]])
+		return
+	}
+
+	info.Title = cleanTitle(text)
+}
+
+// parseTitleSeason moves a season written at the end of the title, like
+// "Show S2" or "Show 2nd Season", to the season.
+func parseTitleSeason(info *Info) {
+	match := titleSeasonPattern.FindStringSubmatchIndex(info.Title)
+	if match == nil || match[0] == 0 {
+		return
+	}
+
+	for group := 1; group <= 3; group++ {
+		if season := intGroup(info.Title, match, group); season != nil {
+			if info.Season == nil {
+				info.Season = season
+			}
+			break
+		}
+	}
+
+	info.Title = cleanTitle(info.Title[:match[0]])
+}
+
+// classifyAll classifies every word of the text, telling if all of them were
+// recognized.
+func classifyAll(info *Info, text string, bracketed bool) bool {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return true
+	}
+
+	if crcPattern.MatchString(text) {
+		info.CRC32 = strings.ToUpper(text)
+		return true
+	}
+
+	recognized := true
+	for _, word := range wordSplitPattern.Split(text, -1) {
+		if word != "" && !classifyWord(info, word, bracketed) {
+			recognized = false
+		}
+	}
+
+	return recognized
+}
+
+func classifyWord(info *Info, word string, bracketed bool) bool {
+	lower := strings.ToLower(word)
+
+	if match := resolutionPattern.FindStringSubmatch(word); match != nil {
+		info.Resolution = match[1] + "p"
+		return true
+	}
+
+	if match := dimensionsPattern.FindStringSubmatch(word); match != nil {
+		info.Resolution = match[1] + "p"
+		return true
+	}
+
+	if lower == "4k" || lower == "uhd" {
+		info.Resolution = "2160p"
+		return true
+	}
+
+	if source, found := sources[lower]; found && (bracketed || !ambiguousSources[lower]) {
+		info.Source = source
+		return true
+	}
+
+	if knownTags[lower] {
+		return true
+	}
+
+	if !bracketed {
+		return false
+	}
+
+	if match := versionPattern.FindStringSubmatch(word); match != nil {
+		version, _ := strconv.Atoi(match[1])
+		setVersion(info, &version)
+		return true
+	}
+
+	return yearPattern.MatchString(word)
+}
+
+func setVersion(info *Info, version *int) {
+	if version != nil && info.Version == nil {
+		info.Version = version
+	}
+}
+
+// setSpecial marks the episode as special when it has a fraction, "12.0" is
+// just episode 12.
+func setSpecial(info *Info, fraction *int) {
+	info.Special = fraction != nil && *fraction != 0
+}
+
+// intGroup reads a numeric submatch, nil when the group didn't match.
+func intGroup(text string, match []int, group int) *int {
+	start, end := match[2*group], match[2*group+1]
+	if start < 0 {
+		return nil
+	}
+
+	value, err := strconv.Atoi(text[start:end])
+	if err != nil {
+		return nil
+	}
+
+	return &value
+}
+
+func cleanTitle(title string) string {
+	return strings.Trim(strings.Join(strings.Fields(title), " "), " -_.~:")
+}
@@ -0,0 +1,216 @@
+package release
+
+import (
+	"reflect"
+	"testing"
+)
+
+func number(value int) *int {
+	return &value
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     Info
+	}{
+		{
+			filename: "[Group] Show Title - 05v2 (1080p) [ABCD1234].mkv",
+			want:     Info{Group: "Group", Title: "Show Title", Episode: number(5), Version: number(2), Resolution: "1080p", CRC32: "ABCD1234"},
+		},
+		{
+			filename: "[SubsPlease] Some Show - 12 (720p) [0A1B2C3D].mkv",
+			want:     Info{Group: "SubsPlease", Title: "Some Show", Episode: number(12), Resolution: "720p", CRC32: "0A1B2C3D"},
+		},
+		{
+			filename: "[Group] Show - 01-12 (BD 1080p) [Batch]",
+			want:     Info{Group: "Group", Title: "Show", Episode: number(1), EpisodeEnd: number(12), Resolution: "1080p", Source: "BD"},
+		},
+		{
+			filename: "[Group] Show - 12.5 [1080p].mkv",
+			want:     Info{Group: "Group", Title: "Show", Episode: number(12), Special: true, Resolution: "1080p"},
+		},
+		{
+			filename: "Show - 12.5.mkv",
+			want:     Info{Title: "Show", Episode: number(12), Special: true},
+		},
+		{
+			filename: "Show - 12.0.mkv",
+			want:     Info{Title: "Show", Episode: number(12)},
+		},
+		{
+			filename: "[Group] Show Episode 7.5 [720p].mkv",
+			want:     Info{Group: "Group", Title: "Show", Episode: number(7), Special: true, Resolution: "720p"},
+		},
+		{
+			filename: "[Group] Show 2nd Season - 03 [1080p].mkv",
+			want:     Info{Group: "Group", Title: "Show", Season: number(2), Episode: number(3), Resolution: "1080p"},
+		},
+		{
+			filename: "[Group] Show S2 - 03 [1080p].mkv",
+			want:     Info{Group: "Group", Title: "Show", Season: number(2), Episode: number(3), Resolution: "1080p"},
+		},
+		{
+			filename: "[Group] Show Season 3 - 10 (1920x1080 HEVC 10bit).mkv",
+			want:     Info{Group: "Group", Title: "Show", Season: number(3), Episode: number(10), Resolution: "1080p"},
+		},
+		{
+			filename: "[Group] Show - 03 [WEB 1080p][v2].mkv",
+			want:     Info{Group: "Group", Title: "Show", Episode: number(3), Version: number(2), Resolution: "1080p", Source: "WEB"},
+		},
+		{
+			filename: "[1080p] Show - 04.mkv",
+			want:     Info{Title: "Show", Episode: number(4), Resolution: "1080p"},
+		},
+		{
+			filename: "【Group】Show - 08【1080p】.mp4",
+			want:     Info{Group: "Group", Title: "Show", Episode: number(8), Resolution: "1080p"},
+		},
+		{
+			filename: "[Group] Show EP09 [1080p].mkv",
+			want:     Info{Group: "Group", Title: "Show", Episode: number(9), Resolution: "1080p"},
+		},
+		{
+			filename: "[Group] Show 11 [720p].mkv",
+			want:     Info{Group: "Group", Title: "Show", Episode: number(11), Resolution: "720p"},
+		},
+		{
+			filename: "[Group] Show 11v3.mkv",
+			want:     Info{Group: "Group", Title: "Show", Episode: number(11), Version: number(3)},
+		},
+		{
+			filename: "[Group]_Show_Title_-_02_[480p].mkv",
+			want:     Info{Group: "Group", Title: "Show Title", Episode: number(2), Resolution: "480p"},
+		},
+		{
+			filename: "Show.Title.S01E05.1080p.WEB-DL.x264-GROUP.mkv",
+			want:     Info{Group: "GROUP", Title: "Show Title", Season: number(1), Episode: number(5), Resolution: "1080p", Source: "WEB"},
+		},
+		{
+			filename: "Show.Title.S01E05.720p.WEB-Rip.x265-GROUP.mkv",
+			want:     Info{Group: "GROUP", Title: "Show Title", Season: number(1), Episode: number(5), Resolution: "720p", Source: "WEB"},
+		},
+		{
+			filename: "Show.Title.S01E05.1080p.WEB-DL.mkv",
+			want:     Info{Title: "Show Title", Season: number(1), Episode: number(5), Resolution: "1080p", Source: "WEB"},
+		},
+		{
+			filename: "Show.Title.S02E10.HDTV.x264-GRP.mp4",
+			want:     Info{Group: "GRP", Title: "Show Title", Season: number(2), Episode: number(10), Source: "TV"},
+		},
+		{
+			filename: "Show.Title.S03E01E02.1080p.BluRay.x264-GRP.mkv",
+			want:     Info{Group: "GRP", Title: "Show Title", Season: number(3), Episode: number(1), EpisodeEnd: number(2), Resolution: "1080p", Source: "BD"},
+		},
+		{
+			filename: "Show.Title.S03E01-02.2160p.BDRip.x265-GRP.mkv",
+			want:     Info{Group: "GRP", Title: "Show Title", Season: number(3), Episode: number(1), EpisodeEnd: number(2), Resolution: "2160p", Source: "BD"},
+		},
+		{
+			filename: "Show Title S01E05v2 1080p.mkv",
+			want:     Info{Title: "Show Title", Season: number(1), Episode: number(5), Version: number(2), Resolution: "1080p"},
+		},
+		{
+			filename: "Show Title 1x05 720p.mkv",
+			want:     Info{Title: "Show Title", Season: number(1), Episode: number(5), Resolution: "720p"},
+		},
+		{
+			filename: "Show Title - S01E05 - Episode Name.mkv",
+			want:     Info{Title: "Show Title", Season: number(1), Episode: number(5)},
+		},
+		{
+			filename: "Show.Title.2019.S01E01.1080p.WEB.h264-GRP.mkv",
+			want:     Info{Group: "GRP", Title: "Show Title 2019", Season: number(1), Episode: number(1), Resolution: "1080p", Source: "WEB"},
+		},
+		{
+			filename: "Movie.Title.2019.1080p.BluRay.x264-GRP.mkv",
+			want:     Info{Group: "GRP", Title: "Movie Title 2019", Resolution: "1080p", Source: "BD"},
+		},
+		{
+			filename: "Movie.Title.4K.HDR.mkv",
+			want:     Info{Title: "Movie Title", Resolution: "2160p"},
+		},
+		{
+			filename: "Show - Re-Zero.mkv",
+			want:     Info{Title: "Show - Re-Zero"},
+		},
+		{
+			filename: "Some Movie.mkv",
+			want:     Info{Title: "Some Movie"},
+		},
+		{
+			filename: "Some Movie (2019).mkv",
+			want:     Info{Title: "Some Movie"},
+		},
+		{
+			filename: "The Web Show - 02.mkv",
+			want:     Info{Title: "The Web Show", Episode: number(2)},
+		},
+		{
+			filename: "Nick Video.mkv",
+			want:     Info{Title: "Nick Video"},
+		},
+		{
+			filename: "[ABCD1234] Show - 06.mkv",
+			want:     Info{Title: "Show", Episode: number(6), CRC32: "ABCD1234"},
+		},
+		{
+			filename: "series/Show/[Group] Show - 07 [1080p].mkv",
+			want:     Info{Group: "Group", Title: "Show", Episode: number(7), Resolution: "1080p"},
+		},
+		{
+			filename: `C:\Videos\Show\[Group] Show - 07 [1080p].mkv`,
+			want:     Info{Group: "Group", Title: "Show", Episode: number(7), Resolution: "1080p"},
+		},
+		{
+			filename: "[Group] Show - 05 (BDRip 1080p Hi10P Dual-Audio FLAC) [ABCD1234].mkv",
+			want:     Info{Group: "Group", Title: "Show", Episode: number(5), Resolution: "1080p", Source: "BD", CRC32: "ABCD1234"},
+		},
+		{
+			filename: "",
+			want:     Info{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filename, func(t *testing.T) {
+			got := Parse(test.filename)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Parse(%q)\n got %+v\nwant %+v", test.filename, describe(got), describe(test.want))
+			}
+		})
+	}
+}
+
+// describe prints the pointers of the info as their values.
+func describe(info Info) map[string]any {
+	values := map[string]any{}
+	for name, value := range map[string]*int{
+		"Season":     info.Season,
+		"Episode":    info.Episode,
+		"EpisodeEnd": info.EpisodeEnd,
+		"Version":    info.Version,
+	} {
+		if value != nil {
+			values[name] = *value
+		}
+	}
+
+	for name, value := range map[string]string{
+		"Group":      info.Group,
+		"Title":      info.Title,
+		"Resolution": info.Resolution,
+		"Source":     info.Source,
+		"CRC32":      info.CRC32,
+	} {
+		if value != "" {
+			values[name] = value
+		}
+	}
+
+	if info.Special {
+		values["Special"] = true
+	}
+
+	return values
+}
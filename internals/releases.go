@@ -0,0 +1,167 @@
+package internals
+
+import (
+	"database/sql"
+	"go-video-viewer/internals/release"
+)
+
+// releaseColumns are the release columns of a video as read from the database.
+type releaseColumns struct {
+	Group      sql.NullString
+	Title      sql.NullString
+	Season     sql.NullInt64
+	Episode    sql.NullInt64
+	EpisodeEnd sql.NullInt64
+	Special    bool
+	Version    sql.NullInt64
+	Resolution sql.NullString
+	Source     sql.NullString
+	CRC32      sql.NullString
+}
+
+func (columns releaseColumns) toInfo() release.Info {
+	return release.Info{
+		Group:      columns.Group.String,
+		Title:      columns.Title.String,
+		Season:     nullableInt(columns.Season),
+		Episode:    nullableInt(columns.Episode),
+		EpisodeEnd: nullableInt(columns.EpisodeEnd),
+		Special:    columns.Special,
+		Version:    nullableInt(columns.Version),
+		Resolution: columns.Resolution.String,
+		Source:     columns.Source.String,
+		CRC32:      columns.CRC32.String,
+	}
+}
+
+func nullableInt(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+
+	number := int(value.Int64)
+	return &number
+}
+
+func nullableText(text string) any {
+	if text == "" {
+		return nil
+	}
+
+	return text
+}
+
+// parseReleases fills the release columns of the videos that match the filter
+// by parsing their filenames, and puts them in the series of their title unless
+// it was set by hand.
+func parseReleases(tx *sql.Tx, filter string, args ...any) error {
+	type parsedVideo struct {
+		id           int32
+		libraryId    int32
+		filename     string
+		manualSeries bool
+	}
+
+	rows, err := tx.Query("select id, library_id, filename, series_manual from videos where "+filter, args...)
+	if err != nil {
+		return err
+	}
+
+	var videos []parsedVideo
+	for rows.Next() {
+		var video parsedVideo
+		if err = rows.Scan(&video.id, &video.libraryId, &video.filename, &video.manualSeries); err != nil {
+			rows.Close()
+			return err
+		}
+
+		videos = append(videos, video)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		update videos set
+			release_group = ?,
+			series_title = ?,
+			season = ?,
+			episode = ?,
+			episode_end = ?,
+			special = ?,
+			release_version = ?,
+			resolution = ?,
+			source = ?,
+			crc32 = ?,
+			checksum_status = iif(crc32 is ?, checksum_status, null),
+			series_id = iif(series_manual, series_id, ?),
+			release_parser = ?
+		where
+			id = ?
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, video := range videos {
+		info := release.Parse(video.filename)
+
+		var seriesId sql.NullInt32
+		// without an episode the title is likely just the name of the file
+		if info.Title != "" && info.Episode != nil && !video.manualSeries {
+			seriesId, err = ensureSeries(tx, video.libraryId, info.Title, info.Season)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = stmt.Exec(
+			nullableText(info.Group),
+			nullableText(info.Title),
+			info.Season,
+			info.Episode,
+			info.EpisodeEnd,
+			info.Special,
+			info.Version,
+			nullableText(info.Resolution),
+			nullableText(info.Source),
+			nullableText(info.CRC32),
+			nullableText(info.CRC32),
+			seriesId,
+			release.ParserVersion,
+			video.id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(videos) == 0 {
+		return nil
+	}
+
+	return deleteEmptySeries(tx)
+}
+
+// parseNewReleases parses the videos that were never parsed, or were parsed by
+// an older version of the parser.
+func parseNewReleases(tx *sql.Tx) error {
+	return parseReleases(tx, "release_parser is not ?", release.ParserVersion)
+}
+
+func (repo VideoRepository) parsePendingReleases() error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = parseNewReleases(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,364 @@
+package remux
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+)
+
+// Flags of the samples in the fragments, keyframes don't depend on others.
+const (
+	sampleKeyframe    = 0x02000000
+	sampleNotKeyframe = 0x01010000
+)
+
+// Flags of the track runs, telling which fields each sample has.
+const (
+	runDataOffset        = 0x000001
+	runSampleDuration    = 0x000100
+	runSampleSize        = 0x000200
+	runSampleFlags       = 0x000400
+	runCompositionOffset = 0x000800
+)
+
+// fragmentBaseIsMoof makes the data offsets of the runs relative to the start
+// of the fragment.
+const fragmentBaseIsMoof = 0x020000
+
+// identityMatrix is the transformation of the movie and of its tracks.
+var identityMatrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// box writes an ISO BMFF box, its content being the parts one after the other.
+func box(kind string, parts ...[]byte) []byte {
+	size := 8
+	for _, part := range parts {
+		size += len(part)
+	}
+
+	out := make([]byte, 0, size)
+	out = binary.BigEndian.AppendUint32(out, uint32(size))
+	out = append(out, kind...)
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+
+	return out
+}
+
+// fullBox writes a box whose content starts with a version and flags.
+func fullBox(kind string, version byte, flags uint32, parts ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return box(kind, append([][]byte{header}, parts...)...)
+}
+
+// fields appends big endian integers, strings and zeros to the content of a
+// box.
+type fields []byte
+
+func (f fields) u8(value uint8) fields {
+	return append(f, value)
+}
+
+func (f fields) u16(value uint16) fields {
+	return binary.BigEndian.AppendUint16(f, value)
+}
+
+func (f fields) u24(value uint32) fields {
+	return append(f, byte(value>>16), byte(value>>8), byte(value))
+}
+
+func (f fields) u32(value uint32) fields {
+	return binary.BigEndian.AppendUint32(f, value)
+}
+
+func (f fields) u64(value uint64) fields {
+	return binary.BigEndian.AppendUint64(f, value)
+}
+
+func (f fields) text(value string) fields {
+	return append(f, value...)
+}
+
+func (f fields) zeros(count int) fields {
+	return append(f, make([]byte, count)...)
+}
+
+func (f fields) matrix() fields {
+	for _, value := range identityMatrix {
+		f = f.u32(value)
+	}
+
+	return f
+}
+
+// initSegment writes the header of the stream, which describes the tracks and
+// indexes the fragments that follow it.
+func initSegment(tracks []track, index Index) []byte {
+	ftyp := box("ftyp", fields{}.text("isom").u32(0x200).text("isomiso6mp41"))
+
+	mvhd := fullBox("mvhd", 1, 0, fields{}.
+		u64(0).
+		u64(0).
+		u32(timescale).
+		u64(uint64(index.Duration)).
+		u32(0x00010000).
+		u16(0x0100).
+		zeros(10).
+		matrix().
+		zeros(24).
+		u32(uint32(len(tracks)+1)))
+
+	moov := [][]byte{mvhd}
+	var trex [][]byte
+
+	for _, track := range tracks {
+		moov = append(moov, trackBox(track, index))
+		trex = append(trex, fullBox("trex", 0, 0, fields{}.u32(track.id).u32(1).u32(0).u32(0).u32(0)))
+	}
+
+	mehd := fullBox("mehd", 1, 0, fields{}.u64(uint64(index.Duration)))
+	moov = append(moov, box("mvex", append([][]byte{mehd}, trex...)...))
+
+	return append(append(ftyp, box("moov", moov...)...), segmentIndex(index)...)
+}
+
+func trackBox(track track, index Index) []byte {
+	volume, width, height := uint16(0x0100), 0, 0
+	if track.video {
+		volume, width, height = 0, track.entry.Width, track.entry.Height
+	}
+
+	tkhd := fullBox("tkhd", 1, 0x000003, fields{}.
+		u64(0).
+		u64(0).
+		u32(track.id).
+		zeros(4).
+		u64(uint64(index.Duration)).
+		zeros(8).
+		u16(0).
+		u16(0).
+		u16(volume).
+		zeros(2).
+		matrix().
+		u32(uint32(width)<<16).
+		u32(uint32(height)<<16))
+
+	parts := [][]byte{tkhd}
+
+	// the composition offsets of reordered frames are shifted by the delay,
+	// the edit list takes it back
+	if track.video && index.Delay > 0 {
+		elst := fullBox("elst", 1, 0, fields{}.u32(1).u64(uint64(index.Duration)).u64(uint64(index.Delay)).u16(1).u16(0))
+		parts = append(parts, box("edts", elst))
+	}
+
+	mdhd := fullBox("mdhd", 1, 0, fields{}.
+		u64(0).
+		u64(0).
+		u32(timescale).
+		u64(uint64(index.Duration)).
+		u16(packLanguage(track.entry.Language)).
+		u16(0))
+
+	handler, name, header := "soun", "SoundHandler", fullBox("smhd", 0, 0, fields{}.zeros(4))
+	if track.video {
+		handler, name, header = "vide", "VideoHandler", fullBox("vmhd", 0, 1, fields{}.zeros(8))
+	}
+
+	hdlr := fullBox("hdlr", 0, 0, fields{}.u32(0).text(handler).zeros(12).text(name).u8(0))
+	dinf := box("dinf", fullBox("dref", 0, 0, fields{}.u32(1), fullBox("url ", 0, 1)))
+	stbl := box(
+		"stbl",
+		fullBox("stsd", 0, 0, fields{}.u32(1), sampleEntry(track)),
+		fullBox("stts", 0, 0, fields{}.u32(0)),
+		fullBox("stsc", 0, 0, fields{}.u32(0)),
+		fullBox("stsz", 0, 0, fields{}.u32(0).u32(0)),
+		fullBox("stco", 0, 0, fields{}.u32(0)),
+	)
+
+	minf := box("minf", header, dinf, stbl)
+	parts = append(parts, box("mdia", mdhd, hdlr, minf))
+
+	return box("trak", parts...)
+}
+
+// sampleEntry describes the codec of the track, its configuration comes from
+// the codec private data of the Matroska track.
+func sampleEntry(track track) []byte {
+	if track.video {
+		return box("avc1", fields{}.
+			zeros(6).
+			u16(1).
+			zeros(16).
+			u16(uint16(track.entry.Width)).
+			u16(uint16(track.entry.Height)).
+			u32(0x00480000).
+			u32(0x00480000).
+			zeros(4).
+			u16(1).
+			zeros(32).
+			u16(0x0018).
+			u16(0xFFFF),
+			box("avcC", track.entry.CodecPrivate),
+		)
+	}
+
+	rate := min(uint32(track.entry.SampleRate), 0xFFFF)
+	return box("mp4a", fields{}.
+		zeros(6).
+		u16(1).
+		zeros(8).
+		u16(uint16(track.entry.Channels)).
+		u16(16).
+		zeros(4).
+		u32(rate<<16),
+		fullBox("esds", 0, 0, elementaryStream(track)),
+	)
+}
+
+// elementaryStream writes the descriptors of an AAC stream.
+func elementaryStream(track track) []byte {
+	decoderSpecific := descriptor(0x05, track.config)
+	decoderConfig := descriptor(0x04, fields{}.u8(0x40).u8(0x15).u24(0).u32(0).u32(0), decoderSpecific)
+	slConfig := descriptor(0x06, []byte{0x02})
+
+	return descriptor(0x03, fields{}.u16(uint16(track.id)).u8(0), decoderConfig, slConfig)
+}
+
+// descriptor writes an MPEG-4 descriptor, whose size takes 7 bits of each byte.
+func descriptor(tag byte, parts ...[]byte) []byte {
+	var content []byte
+	for _, part := range parts {
+		content = append(content, part...)
+	}
+
+	size := len(content)
+	out := []byte{tag}
+	for shift := 21; shift > 0; shift -= 7 {
+		if size >= 1<<shift {
+			out = append(out, byte(size>>shift)|0x80)
+		}
+	}
+	out = append(out, byte(size&0x7F))
+
+	return append(out, content...)
+}
+
+// segmentIndex lists the size and duration of every fragment, so players can
+// turn a time into the range of bytes to request.
+func segmentIndex(index Index) []byte {
+	var earliest int64
+	if len(index.Fragments) > 0 {
+		earliest = index.Fragments[0].Time + index.Delay
+	}
+
+	content := fields{}.
+		u32(1).
+		u32(timescale).
+		u64(uint64(max(earliest, 0))).
+		u64(0).
+		u16(0).
+		u16(uint16(len(index.Fragments)))
+
+	for i, fragment := range index.Fragments {
+		end := index.Duration
+		if i+1 < len(index.Fragments) {
+			end = index.Fragments[i+1].Time
+		}
+
+		content = content.
+			u32(uint32(fragment.Size) & 0x7FFFFFFF).
+			u32(uint32(max(end-fragment.Time, 0))).
+			u32(0x90000000)
+	}
+
+	return fullBox("sidx", 1, 0, content)
+}
+
+// packLanguage packs an ISO 639-2 code in 15 bits, other codes become
+// undetermined.
+func packLanguage(language string) uint16 {
+	if len(language) != 3 || strings.ToLower(language) != language || strings.Trim(language, "abcdefghijklmnopqrstuvwxyz") != "" {
+		language = "und"
+	}
+
+	return uint16(language[0]-0x60)<<10 | uint16(language[1]-0x60)<<5 | uint16(language[2]-0x60)
+}
+
+// fragmentBoxes writes the header of a fragment, which describes its samples,
+// and returns the size of their data.
+func fragmentBoxes(sequence int, tracks []track, samples []sample, delay int64) ([]byte, int64) {
+	runs := make([][]sample, len(tracks))
+	for _, sample := range samples {
+		runs[sample.track] = append(runs[sample.track], sample)
+	}
+
+	build := func(moofSize int) ([]byte, int64) {
+		parts := [][]byte{fullBox("mfhd", 0, 0, fields{}.u32(uint32(sequence)))}
+		dataOffset := int64(moofSize + 8)
+
+		for i, run := range runs {
+			if len(run) == 0 {
+				continue
+			}
+
+			parts = append(parts, trackFragment(tracks[i], run, delay, dataOffset))
+			for _, sample := range run {
+				dataOffset += int64(sample.size)
+			}
+		}
+
+		return box("moof", parts...), dataOffset - int64(moofSize+8)
+	}
+
+	// the data offsets depend on the size of the header, which doesn't
+	moof, _ := build(0)
+	return build(len(moof))
+}
+
+// trackFragment writes the samples of a track in a fragment. Matroska stores
+// the presentation times of reordered frames, their decoding times are the
+// same times sorted.
+func trackFragment(track track, run []sample, delay int64, dataOffset int64) []byte {
+	decode := make([]int64, len(run))
+	for i, sample := range run {
+		decode[i] = sample.pts
+	}
+
+	if track.video {
+		slices.Sort(decode)
+	}
+
+	flags := uint32(runDataOffset | runSampleDuration | runSampleSize | runSampleFlags)
+	if track.video {
+		flags |= runCompositionOffset
+	}
+
+	content := fields{}.u32(uint32(len(run))).u32(uint32(dataOffset))
+	for i, sample := range run {
+		duration := sample.duration
+		switch {
+		case i+1 < len(run):
+			duration = decode[i+1] - decode[i]
+		case duration <= 0 && i > 0:
+			duration = decode[i] - decode[i-1]
+		}
+
+		sampleFlags := uint32(sampleNotKeyframe)
+		if sample.keyframe || !track.video {
+			sampleFlags = sampleKeyframe
+		}
+
+		content = content.u32(uint32(max(duration, 0))).u32(uint32(sample.size)).u32(sampleFlags)
+		if track.video {
+			content = content.u32(uint32(sample.pts + delay - decode[i]))
+		}
+	}
+
+	return box(
+		"traf",
+		fullBox("tfhd", 0, fragmentBaseIsMoof, fields{}.u32(track.id)),
+		fullBox("tfdt", 1, 0, fields{}.u64(uint64(max(decode[0], 0)))),
+		fullBox("trun", 0, flags, content),
+	)
+}
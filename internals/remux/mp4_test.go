@@ -0,0 +1,324 @@
+package remux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"go-video-viewer/internals/probe"
+	"reflect"
+	"testing"
+)
+
+// mp4Box is a box read back from the output, content is what follows its
+// size and kind.
+type mp4Box struct {
+	kind    string
+	content []byte
+}
+
+// readBoxes splits the data into the boxes it's made of, failing the test when
+// their sizes don't add up to the data.
+func readBoxes(t *testing.T, data []byte) []mp4Box {
+	t.Helper()
+
+	var boxes []mp4Box
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("%v bytes left after the boxes", len(data))
+		}
+
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			t.Fatalf("box '%s' of %v bytes, %v bytes left", data[4:8], size, len(data))
+		}
+
+		boxes = append(boxes, mp4Box{kind: string(data[4:8]), content: data[8:size]})
+		data = data[size:]
+	}
+
+	return boxes
+}
+
+// boxKinds lists the kinds of the boxes, in order.
+func boxKinds(boxes []mp4Box) []string {
+	var kinds []string
+	for _, box := range boxes {
+		kinds = append(kinds, box.kind)
+	}
+
+	return kinds
+}
+
+// u32s reads the big endian integers of the content of a box.
+func u32s(data []byte) []uint32 {
+	var values []uint32
+	for ; len(data) >= 4; data = data[4:] {
+		values = append(values, binary.BigEndian.Uint32(data))
+	}
+
+	return values
+}
+
+func testLayout() []track {
+	return []track{
+		{
+			id:    1,
+			video: true,
+			entry: probe.TrackEntry{
+				Track:        probe.Track{Number: 1, Type: probe.TrackVideo, Codec: "h264", Width: 320, Height: 240},
+				CodecPrivate: []byte("avc config"),
+			},
+		},
+		{
+			id:     2,
+			entry:  probe.TrackEntry{Track: probe.Track{Number: 2, Type: probe.TrackAudio, Codec: "aac", Language: "jpn", Channels: 2, SampleRate: 48000}},
+			config: []byte{0x11, 0x90},
+		},
+	}
+}
+
+func TestBox(t *testing.T) {
+	got := box("free", []byte("ab"), []byte("c"))
+	want := []byte{0, 0, 0, 11, 'f', 'r', 'e', 'e', 'a', 'b', 'c'}
+	if !bytes.Equal(got, want) {
+		t.Errorf("box = %v, want %v", got, want)
+	}
+
+	got = fullBox("mfhd", 1, 0x020001, fields{}.u32(7))
+	want = []byte{0, 0, 0, 16, 'm', 'f', 'h', 'd', 1, 0x02, 0x00, 0x01, 0, 0, 0, 7}
+	if !bytes.Equal(got, want) {
+		t.Errorf("fullBox = %v, want %v", got, want)
+	}
+
+	got = fields{}.u8(1).u16(0x0203).u24(0x040506).u64(7).text("ab").zeros(2)
+	want = []byte{1, 2, 3, 4, 5, 6, 0, 0, 0, 0, 0, 0, 0, 7, 'a', 'b', 0, 0}
+	if !bytes.Equal(got, want) {
+		t.Errorf("fields = %v, want %v", got, want)
+	}
+}
+
+func TestDescriptor(t *testing.T) {
+	if got, want := descriptor(0x05, []byte{1}, []byte{2}), []byte{0x05, 2, 1, 2}; !bytes.Equal(got, want) {
+		t.Errorf("descriptor = %v, want %v", got, want)
+	}
+
+	// sizes past 127 take more than a byte, with the high bit telling more follow
+	got := descriptor(0x04, make([]byte, 200))
+	if want := []byte{0x04, 0x81, 0x48}; !bytes.Equal(got[:3], want) || len(got) != 203 {
+		t.Errorf("descriptor of 200 bytes starts with %v and has %v bytes, want %v and 203", got[:3], len(got), want)
+	}
+}
+
+func TestPackLanguage(t *testing.T) {
+	tests := []struct {
+		language string
+		want     uint16
+	}{
+		{language: "jpn", want: 0x2A0E},
+		{language: "und", want: 0x55C4},
+		{language: "Jpn", want: 0x55C4},
+		{language: "en", want: 0x55C4},
+		{language: "pt-BR", want: 0x55C4},
+		{language: "", want: 0x55C4},
+	}
+
+	for _, test := range tests {
+		if got := packLanguage(test.language); got != test.want {
+			t.Errorf("packLanguage(%q) = %#x, want %#x", test.language, got, test.want)
+		}
+	}
+}
+
+func TestFragmentBoxes(t *testing.T) {
+	layout := testLayout()
+
+	// the B frame at 40 is stored after the P frame at 80 it depends on
+	samples := []sample{
+		{track: 0, pts: 0, duration: 40, keyframe: true, size: 5},
+		{track: 1, pts: 0, duration: 0, size: 3},
+		{track: 0, pts: 80, duration: 40, size: 2},
+		{track: 0, pts: 40, duration: 40, size: 1},
+		{track: 1, pts: 20, duration: 0, size: 4},
+	}
+
+	delay := reorderDelay(samples)
+	if delay != 40 {
+		t.Fatalf("reorderDelay = %v, want 40", delay)
+	}
+
+	moof, dataSize := fragmentBoxes(3, layout, samples, delay)
+	if dataSize != 15 {
+		t.Errorf("data size = %v, want 15", dataSize)
+	}
+
+	boxes := readBoxes(t, moof)
+	if len(boxes) != 1 || boxes[0].kind != "moof" {
+		t.Fatalf("fragment boxes = %v, want a single moof", boxKinds(boxes))
+	}
+
+	parts := readBoxes(t, boxes[0].content)
+	if kinds := boxKinds(parts); !reflect.DeepEqual(kinds, []string{"mfhd", "traf", "traf"}) {
+		t.Fatalf("moof boxes = %v, want mfhd and a traf per track", kinds)
+	}
+
+	if sequence := u32s(parts[0].content); !reflect.DeepEqual(sequence, []uint32{0, 3}) {
+		t.Errorf("mfhd = %v, want the sequence number 3", sequence)
+	}
+
+	// the data of the runs follows the header of the mdat, video first
+	videoData := uint32(len(moof) + 8)
+	tests := []struct {
+		name string
+		traf []byte
+		tfhd []uint32
+		tfdt []uint32
+		trun []uint32
+	}{
+		{
+			name: "video",
+			traf: parts[1].content,
+			tfhd: []uint32{fragmentBaseIsMoof, 1},
+			tfdt: []uint32{0x01000000, 0, 0},
+			trun: []uint32{
+				runDataOffset | runSampleDuration | runSampleSize | runSampleFlags | runCompositionOffset,
+				3, videoData,
+				// decoded at 0, 40 and 80, presented 40 later than
+				// their time in the file
+				40, 5, sampleKeyframe, 40,
+				40, 2, sampleNotKeyframe, 80,
+				40, 1, sampleNotKeyframe, 0,
+			},
+		},
+		{
+			name: "audio",
+			traf: parts[2].content,
+			tfhd: []uint32{fragmentBaseIsMoof, 2},
+			tfdt: []uint32{0x01000000, 0, 0},
+			trun: []uint32{
+				runDataOffset | runSampleDuration | runSampleSize | runSampleFlags,
+				2, videoData + 8,
+				// the last sample without duration lasts as long as the
+				// one before it
+				20, 3, sampleKeyframe,
+				20, 4, sampleKeyframe,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			boxes := readBoxes(t, test.traf)
+			if kinds := boxKinds(boxes); !reflect.DeepEqual(kinds, []string{"tfhd", "tfdt", "trun"}) {
+				t.Fatalf("traf boxes = %v, want tfhd, tfdt and trun", kinds)
+			}
+
+			if got := u32s(boxes[0].content); !reflect.DeepEqual(got, test.tfhd) {
+				t.Errorf("tfhd = %v, want %v", got, test.tfhd)
+			}
+
+			if got := u32s(boxes[1].content); !reflect.DeepEqual(got, test.tfdt) {
+				t.Errorf("tfdt = %v, want %v", got, test.tfdt)
+			}
+
+			if got := u32s(boxes[2].content); !reflect.DeepEqual(got, test.trun) {
+				t.Errorf("trun\n got %v\nwant %v", got, test.trun)
+			}
+		})
+	}
+}
+
+func TestSegmentIndex(t *testing.T) {
+	index := Index{
+		Delay:    40,
+		Duration: 300,
+		Fragments: []Fragment{
+			{Time: 0, Size: 100},
+			{Time: 120, Size: 200},
+		},
+	}
+
+	boxes := readBoxes(t, segmentIndex(index))
+	if len(boxes) != 1 || boxes[0].kind != "sidx" {
+		t.Fatalf("segmentIndex = %v, want a single sidx", boxKinds(boxes))
+	}
+
+	want := []uint32{
+		0x01000000,
+		1, timescale,
+		// the earliest presentation time is shifted by the delay
+		0, 40,
+		0, 0,
+		2,
+		100, 120, 0x90000000,
+		200, 180, 0x90000000,
+	}
+	if got := u32s(boxes[0].content); !reflect.DeepEqual(got, want) {
+		t.Errorf("sidx\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestInitSegment(t *testing.T) {
+	index := Index{VideoTrack: 1, AudioTrack: 2, Delay: 40, Duration: 300, Fragments: []Fragment{{Size: 100}}}
+	boxes := readBoxes(t, initSegment(testLayout(), index))
+
+	if kinds := boxKinds(boxes); !reflect.DeepEqual(kinds, []string{"ftyp", "moov", "sidx"}) {
+		t.Fatalf("init segment = %v, want ftyp, moov and sidx", kinds)
+	}
+
+	moov := readBoxes(t, boxes[1].content)
+	if kinds := boxKinds(moov); !reflect.DeepEqual(kinds, []string{"mvhd", "trak", "trak", "mvex"}) {
+		t.Fatalf("moov = %v, want mvhd, a trak per track and mvex", kinds)
+	}
+
+	tests := []struct {
+		name   string
+		trak   []byte
+		parts  []string
+		entry  string
+		config string
+		codec  []byte
+	}{
+		// the edit list of the video takes the delay back
+		{name: "video", trak: moov[1].content, parts: []string{"tkhd", "edts", "mdia"}, entry: "avc1", config: "avcC", codec: []byte("avc config")},
+		{name: "audio", trak: moov[2].content, parts: []string{"tkhd", "mdia"}, entry: "mp4a", config: "esds"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parts := readBoxes(t, test.trak)
+			if kinds := boxKinds(parts); !reflect.DeepEqual(kinds, test.parts) {
+				t.Fatalf("trak = %v, want %v", kinds, test.parts)
+			}
+
+			mdia := readBoxes(t, parts[len(parts)-1].content)
+			minf := readBoxes(t, mdia[2].content)
+			stbl := readBoxes(t, minf[2].content)
+			// past the version, flags and count of the stsd
+			entries := readBoxes(t, stbl[0].content[8:])
+			if len(entries) != 1 || entries[0].kind != test.entry {
+				t.Fatalf("sample entries = %v, want %v", boxKinds(entries), test.entry)
+			}
+
+			// video entries take 78 bytes before their configuration,
+			// audio ones 28
+			skip := 28
+			if test.entry == "avc1" {
+				skip = 78
+			}
+
+			config := readBoxes(t, entries[0].content[skip:])
+			if len(config) != 1 || config[0].kind != test.config {
+				t.Fatalf("configuration = %v, want %v", boxKinds(config), test.config)
+			}
+
+			if test.codec != nil && !bytes.Equal(config[0].content, test.codec) {
+				t.Errorf("%v = %q, want the codec private %q", test.config, config[0].content, test.codec)
+			}
+		})
+	}
+
+	// the trex of each track follows the mehd
+	mvex := readBoxes(t, moov[3].content)
+	if kinds := boxKinds(mvex); !reflect.DeepEqual(kinds, []string{"mehd", "trex", "trex"}) {
+		t.Errorf("mvex = %v, want mehd and a trex per track", kinds)
+	}
+}
@@ -0,0 +1,515 @@
+// Package remux rewrites the H.264 and AAC tracks of Matroska files as a
+// fragmented MP4, without decoding them, so browsers that don't play Matroska
+// can still play the files. Each fragment starts at a cue, and the stream can
+// be read from any byte so players seek with range requests.
+package remux
+
+import (
+	"context"
+	"errors"
+	"go-video-viewer/internals/probe"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnsupported   = errors.New("the tracks of the file can't be remuxed to MP4")
+	errIndexMismatch = errors.New("the file doesn't match its remux index")
+)
+
+// timescale is the number of ticks per second of the MP4 timestamps.
+const timescale = 1000000
+
+// aacSampleRates are the sample rates an AAC configuration refers to by index.
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// aacProfiles are the object types of the Matroska codec ids that don't have
+// a codec private, the SBR ones are signaled implicitly.
+var aacProfiles = map[string]byte{"MAIN": 1, "LC": 2, "SSR": 3, "LTP": 4}
+
+// Index is where the fragments of the stream come from and how big they are,
+// which takes a read over the whole file to know.
+type Index struct {
+	VideoTrack int `json:"video_track"`
+	// AudioTrack is zero for files without audio
+	AudioTrack int `json:"audio_track"`
+	// Delay is how much the presentation of the video is shifted so reordered
+	// frames don't start before they are decoded
+	Delay     int64      `json:"delay"`
+	Duration  int64      `json:"duration"`
+	Fragments []Fragment `json:"fragments"`
+}
+
+// Fragment is made of the clusters from Start up to End, which is zero for the
+// last fragment. Time is when it starts.
+type Fragment struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Time  int64 `json:"time"`
+	Size  int64 `json:"size"`
+}
+
+// track is a Matroska track as written to the MP4, the video being first.
+type track struct {
+	id    uint32
+	video bool
+	entry probe.TrackEntry
+	// config is the AAC configuration of audio tracks
+	config []byte
+}
+
+// sample is a frame of a track, the data is left out while indexing.
+type sample struct {
+	track    int
+	pts      int64
+	duration int64
+	keyframe bool
+	size     int
+	data     []byte
+}
+
+// Supported tells if the tracks of the media can be remuxed, which are the
+// first video track and the default audio track.
+func Supported(media probe.Info) bool {
+	video, audio, found := chooseTracks(media.Tracks)
+	return found && remuxable(video) && (audio == nil || remuxable(*audio))
+}
+
+// chooseTracks picks the first video track and the default audio track, or the
+// first one when none is the default.
+func chooseTracks(tracks []probe.Track) (probe.Track, *probe.Track, bool) {
+	var video, audio *probe.Track
+
+	for i, track := range tracks {
+		switch {
+		case track.Type == probe.TrackVideo && video == nil:
+			video = &tracks[i]
+		case track.Type == probe.TrackAudio && (audio == nil || (track.Default && !audio.Default)):
+			audio = &tracks[i]
+		}
+	}
+
+	if video == nil {
+		return probe.Track{}, nil, false
+	}
+
+	return *video, audio, true
+}
+
+func remuxable(track probe.Track) bool {
+	switch track.Type {
+	case probe.TrackVideo:
+		// few browsers play HEVC, those videos are transcoded instead
+		return track.Codec == "h264"
+	case probe.TrackAudio:
+		return track.Codec == "aac"
+	default:
+		return false
+	}
+}
+
+// layoutTracks finds the tracks of the index among the ones of the file.
+func layoutTracks(entries []probe.TrackEntry, video int, audio int) ([]track, error) {
+	var tracks []track
+
+	for _, number := range []int{video, audio} {
+		if number == 0 {
+			continue
+		}
+
+		found := slices.IndexFunc(entries, func(entry probe.TrackEntry) bool {
+			return entry.Number == number
+		})
+		if found < 0 || !remuxable(entries[found].Track) {
+			return nil, ErrUnsupported
+		}
+
+		entry := entries[found]
+		layout := track{id: uint32(len(tracks) + 1), video: entry.Type == probe.TrackVideo, entry: entry}
+
+		if layout.video && len(entry.CodecPrivate) == 0 {
+			return nil, ErrUnsupported
+		}
+
+		if !layout.video {
+			config, ok := aacConfig(entry)
+			if !ok {
+				return nil, ErrUnsupported
+			}
+			layout.config = config
+		}
+
+		tracks = append(tracks, layout)
+	}
+
+	return tracks, nil
+}
+
+// aacConfig returns the AAC configuration of the track. Older files name the
+// profile in the codec id instead.
+func aacConfig(entry probe.TrackEntry) ([]byte, bool) {
+	if len(entry.CodecPrivate) > 0 {
+		return entry.CodecPrivate, true
+	}
+
+	profile := byte(2)
+	for _, part := range strings.Split(entry.CodecId, "/") {
+		if value, found := aacProfiles[part]; found {
+			profile = value
+		}
+	}
+
+	rate := slices.Index(aacSampleRates, int(entry.SampleRate))
+	if rate < 0 || entry.Channels <= 0 || entry.Channels > 7 {
+		return nil, false
+	}
+
+	return []byte{profile<<3 | byte(rate)>>1, byte(rate)<<7 | byte(entry.Channels)<<3}, true
+}
+
+// Build indexes the file, reading every block of the remuxed tracks once to
+// know the size of each fragment. Fragments start at the clusters of the cues
+// of the video, or at the clusters starting with a keyframe when there are no
+// cues.
+func Build(ctx context.Context, r io.ReadSeeker, size int64) (Index, error) {
+	demuxer, err := probe.OpenMatroska(r, size)
+	if errors.Is(err, probe.ErrNotMatroska) {
+		return Index{}, ErrUnsupported
+	}
+
+	if err != nil {
+		return Index{}, err
+	}
+
+	entries := demuxer.Tracks()
+	var tracks []probe.Track
+	for _, entry := range entries {
+		tracks = append(tracks, entry.Track)
+	}
+
+	video, audio, found := chooseTracks(tracks)
+	if !found {
+		return Index{}, ErrUnsupported
+	}
+
+	index := Index{VideoTrack: video.Number}
+	if audio != nil {
+		index.AudioTrack = audio.Number
+	}
+
+	layout, err := layoutTracks(entries, index.VideoTrack, index.AudioTrack)
+	if err != nil {
+		return Index{}, err
+	}
+
+	cueClusters := map[int64]bool{}
+	for _, cue := range demuxer.Cues() {
+		if cue.Track == index.VideoTrack {
+			cueClusters[cue.Cluster] = true
+		}
+	}
+
+	demuxer.Select(index.VideoTrack, index.AudioTrack)
+
+	var samples []sample
+	start, cluster := int64(-1), int64(-1)
+	clusterFirst, videoSeen := 0, false
+
+	flush := func(end int64) {
+		if len(samples) == 0 {
+			return
+		}
+
+		moof, dataSize := fragmentBoxes(len(index.Fragments)+1, layout, samples, 0)
+		index.Fragments = append(index.Fragments, Fragment{
+			Start: start,
+			End:   end,
+			Time:  earliest(samples),
+			Size:  int64(len(moof)) + 8 + dataSize,
+		})
+
+		index.Delay = max(index.Delay, reorderDelay(samples))
+		for _, sample := range samples {
+			index.Duration = max(index.Duration, sample.pts+sample.duration)
+		}
+	}
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return Index{}, err
+		}
+
+		block, err := demuxer.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return Index{}, err
+		}
+
+		if block.Cluster != cluster {
+			cluster, clusterFirst, videoSeen = block.Cluster, len(samples), false
+		}
+
+		isVideo := block.Track == index.VideoTrack
+		cut := cueClusters[cluster]
+		if len(cueClusters) == 0 {
+			cut = isVideo && !videoSeen && block.Keyframe
+		}
+		videoSeen = videoSeen || isVideo
+
+		if cut && start >= 0 && cluster != start {
+			// the blocks of the cluster read so far belong to the new fragment
+			moved := slices.Clone(samples[clusterFirst:])
+			samples = samples[:clusterFirst]
+			flush(cluster)
+
+			samples, start, clusterFirst = moved, cluster, 0
+		}
+
+		if start < 0 {
+			start = cluster
+		}
+
+		samples = appendSamples(samples, layout, block, false)
+	}
+	flush(0)
+
+	if len(index.Fragments) == 0 {
+		return Index{}, ErrUnsupported
+	}
+
+	if duration := ticks(demuxer.Duration()); duration > index.Duration {
+		index.Duration = duration
+	}
+
+	return index, nil
+}
+
+// appendSamples splits the frames of a block into samples. Laced frames share
+// the time of the block, each one lasting its part of the block.
+func appendSamples(samples []sample, layout []track, block probe.Block, withData bool) []sample {
+	position := slices.IndexFunc(layout, func(track track) bool {
+		return track.entry.Number == block.Track
+	})
+	if position < 0 {
+		return samples
+	}
+
+	duration := layout[position].entry.DefaultDuration
+	if block.Duration > 0 {
+		duration = block.Duration / time.Duration(len(block.Frames))
+	}
+
+	for i, frame := range block.Frames {
+		next := sample{
+			track:    position,
+			pts:      ticks(block.Time + time.Duration(i)*duration),
+			duration: ticks(duration),
+			keyframe: block.Keyframe,
+			size:     len(frame),
+		}
+
+		if withData {
+			next.data = frame
+		}
+
+		samples = append(samples, next)
+	}
+
+	return samples
+}
+
+// earliest returns when the fragment starts, the earliest frame of its video
+// or of its audio when it has no video.
+func earliest(samples []sample) int64 {
+	var video []int64
+	for _, sample := range samples {
+		if sample.track == 0 {
+			video = append(video, sample.pts)
+		}
+	}
+
+	if len(video) > 0 {
+		return slices.Min(video)
+	}
+
+	first := samples[0].pts
+	for _, sample := range samples {
+		first = min(first, sample.pts)
+	}
+
+	return first
+}
+
+// reorderDelay returns how much earlier than presented the video frames of the
+// fragment are decoded.
+func reorderDelay(samples []sample) int64 {
+	var presentation []int64
+	for _, sample := range samples {
+		if sample.track == 0 {
+			presentation = append(presentation, sample.pts)
+		}
+	}
+
+	decode := slices.Sorted(slices.Values(presentation))
+
+	var delay int64
+	for i := range presentation {
+		delay = max(delay, decode[i]-presentation[i])
+	}
+
+	return delay
+}
+
+func ticks(duration time.Duration) int64 {
+	return int64(duration / (time.Second / timescale))
+}
+
+// Stream reads the remuxed file. Only the header of the stream is kept in
+// memory, the fragments are remuxed once read.
+type Stream struct {
+	demuxer *probe.Demuxer
+	tracks  []track
+	index   Index
+	header  []byte
+	// offsets are where the fragments start in the stream
+	offsets  []int64
+	size     int64
+	position int64
+	// fragment is the last fragment read, most reads go over it in order
+	current  int
+	fragment []byte
+}
+
+// NewStream prepares the remux of the file with its index.
+func NewStream(r io.ReadSeeker, size int64, index Index) (*Stream, error) {
+	demuxer, err := probe.OpenMatroska(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks, err := layoutTracks(demuxer.Tracks(), index.VideoTrack, index.AudioTrack)
+	if err != nil {
+		return nil, err
+	}
+	demuxer.Select(index.VideoTrack, index.AudioTrack)
+
+	stream := &Stream{
+		demuxer: demuxer,
+		tracks:  tracks,
+		index:   index,
+		header:  initSegment(tracks, index),
+		current: -1,
+	}
+
+	stream.size = int64(len(stream.header))
+	for _, fragment := range index.Fragments {
+		stream.offsets = append(stream.offsets, stream.size)
+		stream.size += fragment.Size
+	}
+
+	return stream, nil
+}
+
+func (stream *Stream) Size() int64 {
+	return stream.size
+}
+
+func (stream *Stream) Read(p []byte) (int, error) {
+	if stream.position >= stream.size {
+		return 0, io.EOF
+	}
+
+	if stream.position < int64(len(stream.header)) {
+		n := copy(p, stream.header[stream.position:])
+		stream.position += int64(n)
+		return n, nil
+	}
+
+	current := sort.Search(len(stream.offsets), func(i int) bool {
+		return stream.offsets[i] > stream.position
+	}) - 1
+
+	if current != stream.current {
+		fragment, err := stream.remuxFragment(current)
+		if err != nil {
+			return 0, err
+		}
+
+		stream.current, stream.fragment = current, fragment
+	}
+
+	n := copy(p, stream.fragment[stream.position-stream.offsets[current]:])
+	stream.position += int64(n)
+	return n, nil
+}
+
+func (stream *Stream) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += stream.position
+	case io.SeekEnd:
+		offset += stream.size
+	}
+
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the stream")
+	}
+
+	stream.position = offset
+	return offset, nil
+}
+
+// remuxFragment reads the blocks of the clusters of the fragment and writes
+// them as MP4.
+func (stream *Stream) remuxFragment(number int) ([]byte, error) {
+	fragment := stream.index.Fragments[number]
+	stream.demuxer.SeekCluster(fragment.Start)
+
+	var samples []sample
+	for {
+		block, err := stream.demuxer.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if fragment.End > 0 && block.Cluster >= fragment.End {
+			break
+		}
+
+		samples = appendSamples(samples, stream.tracks, block, true)
+	}
+
+	if len(samples) == 0 {
+		return nil, errIndexMismatch
+	}
+
+	moof, dataSize := fragmentBoxes(number+1, stream.tracks, samples, stream.index.Delay)
+	if int64(len(moof))+8+dataSize != fragment.Size {
+		return nil, errIndexMismatch
+	}
+
+	out := make([]byte, 0, fragment.Size)
+	out = append(out, moof...)
+	out = append(out, fields{}.u32(uint32(dataSize+8)).text("mdat")...)
+
+	// the data of each track is together, in the order of the runs
+	for i := range stream.tracks {
+		for _, sample := range samples {
+			if sample.track == i {
+				out = append(out, sample.data...)
+			}
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,350 @@
+package remux
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"go-video-viewer/internals/probe"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// Matroska element ids of the test files.
+const (
+	mkvEBML            = 0x1A45DFA3
+	mkvDocType         = 0x4282
+	mkvSegment         = 0x18538067
+	mkvInfo            = 0x1549A966
+	mkvTimestampScale  = 0x2AD7B1
+	mkvDuration        = 0x4489
+	mkvTracks          = 0x1654AE6B
+	mkvTrackEntry      = 0xAE
+	mkvTrackNumber     = 0xD7
+	mkvTrackType       = 0x83
+	mkvCodecId         = 0x86
+	mkvCodecPrivate    = 0x63A2
+	mkvDefaultDuration = 0x23E383
+	mkvVideo           = 0xE0
+	mkvPixelWidth      = 0xB0
+	mkvPixelHeight     = 0xBA
+	mkvAudio           = 0xE1
+	mkvSampling        = 0xB5
+	mkvChannels        = 0x9F
+	mkvCluster         = 0x1F43B675
+	mkvTimestamp       = 0xE7
+	mkvSimpleBlock     = 0xA3
+)
+
+// element writes an EBML element, its size taking the 8 bytes of the longest
+// variable size integer.
+func element(id uint32, children ...[]byte) []byte {
+	data := bytes.Join(children, nil)
+
+	var out []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(out) > 0 {
+			out = append(out, b)
+		}
+	}
+
+	out = binary.BigEndian.AppendUint64(out, uint64(len(data))|0x01<<56)
+	return append(out, data...)
+}
+
+// unknownElement writes an element without its size, like live recordings.
+func unknownElement(id uint32, children ...[]byte) []byte {
+	out := element(id, children...)
+	copy(out[4:12], []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	return out
+}
+
+func uintElement(id uint32, value uint64) []byte {
+	return element(id, binary.BigEndian.AppendUint64(nil, value))
+}
+
+func floatElement(id uint32, value float64) []byte {
+	return element(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(value)))
+}
+
+// simpleBlock writes a block at a time in milliseconds relative to its cluster.
+func simpleBlock(track byte, relative int16, keyframe bool, data string) []byte {
+	flags := byte(0)
+	if keyframe {
+		flags = 0x80
+	}
+
+	return element(mkvSimpleBlock, []byte{0x80 | track, byte(relative >> 8), byte(relative), flags}, []byte(data))
+}
+
+// testFile writes a Matroska file with a H.264 track and an AAC track, without
+// cues. The clusters start at the returned offsets.
+func testFile(videoCodec string, clusters ...[]byte) ([]byte, []int64) {
+	head := bytes.Join([][]byte{
+		element(mkvInfo, uintElement(mkvTimestampScale, 1000000), floatElement(mkvDuration, 200)),
+		element(mkvTracks,
+			element(mkvTrackEntry,
+				uintElement(mkvTrackNumber, 1),
+				uintElement(mkvTrackType, 1),
+				element(mkvCodecId, []byte(videoCodec)),
+				element(mkvCodecPrivate, []byte("avc config")),
+				uintElement(mkvDefaultDuration, 40000000),
+				element(mkvVideo, uintElement(mkvPixelWidth, 320), uintElement(mkvPixelHeight, 240)),
+			),
+			element(mkvTrackEntry,
+				uintElement(mkvTrackNumber, 2),
+				uintElement(mkvTrackType, 2),
+				element(mkvCodecId, []byte("A_AAC")),
+				element(mkvCodecPrivate, []byte{0x11, 0x90}),
+				element(mkvAudio, uintElement(mkvChannels, 2), floatElement(mkvSampling, 48000)),
+			),
+		),
+	}, nil)
+
+	header := element(mkvEBML, element(mkvDocType, []byte("matroska")))
+	segment := unknownElement(mkvSegment, append([][]byte{head}, clusters...)...)
+
+	// the segment id and its unknown size
+	offset := int64(len(header) + 12 + len(head))
+	var offsets []int64
+	for _, cluster := range clusters {
+		offsets = append(offsets, offset)
+		offset += int64(len(cluster))
+	}
+
+	return append(header, segment...), offsets
+}
+
+// testClusters are two fragments, the third cluster doesn't start with a
+// keyframe so it belongs to the second one.
+func testClusters(reordered string) [][]byte {
+	return [][]byte{
+		element(mkvCluster,
+			uintElement(mkvTimestamp, 0),
+			simpleBlock(1, 0, true, "key0"),
+			simpleBlock(2, 0, true, "aa0"),
+			simpleBlock(1, 80, false, "p80"),
+			simpleBlock(1, 40, false, reordered),
+			simpleBlock(2, 20, true, "aa20"),
+		),
+		element(mkvCluster,
+			uintElement(mkvTimestamp, 120),
+			simpleBlock(1, 0, true, "key120"),
+			simpleBlock(2, 0, true, "aa120"),
+		),
+		element(mkvCluster,
+			uintElement(mkvTimestamp, 160),
+			simpleBlock(1, 0, false, "p160"),
+		),
+	}
+}
+
+func TestSupported(t *testing.T) {
+	video := probe.Track{Number: 1, Type: probe.TrackVideo, Codec: "h264"}
+	aac := probe.Track{Number: 2, Type: probe.TrackAudio, Codec: "aac"}
+	subtitle := probe.Track{Number: 4, Type: probe.TrackSubtitle, Codec: "ass"}
+
+	tests := []struct {
+		name   string
+		tracks []probe.Track
+		want   bool
+	}{
+		{name: "h264 and aac", tracks: []probe.Track{video, aac, subtitle}, want: true},
+		{name: "no audio", tracks: []probe.Track{video}, want: true},
+		{name: "no video", tracks: []probe.Track{aac}},
+		{name: "hevc", tracks: []probe.Track{{Number: 1, Type: probe.TrackVideo, Codec: "hevc"}, aac}},
+		{name: "opus", tracks: []probe.Track{video, {Number: 2, Type: probe.TrackAudio, Codec: "opus"}}},
+		{
+			// the default audio track is the one remuxed
+			name:   "default opus",
+			tracks: []probe.Track{video, aac, {Number: 3, Type: probe.TrackAudio, Codec: "opus", Default: true}},
+		},
+		{
+			name:   "default aac",
+			tracks: []probe.Track{video, {Number: 3, Type: probe.TrackAudio, Codec: "opus"}, {Number: 2, Type: probe.TrackAudio, Codec: "aac", Default: true}},
+			want:   true,
+		},
+	}
+
+	for _, test := range tests {
+		if got := Supported(probe.Info{Tracks: test.tracks}); got != test.want {
+			t.Errorf("Supported(%v) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAacConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry probe.TrackEntry
+		want  []byte
+		ok    bool
+	}{
+		{
+			name:  "codec private",
+			entry: probe.TrackEntry{CodecPrivate: []byte{0x12, 0x10}},
+			want:  []byte{0x12, 0x10},
+			ok:    true,
+		},
+		{
+			name:  "low complexity",
+			entry: probe.TrackEntry{Track: probe.Track{CodecId: "A_AAC", SampleRate: 48000, Channels: 2}},
+			want:  []byte{0x11, 0x90},
+			ok:    true,
+		},
+		{
+			name:  "profile in the codec id",
+			entry: probe.TrackEntry{Track: probe.Track{CodecId: "A_AAC/MPEG4/MAIN", SampleRate: 44100, Channels: 1}},
+			want:  []byte{0x0A, 0x08},
+			ok:    true,
+		},
+		{
+			name:  "unknown sample rate",
+			entry: probe.TrackEntry{Track: probe.Track{CodecId: "A_AAC", SampleRate: 12345, Channels: 2}},
+		},
+		{
+			name:  "no channels",
+			entry: probe.TrackEntry{Track: probe.Track{CodecId: "A_AAC", SampleRate: 48000}},
+		},
+	}
+
+	for _, test := range tests {
+		got, ok := aacConfig(test.entry)
+		if !bytes.Equal(got, test.want) || ok != test.ok {
+			t.Errorf("aacConfig(%v) = %v, %v, want %v, %v", test.name, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	file, offsets := testFile("V_MPEG4/ISO/AVC", testClusters("b40")...)
+
+	index, err := Build(context.Background(), bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the sizes are checked against the stream by TestStream
+	var sizes []int64
+	for i := range index.Fragments {
+		sizes = append(sizes, index.Fragments[i].Size)
+		index.Fragments[i].Size = 0
+	}
+
+	want := Index{
+		VideoTrack: 1,
+		AudioTrack: 2,
+		Delay:      40000,
+		Duration:   200000,
+		Fragments: []Fragment{
+			{Start: offsets[0], End: offsets[1], Time: 0},
+			{Start: offsets[1], End: 0, Time: 120000},
+		},
+	}
+	if !reflect.DeepEqual(index, want) {
+		t.Errorf("Build\n got %+v\nwant %+v", index, want)
+	}
+
+	for i, size := range sizes {
+		if size <= 0 {
+			t.Errorf("fragment %v has a size of %v", i, size)
+		}
+	}
+}
+
+func TestBuildUnsupported(t *testing.T) {
+	hevc, _ := testFile("V_MPEGH/ISO/HEVC", testClusters("b40")...)
+	empty, _ := testFile("V_MPEG4/ISO/AVC")
+
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{name: "hevc", file: hevc},
+		{name: "no blocks", file: empty},
+		{name: "not matroska", file: box("ftyp", []byte("isom"))},
+	}
+
+	for _, test := range tests {
+		_, err := Build(context.Background(), bytes.NewReader(test.file), int64(len(test.file)))
+		if !errors.Is(err, ErrUnsupported) {
+			t.Errorf("Build(%v) = %v, want %v", test.name, err, ErrUnsupported)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	file, _ := testFile("V_MPEG4/ISO/AVC", testClusters("b40")...)
+
+	index, err := Build(context.Background(), bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := NewStream(bytes.NewReader(file), int64(len(file)), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if int64(len(data)) != stream.Size() {
+		t.Fatalf("read %v bytes, want the size of %v", len(data), stream.Size())
+	}
+
+	boxes := readBoxes(t, data)
+	want := []string{"ftyp", "moov", "sidx", "moof", "mdat", "moof", "mdat"}
+	if kinds := boxKinds(boxes); !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("stream boxes = %v, want %v", kinds, want)
+	}
+
+	// the data of each fragment has the video frames, then the audio ones
+	mdats := []string{"key0p80b40aa0aa20", "key120p160aa120"}
+	for i, mdat := range mdats {
+		moof, data := boxes[3+2*i], boxes[4+2*i]
+		if string(data.content) != mdat {
+			t.Errorf("mdat of fragment %v = %q, want %q", i, data.content, mdat)
+		}
+
+		if size := int64(len(moof.content) + len(data.content) + 16); size != index.Fragments[i].Size {
+			t.Errorf("fragment %v takes %v bytes, its index says %v", i, size, index.Fragments[i].Size)
+		}
+	}
+
+	// players seek to the fragments with range requests
+	second := stream.Size() - index.Fragments[1].Size
+	if _, err = stream.Seek(second, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	tail, err := io.ReadAll(stream)
+	if err != nil || !bytes.Equal(tail, data[second:]) {
+		t.Errorf("read after Seek = %v bytes, %v, want the last %v bytes", len(tail), err, len(data)-int(second))
+	}
+}
+
+func TestStreamIndexMismatch(t *testing.T) {
+	file, _ := testFile("V_MPEG4/ISO/AVC", testClusters("b40")...)
+
+	index, err := Build(context.Background(), bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file was replaced by one whose frames have other sizes
+	changed, _ := testFile("V_MPEG4/ISO/AVC", testClusters("b")...)
+
+	stream, err := NewStream(bytes.NewReader(changed), int64(len(changed)), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = io.ReadAll(stream); !errors.Is(err, errIndexMismatch) {
+		t.Errorf("ReadAll of a changed file = %v, want %v", err, errIndexMismatch)
+	}
+}
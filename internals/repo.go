@@ -1,18 +1,56 @@
 package internals
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 )
 
+// videoColumns is the column list read by readVideoFromRow, the tags are
+// aggregated into a json array so their names can contain any character.
+const videoColumns = `
+	videos.id,
+	videos.library_id,
+	videos.filename,
+	videos.nickname,
+	(
+		select
+			json_group_array(tags.name order by video_tags.rowid)
+		from
+			video_tags
+			join tags on tags.id = video_tags.tag_id
+		where
+			video_tags.video_id = videos.id
+	) as tags,
+	videos.created_at,
+	videos.status,
+	videos.missing_since is not null as missing,
+	videos.release_group,
+	videos.series_title,
+	videos.season,
+	videos.episode,
+	videos.episode_end,
+	videos.special,
+	videos.release_version,
+	videos.resolution,
+	videos.source,
+	videos.crc32,
+	videos.series_id,
+	coalesce(videos.checksum_status, 'unchecked') as checksum_status
+`
+
 type VideoRepository struct {
-	folderPath string
-	db         *sql.DB
+	libraries   []Library
+	skipFolders []string
+	db          *sql.DB
 }
 
 func NewRepository(config Config) (VideoRepository, error) {
@@ -23,7 +61,24 @@ func NewRepository(config Config) (VideoRepository, error) {
 		return VideoRepository{}, err
 	}
 	repo.db = db
-	repo.folderPath = config.VideoFolder
+
+	if err = repo.syncLibraries(config.Libraries); err != nil {
+		db.Close()
+		return VideoRepository{}, err
+	}
+
+	// videos imported before the parser existed, or parsed by an older version
+	if err = repo.parsePendingReleases(); err != nil {
+		db.Close()
+		return VideoRepository{}, err
+	}
+
+	// the holding and archive folders may live inside the video folder
+	for _, folder := range []string{config.HoldingFolder, config.ArchiveFolder} {
+		if folder != "" {
+			repo.skipFolders = append(repo.skipFolders, absolutePath(folder))
+		}
+	}
 
 	return repo, nil
 }
@@ -56,46 +111,72 @@ func (repo VideoRepository) LastFolderUpdate() (*time.Time, error) {
 	return &actualTime, nil
 }
 
-func (repo VideoRepository) ListAllSaved() ([]Video, error) {
-	return repo.queryVideos(
-		`
-		select
-			id,
-			filename,
-			nickname,
-			tags,
-			created_at,
-			status
-		from
-			videos
-		where
-			status = ?
-	    order by
-	      created_at
-		`,
-		VideoSaved,
+func (repo VideoRepository) ListSaved(query VideoListQuery) ([]Video, int, error) {
+	return repo.ListByStatus([]VideoStatus{VideoSaved}, query)
+}
+
+// ListByStatus lists a page of the videos that have any of the given statuses,
+// along with the total amount of matching videos. An empty status list matches
+// every video.
+func (repo VideoRepository) ListByStatus(statuses []VideoStatus, query VideoListQuery) ([]Video, int, error) {
+	where, args := statusFilterClause(statuses)
+	if query.LibraryId != 0 {
+		where += " and library_id = ?"
+		args = append(args, query.LibraryId)
+	}
+
+	var total int
+	err := repo.db.QueryRow("select count(id) from videos where "+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	videos, err := repo.queryVideos(
+		fmt.Sprintf(
+			`
+			select
+				%v
+			from
+				videos
+			where
+				%v
+			order by
+				%v
+			limit ? offset ?
+			`,
+			videoColumns,
+			where,
+			query.orderClause(),
+		),
+		append(args, query.PageSize, query.Offset())...,
 	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return videos, total, nil
 }
 
-func (repo VideoRepository) NextInQueue(quantity int) ([]Video, error) {
+// NextInQueue returns the first unwatched videos of a library, or of every
+// library when the id is zero.
+func (repo VideoRepository) NextInQueue(libraryId int32, quantity int, order QueueOrder) ([]Video, error) {
 	return repo.queryVideos(
 		`
 		select
-			id,
-			filename,
-			nickname,
-			tags,
-			created_at,
-			status
+			`+videoColumns+`
 		from
 			videos
 		where
 			status = ?
+			and missing_since is null
+			and (? = 0 or library_id = ?)
 		order by
-			created_at
+			`+order.clause()+`
 		limit ?
 		`,
 		VideoUnwatched,
+		libraryId,
+		libraryId,
 		quantity,
 	)
 }
@@ -104,12 +185,7 @@ func (repo VideoRepository) FindById(id int32) (*Video, error) {
 	rows, err := repo.db.Query(
 		`
 		select
-			id,
-			filename,
-			nickname,
-			tags,
-			created_at,
-			status
+			`+videoColumns+`
 		from
 			videos
 		where
@@ -138,17 +214,13 @@ func (repo VideoRepository) NextSavedById(id int32) (*Video, error) {
 	rows, err := repo.db.Query(
 		`
 		select
-			id,
-			filename,
-			nickname,
-			tags,
-			created_at,
-			status
+			`+videoColumns+`
 		from
 			videos
 		where
 			status = ?
 	        and created_at >= (select created_at from videos where id = ?)
+	        and library_id = (select library_id from videos where id = ?)
 	        and id <> ?
 	    order by
 	        created_at
@@ -158,6 +230,7 @@ func (repo VideoRepository) NextSavedById(id int32) (*Video, error) {
 		VideoSaved,
 		id,
 		id,
+		id,
 	)
 	if err != nil {
 		return nil, err
@@ -178,41 +251,59 @@ func (repo VideoRepository) NextSavedById(id int32) (*Video, error) {
 }
 
 func (repo VideoRepository) Update(video Video) error {
-	var nickname any
-	if video.Nickname.Valid && len(video.Nickname.String) > 0 {
-		nickname = video.Nickname.String
-	} else {
-		nickname = nil
+	return repo.update(video, 0)
+}
+
+// update saves the video, undoes is the id of the status event it reverts,
+// zero for a regular change.
+func (repo VideoRepository) update(video Video, undoes int64) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
 	}
 
-	var tags any
-	if len(video.Tags) > 0 {
-		tags = strings.Join(video.Tags, ",")
-	} else {
-		tags = nil
+	if err = recordVideoChanges(tx, video, undoes); err != nil {
+		tx.Rollback()
+		return err
 	}
 
-	_, err := repo.db.Exec(
+	_, err = tx.Exec(
 		`
 		update videos set
 			status = ?,
-			nickname = ?,
-			tags = ?
+			nickname = ?
 		where
 			id = ?
 		`,
 		video.Status,
-		nickname,
-		tags,
+		nullableNickname(video.Nickname),
 		video.Id,
 	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = replaceVideoTags(tx, video.Id, video.Tags); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = reindexVideos(tx, "id = ?", video.Id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
 
 	log.Println("Updated video", video.Id)
 
-	return err
+	return nil
 }
 
-func (repo VideoRepository) ImportJsonFile(path string) error {
+func (repo VideoRepository) ImportJsonFile(libraryId int32, path string) error {
 	jsonFile, err := readVideoJsonFile(path)
 	if err != nil {
 		return err
@@ -225,10 +316,10 @@ func (repo VideoRepository) ImportJsonFile(path string) error {
 
 	stmt, err := tx.Prepare(`
 		insert into videos
-			(filename, created_at, status)
+			(library_id, filename, created_at, status)
 		values
-			(?, ?, ?)
-		on conflict (filename) do nothing
+			(?, ?, ?, ?)
+		on conflict (library_id, filename) do nothing
 	`)
 	if err != nil {
 		tx.Rollback()
@@ -237,46 +328,61 @@ func (repo VideoRepository) ImportJsonFile(path string) error {
 	defer stmt.Close()
 
 	for _, entry := range jsonFile.Watched {
-		_, err = stmt.Exec(entry.Name, entry.Date, StatusFromWatchedEntry(entry))
+		_, err = stmt.Exec(libraryId, entry.Name, entry.Date, StatusFromWatchedEntry(entry))
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
 	}
 
-	_, err = stmt.Exec(jsonFile.Current.Name, jsonFile.Current.Date, VideoUnwatched)
+	_, err = stmt.Exec(libraryId, jsonFile.Current.Name, jsonFile.Current.Date, VideoUnwatched)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
 	for _, video := range jsonFile.ToWatch {
-		_, err = stmt.Exec(video.Name, video.Date, VideoUnwatched)
+		_, err = stmt.Exec(libraryId, video.Name, video.Date, VideoUnwatched)
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
 	}
 
+	if err = indexNewVideos(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = parseNewReleases(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	return tx.Commit()
 }
 
-func (repo VideoRepository) ImportFsEntries(entries []VideoFsEntry) error {
+// ImportFsEntries inserts the new files of a library, and reports each one as
+// added, truncated when it was already empty on disk, or skipped when it was
+// already known.
+func (repo VideoRepository) ImportFsEntries(libraryId int32, entries []VideoFsEntry) (ScanResult, error) {
+	var result ScanResult
+
 	tx, err := repo.db.Begin()
 	if err != nil {
-		return err
+		return result, err
 	}
 
 	stmt, err := tx.Prepare(`
 		insert into videos
-			(filename, created_at, status)
+			(library_id, filename, created_at, status, file_size, fingerprint)
 		values
-			(?, ?, ?)
-		on conflict (filename) do nothing
+			(?, ?, ?, ?, ?, ?)
+		on conflict (library_id, filename) do nothing
 	`)
 	if err != nil {
 		tx.Rollback()
-		return err
+		return result, err
 	}
 	defer stmt.Close()
 
@@ -287,53 +393,138 @@ func (repo VideoRepository) ImportFsEntries(entries []VideoFsEntry) error {
 		}
 
 		res, err := stmt.Exec(
+			libraryId,
 			entry.Filename,
 			entry.LastModifiedTime,
 			videoStatus,
+			entry.Size,
+			sql.NullString(entry.Fingerprint),
 		)
+		if err != nil {
+			tx.Rollback()
+			return ScanResult{}, err
+		}
 
-		if rows, err := res.RowsAffected(); err == nil && rows > 0 {
-			fmt.Println("Found", entry.Filename)
+		scanEntry := ScanEntry{LibraryId: libraryId, Filename: entry.Filename}
+		if rows, err := res.RowsAffected(); err != nil || rows == 0 {
+			result.Skipped = append(result.Skipped, scanEntry)
+			continue
 		}
 
-		if err != nil {
-			tx.Rollback()
-			return err
+		if id, err := res.LastInsertId(); err == nil {
+			scanEntry.VideoId = int32(id)
+		}
+
+		if entry.IsTruncated {
+			result.Truncated = append(result.Truncated, scanEntry)
+		} else {
+			result.Added = append(result.Added, scanEntry)
 		}
 	}
 
+	if err = indexNewVideos(tx); err != nil {
+		tx.Rollback()
+		return ScanResult{}, err
+	}
+
+	if err = parseNewReleases(tx); err != nil {
+		tx.Rollback()
+		return ScanResult{}, err
+	}
+
 	tx.Exec("update video_update set last_update = datetime('now') where id = 1;")
 
-	return tx.Commit()
+	if err = tx.Commit(); err != nil {
+		return ScanResult{}, err
+	}
+
+	return result, nil
+}
+
+// scansFolder tells if a subfolder of the library is part of its scan, given
+// its path and its slash separated path relative to the library folder.
+func (repo VideoRepository) scansFolder(library Library, path string, relative string) bool {
+	depth := strings.Count(relative, "/") + 1
+	tooDeep := library.MaxDepth > 0 && depth > library.MaxDepth
+
+	return library.RecursiveScan && !tooDeep && !slices.Contains(repo.skipFolders, absolutePath(path))
 }
 
-func (repo VideoRepository) ListDirVideos() ([]VideoFsEntry, error) {
-	entries, err := os.ReadDir(repo.folderPath)
+// absolutePath resolves the path against the working directory, so folders
+// configured relative to it match the paths found under the library folders.
+func absolutePath(path string) string {
+	absolute, err := filepath.Abs(path)
 	if err != nil {
-		return nil, err
+		return filepath.Clean(path)
 	}
 
-	files := make([]VideoFsEntry, len(entries))
-	file_index := 0
-	for _, entry := range entries {
-		if entry.IsDir() || !hasVideoExtension(entry.Name()) {
-			continue
+	return absolute
+}
+
+// ListDirVideos lists the videos of the library folder. When the scan is
+// recursive the subfolders are included up to the max depth, and the filenames
+// are relative to the video folder, using forward slashes. Files that can't be
+// read, or are symlinks out of the folder, are listed with their error.
+func (repo VideoRepository) ListDirVideos(library Library) ([]VideoFsEntry, error) {
+	var files []VideoFsEntry
+
+	err := filepath.WalkDir(library.VideoFolder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
 		}
 
-		info, err := entry.Info()
+		if path == library.VideoFolder {
+			return nil
+		}
+
+		relative, err := filepath.Rel(library.VideoFolder, path)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		relative = filepath.ToSlash(relative)
+
+		if entry.IsDir() {
+			if !repo.scansFolder(library, path, relative) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !hasVideoExtension(entry.Name()) {
+			return nil
+		}
+
+		var info fs.FileInfo
+		if entry.Type()&fs.ModeSymlink != 0 {
+			if _, err = resolveInFolder(library.VideoFolder, relative); err == nil {
+				info, err = os.Stat(path)
+			}
+		} else {
+			info, err = entry.Info()
 		}
 
-		files[file_index] = VideoFsEntry{
-			Filename:         entry.Name(),
+		if err != nil {
+			files = append(files, VideoFsEntry{Filename: relative, Err: err})
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		files = append(files, VideoFsEntry{
+			Filename:         relative,
 			LastModifiedTime: info.ModTime(),
+			Size:             info.Size(),
 			IsTruncated:      info.Size() <= 0,
-		}
-		file_index += 1
-	}
+		})
 
-	files = files[:file_index]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	return files, nil
 }
@@ -387,6 +578,24 @@ func (repo VideoRepository) QueryStats() (VideoStats, error) {
 	return stats, nil
 }
 
+// VideosWithFiles lists the videos whose file is still there and wasn't
+// truncated.
+func (repo VideoRepository) VideosWithFiles() ([]Video, error) {
+	return repo.queryVideos(
+		`
+		select
+			` + videoColumns + `
+		from
+			videos
+		where
+			file_size > 0
+			and missing_since is null
+		order by
+			id
+		`,
+	)
+}
+
 func (repo VideoRepository) queryVideos(sql string, args ...any) ([]Video, error) {
 	rows, err := repo.db.Query(sql, args...)
 	if err != nil {
@@ -394,7 +603,7 @@ func (repo VideoRepository) queryVideos(sql string, args ...any) ([]Video, error
 	}
 	defer rows.Close()
 
-	var videos []Video
+	videos := []Video{}
 	for rows.Next() {
 		video, err := readVideoFromRow(rows)
 		if err != nil {
@@ -406,8 +615,47 @@ func (repo VideoRepository) queryVideos(sql string, args ...any) ([]Video, error
 	return videos, nil
 }
 
+func statusFilterClause(statuses []VideoStatus) (string, []any) {
+	if len(statuses) == 0 {
+		return "1 = 1", nil
+	}
+
+	args := make([]any, len(statuses))
+	for i, status := range statuses {
+		args[i] = status
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(statuses)), ", ")
+	return fmt.Sprintf("status in (%v)", placeholders), args
+}
+
+// orderClause builds the "order by" expression for the query. The sort field
+// is validated by ParseVideoListQuery, so it never reaches the SQL verbatim.
+func (query VideoListQuery) orderClause() string {
+	var column string
+	switch query.Sort {
+	case SortByFilename:
+		column = "filename"
+	case SortByNickname:
+		column = "coalesce(nickname, filename)"
+	case SortById:
+		column = "id"
+	default:
+		column = "created_at"
+	}
+
+	direction := "asc"
+	if query.Order == OrderDesc {
+		direction = "desc"
+	}
+
+	return fmt.Sprintf("%v %v, id %v", column, direction, direction)
+}
+
 func openDatabase(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
+	// sqlite ignores the "on delete cascade" of the tables unless every
+	// connection turns the foreign keys on
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on")
 	if err != nil {
 		return nil, err
 	}
@@ -461,6 +709,204 @@ func executeMigrations(db *sql.DB) error {
 		insert into video_update (id, last_update) values (1, null)
 		on conflict (id) do nothing;
 		`,
+		`
+		create table if not exists tags (
+			id integer primary key,
+			name text not null unique
+		);
+
+		create table if not exists video_tags (
+			video_id integer not null references videos (id) on delete cascade,
+			tag_id integer not null references tags (id) on delete cascade,
+			primary key (video_id, tag_id)
+		);
+
+		create index if not exists video_tags_tag_id on video_tags (tag_id);
+
+		create temp table tag_split as
+		with recursive split (video_id, tag, rest) as (
+			select id, '', tags || ',' from videos where tags is not null and tags <> ''
+			union all
+			select
+				video_id,
+				trim(substr(rest, 1, instr(rest, ',') - 1)),
+				substr(rest, instr(rest, ',') + 1)
+			from
+				split
+			where
+				rest <> ''
+		)
+		select distinct video_id, tag from split where tag <> '';
+
+		insert into tags (name)
+		select distinct tag from tag_split;
+
+		insert into video_tags (video_id, tag_id)
+		select tag_split.video_id, tags.id from tag_split join tags on tags.name = tag_split.tag;
+
+		drop table tag_split;
+
+		alter table videos
+		drop column tags;
+		`,
+		`
+		create virtual table if not exists videos_search using fts5 (
+			filename,
+			nickname,
+			tags,
+			tokenize = 'unicode61 remove_diacritics 2',
+			prefix = '2 3'
+		);
+
+		insert into videos_search (rowid, filename, nickname, tags)
+		select
+			` + searchIndexColumns + `
+		from
+			videos;
+		`,
+		`
+		create table if not exists video_progress (
+			video_id integer primary key references videos (id) on delete cascade,
+			position real not null,
+			duration real not null,
+			updated_at datetime not null
+		);
+		`,
+		`
+		create table if not exists video_events (
+			id integer primary key,
+			video_id integer not null references videos (id) on delete cascade,
+			kind text not null,
+			old_value text,
+			new_value text,
+			undoes integer references video_events (id),
+			created_at datetime not null
+		);
+
+		create index if not exists video_events_video_id on video_events (video_id, created_at);
+
+		create index if not exists video_events_created_at on video_events (created_at);
+		`,
+		`
+		create table if not exists pending_disposals (
+			video_id integer primary key references videos (id) on delete cascade,
+			original_path text not null,
+			held_path text,
+			dispose_at datetime not null
+		);
+		`,
+		`
+		alter table pending_disposals
+		add column mode text not null default 'truncate';
+		`,
+		`
+		create table if not exists libraries (
+			id integer primary key,
+			name text not null unique
+		);
+
+		insert into libraries (id, name) values (1, '` + DefaultLibraryName + `')
+		on conflict do nothing;
+
+		create table videos_with_library (
+			id integer primary key,
+			library_id integer not null default 1 references libraries (id),
+			filename text not null,
+			created_at datetime not null,
+			status integer,
+			nickname text,
+			unique (library_id, filename)
+		);
+
+		insert into videos_with_library (id, library_id, filename, created_at, status, nickname)
+		select id, 1, filename, created_at, status, nickname from videos;
+
+		drop table videos;
+
+		alter table videos_with_library
+		rename to videos;
+		`,
+		`
+		alter table videos
+		add column file_size integer;
+
+		alter table videos
+		add column fingerprint text;
+
+		alter table videos
+		add column missing_since datetime;
+
+		create index if not exists videos_fingerprint on videos (file_size, fingerprint);
+		`,
+		`
+		create table if not exists scan_runs (
+			id integer primary key,
+			library_id integer references libraries (id),
+			started_at datetime not null,
+			finished_at datetime not null,
+			error text,
+			summary text not null,
+			result text not null
+		);
+		`,
+		`
+		alter table videos add column release_group text;
+		alter table videos add column series_title text;
+		alter table videos add column season integer;
+		alter table videos add column episode integer;
+		alter table videos add column episode_end integer;
+		alter table videos add column release_version integer;
+		alter table videos add column resolution text;
+		alter table videos add column source text;
+		alter table videos add column crc32 text;
+		alter table videos add column special boolean not null default false;
+		alter table videos add column release_parser integer;
+		`,
+		`
+		create table if not exists series (
+			id integer primary key,
+			library_id integer not null references libraries (id),
+			title text not null,
+			season integer
+		);
+
+		create unique index if not exists series_key on series (library_id, title collate nocase, ifnull(season, 0));
+
+		alter table videos add column series_id integer references series (id);
+		alter table videos add column series_manual boolean not null default false;
+
+		create index if not exists videos_series on videos (series_id);
+
+		-- parsing again puts the videos in their series
+		update videos set release_parser = null;
+		`,
+		`
+		alter table videos add column checksum_status text;
+		alter table videos add column checksum_checked_at datetime;
+		`,
+		`
+		create table if not exists video_media (
+			video_id integer primary key references videos (id) on delete cascade,
+			file_size integer not null,
+			modified_at datetime not null,
+			probed_at datetime not null,
+			info text,
+			error text
+		);
+		`,
+		`
+		create table if not exists skip_markers (
+			id integer primary key,
+			video_id integer references videos (id) on delete cascade,
+			series_id integer references series (id) on delete cascade,
+			kind text not null,
+			start_time real not null,
+			end_time real not null
+		);
+
+		create unique index if not exists skip_markers_video on skip_markers (video_id, kind) where video_id is not null;
+		create unique index if not exists skip_markers_series on skip_markers (series_id, kind) where series_id is not null;
+		`,
 	}
 
 	rows, err := db.Query("select version from migrations where id = 1")
@@ -480,7 +926,22 @@ func executeMigrations(db *sql.DB) error {
 	}
 	rows.Close()
 
-	trans, err := db.Begin()
+	// rebuilding a table drops the old one, which would cascade to the rows
+	// referencing it, so the migrations run on a connection without foreign
+	// keys. The pragma can't change inside a transaction.
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "pragma foreign_keys = off"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "pragma foreign_keys = on")
+
+	trans, err := conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -502,25 +963,48 @@ func executeMigrations(db *sql.DB) error {
 	return trans.Commit()
 }
 
+// nullableNickname stores empty nicknames as null.
+func nullableNickname(nickname NullString) any {
+	if nickname.Valid && len(nickname.String) > 0 {
+		return nickname.String
+	}
+
+	return nil
+}
+
 func readVideoFromRow(rows *sql.Rows) (Video, error) {
 	var video Video
-	var tags sql.NullString
+	var tags string
+	var info releaseColumns
 	err := rows.Scan(
 		&video.Id,
+		&video.LibraryId,
 		&video.Filename,
 		&video.Nickname,
 		&tags,
 		&video.CreatedAt,
 		&video.Status,
+		&video.Missing,
+		&info.Group,
+		&info.Title,
+		&info.Season,
+		&info.Episode,
+		&info.EpisodeEnd,
+		&info.Special,
+		&info.Version,
+		&info.Resolution,
+		&info.Source,
+		&info.CRC32,
+		&video.SeriesId,
+		&video.ChecksumStatus,
 	)
 	if err != nil {
 		return Video{}, err
 	}
+	video.Release = info.toInfo()
 
-	if tags.Valid && len(tags.String) > 0 {
-		video.Tags = strings.Split(tags.String, ",")
-	} else {
-		video.Tags = []string{}
+	if err = json.Unmarshal([]byte(tags), &video.Tags); err != nil {
+		return Video{}, err
 	}
 
 	return video, nil
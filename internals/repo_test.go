@@ -0,0 +1,70 @@
+//go:build sqlite_fts5
+
+package internals
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TestMigrationsKeepReferencingRows migrates a database from before the tags
+// table, the libraries migration rebuilds the videos table under the tags.
+func TestMigrationsKeepReferencingRows(t *testing.T) {
+	database := filepath.Join(t.TempDir(), "videos.db")
+
+	db, err := sql.Open("sqlite3", database)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`
+		create table videos (
+			id integer primary key,
+			filename text not null unique,
+			created_at datetime not null,
+			status integer,
+			nickname text,
+			tags text
+		);
+
+		create table migrations (
+			id integer primary key,
+			version integer not null
+		);
+
+		insert into migrations (id, version) values (1, 1);
+
+		insert into videos (id, filename, created_at, status, tags)
+		values (1, 'video.mkv', '2024-01-01 00:00:00', 0, 'first, second');
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := NewRepository(Config{
+		Database:  database,
+		Libraries: []Library{{Name: DefaultLibraryName, VideoFolder: t.TempDir()}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	video, err := repo.FindById(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if video == nil || !reflect.DeepEqual(video.Tags, []string{"first", "second"}) {
+		t.Fatalf("FindById after the migrations = %+v, want the video with its two tags", video)
+	}
+
+	if err = repo.SaveProgress(2, 10, 100); err == nil {
+		t.Errorf("SaveProgress of a video that doesn't exist succeeded, want a foreign key error")
+	}
+}
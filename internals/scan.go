@@ -0,0 +1,735 @@
+package internals
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// fingerprintChunk is how much of each end of a file goes in its fingerprint.
+const fingerprintChunk = 4 << 20
+
+// scanRunsKept is how many of the newest scans are stored, the periodic scans
+// would otherwise grow the table forever.
+const scanRunsKept = 200
+
+// scanSkippedKept is how many skipped files a stored scan lists, every known
+// file is skipped so the summary keeps their count instead.
+const scanSkippedKept = 100
+
+// ScanEntry is a file reported by a scan.
+type ScanEntry struct {
+	LibraryId        int32  `json:"library_id"`
+	VideoId          int32  `json:"video_id,omitempty"`
+	Filename         string `json:"filename"`
+	PreviousFilename string `json:"previous_filename,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// ScanResult lists what a scan found. Added files are new, truncated ones were
+// new but already empty on disk, and skipped ones were already known. Failed
+// files couldn't be read and are tried again on the next scan.
+type ScanResult struct {
+	Added     []ScanEntry `json:"added"`
+	Skipped   []ScanEntry `json:"skipped"`
+	Truncated []ScanEntry `json:"truncated"`
+	Failed    []ScanEntry `json:"failed"`
+	Missing   []ScanEntry `json:"missing"`
+	Renamed   []ScanEntry `json:"renamed"`
+	Restored  []ScanEntry `json:"restored"`
+}
+
+type ScanSummary struct {
+	Added     int `json:"added"`
+	Skipped   int `json:"skipped"`
+	Truncated int `json:"truncated"`
+	Failed    int `json:"failed"`
+	Missing   int `json:"missing"`
+	Renamed   int `json:"renamed"`
+	Restored  int `json:"restored"`
+}
+
+// ScanRun is a stored scan, the result is only loaded when a single run is
+// requested.
+type ScanRun struct {
+	Id         int64       `json:"id"`
+	LibraryId  *int32      `json:"library_id"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at"`
+	Error      NullString  `json:"error"`
+	Summary    ScanSummary `json:"summary"`
+	Result     *ScanResult `json:"result,omitempty"`
+}
+
+type ScanResponse struct {
+	LastUpdate *time.Time `json:"last_update"`
+	Run        ScanRun    `json:"run"`
+}
+
+type ScanListResponse struct {
+	Runs     []ScanRun `json:"runs"`
+	Total    int       `json:"total"`
+	Page     int       `json:"page"`
+	PageSize int       `json:"page_size"`
+}
+
+// StoredFile is what the repository knows about the file of a video.
+type StoredFile struct {
+	Id          int32
+	Filename    string
+	Status      VideoStatus
+	Size        sql.NullInt64
+	Fingerprint NullString
+	Missing     bool
+}
+
+type FileRename struct {
+	Id       int32
+	Filename string
+}
+
+type FileState struct {
+	Id          int32
+	Size        int64
+	Fingerprint NullString
+}
+
+// ScanChanges are the updates to the known videos found by a scan, the new
+// files are imported with ImportFsEntries.
+type ScanChanges struct {
+	Renamed []FileRename
+	Missing []int32
+	Files   []FileState
+}
+
+// newScanResult starts every list empty, so they're encoded as arrays.
+func newScanResult() ScanResult {
+	return ScanResult{
+		Added:     []ScanEntry{},
+		Skipped:   []ScanEntry{},
+		Truncated: []ScanEntry{},
+		Failed:    []ScanEntry{},
+		Missing:   []ScanEntry{},
+		Renamed:   []ScanEntry{},
+		Restored:  []ScanEntry{},
+	}
+}
+
+func (result *ScanResult) add(other ScanResult) {
+	result.Added = append(result.Added, other.Added...)
+	result.Skipped = append(result.Skipped, other.Skipped...)
+	result.Truncated = append(result.Truncated, other.Truncated...)
+	result.Failed = append(result.Failed, other.Failed...)
+	result.Missing = append(result.Missing, other.Missing...)
+	result.Renamed = append(result.Renamed, other.Renamed...)
+	result.Restored = append(result.Restored, other.Restored...)
+}
+
+func (result ScanResult) summary() ScanSummary {
+	return ScanSummary{
+		Added:     len(result.Added),
+		Skipped:   len(result.Skipped),
+		Truncated: len(result.Truncated),
+		Failed:    len(result.Failed),
+		Missing:   len(result.Missing),
+		Renamed:   len(result.Renamed),
+		Restored:  len(result.Restored),
+	}
+}
+
+// SaveScanRun stores the scan and forgets the ones older than the last
+// scanRunsKept.
+func (repo VideoRepository) SaveScanRun(run *ScanRun) error {
+	summary, err := json.Marshal(run.Summary)
+	if err != nil {
+		return err
+	}
+
+	stored := run.Result
+	if stored != nil && len(stored.Skipped) > scanSkippedKept {
+		capped := *stored
+		capped.Skipped = capped.Skipped[:scanSkippedKept]
+		stored = &capped
+	}
+
+	result, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	res, err := repo.db.Exec(
+		`
+		insert into scan_runs
+			(library_id, started_at, finished_at, error, summary, result)
+		values
+			(?, ?, ?, ?, ?, ?)
+		`,
+		run.LibraryId,
+		run.StartedAt.UTC(),
+		run.FinishedAt.UTC(),
+		sql.NullString(run.Error),
+		string(summary),
+		string(result),
+	)
+	if err != nil {
+		return err
+	}
+
+	run.Id, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.db.Exec(
+		`
+		delete from scan_runs
+		where id not in (
+			select
+				id
+			from
+				scan_runs
+			order by
+				started_at desc,
+				id desc
+			limit ?
+		)
+		`,
+		scanRunsKept,
+	)
+	return err
+}
+
+// ListScanRuns lists the scans from the newest to the oldest, without their
+// results.
+func (repo VideoRepository) ListScanRuns(page int, pageSize int) ([]ScanRun, int, error) {
+	var total int
+	if err := repo.db.QueryRow("select count(id) from scan_runs").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	runs, err := repo.queryScanRuns(
+		`
+		select
+			id,
+			library_id,
+			started_at,
+			finished_at,
+			error,
+			summary,
+			null
+		from
+			scan_runs
+		order by
+			started_at desc,
+			id desc
+		limit ? offset ?
+		`,
+		pageSize,
+		(page-1)*pageSize,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return runs, total, nil
+}
+
+func (repo VideoRepository) FindScanRun(id int64) (*ScanRun, error) {
+	runs, err := repo.queryScanRuns(
+		`
+		select
+			id,
+			library_id,
+			started_at,
+			finished_at,
+			error,
+			summary,
+			result
+		from
+			scan_runs
+		where
+			id = ?
+		`,
+		id,
+	)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+
+	return &runs[0], nil
+}
+
+func (repo VideoRepository) queryScanRuns(query string, args ...any) ([]ScanRun, error) {
+	rows, err := repo.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ScanRun{}
+	for rows.Next() {
+		var run ScanRun
+		var libraryId sql.NullInt32
+		var summary string
+		var result sql.NullString
+
+		err = rows.Scan(
+			&run.Id,
+			&libraryId,
+			&run.StartedAt,
+			&run.FinishedAt,
+			&run.Error,
+			&summary,
+			&result,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if libraryId.Valid {
+			run.LibraryId = &libraryId.Int32
+		}
+
+		if err = json.Unmarshal([]byte(summary), &run.Summary); err != nil {
+			return nil, err
+		}
+
+		if result.Valid {
+			run.Result = &ScanResult{}
+			if err = json.Unmarshal([]byte(result.String), run.Result); err != nil {
+				return nil, err
+			}
+		}
+
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+func (repo VideoRepository) ListStoredFiles(libraryId int32) ([]StoredFile, error) {
+	rows, err := repo.db.Query(
+		`
+		select
+			id,
+			filename,
+			status,
+			file_size,
+			fingerprint,
+			missing_since is not null
+		from
+			videos
+		where
+			library_id = ?
+		`,
+		libraryId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []StoredFile
+	for rows.Next() {
+		var file StoredFile
+		err = rows.Scan(
+			&file.Id,
+			&file.Filename,
+			&file.Status,
+			&file.Size,
+			&file.Fingerprint,
+			&file.Missing,
+		)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	return files, rows.Err()
+}
+
+func (repo VideoRepository) ApplyScan(changes ScanChanges) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range changes.Files {
+		_, err = tx.Exec(
+			`
+			update videos set
+				file_size = ?,
+				fingerprint = coalesce(?, fingerprint),
+				checksum_status = iif(file_size is ?, checksum_status, null),
+				missing_since = null
+			where
+				id = ?
+			`,
+			file.Size,
+			sql.NullString(file.Fingerprint),
+			file.Size,
+			file.Id,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for _, rename := range changes.Renamed {
+		_, err = tx.Exec(
+			"update videos set filename = ?, missing_since = null where id = ?",
+			rename.Filename,
+			rename.Id,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = reindexVideos(tx, "id = ?", rename.Id); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = parseReleases(tx, "id = ?", rename.Id); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		log.Printf("Video %v was renamed to %v", rename.Id, rename.Filename)
+	}
+
+	now := time.Now().UTC()
+	for _, id := range changes.Missing {
+		_, err = tx.Exec("update videos set missing_since = ? where id = ?", now, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// scanLibraries reconciles the libraries with their folders and stores the run,
+// even when it fails or is cancelled halfway. The library id is zero when every
+// library is scanned.
+func (app App) scanLibraries(ctx context.Context, libraries []Library, libraryId int32, progress func(any)) (ScanRun, error) {
+	app.scans.Lock()
+	defer app.scans.Unlock()
+
+	run := ScanRun{StartedAt: time.Now()}
+	if libraryId != 0 {
+		run.LibraryId = &libraryId
+	}
+
+	app.Events.Publish(EventScanStarted, ScanStartedEvent{LibraryId: run.LibraryId, Libraries: len(libraries)})
+
+	result := newScanResult()
+	var scanErr error
+	for i, library := range libraries {
+		libraryResult, err := app.reconcileLibrary(ctx, library)
+		result.add(libraryResult)
+		app.publishImports(libraryResult)
+
+		if err == nil {
+			err = app.probeLibraryMedia(ctx, library)
+		}
+
+		if err != nil {
+			scanErr = err
+			run.Error = NullString{String: err.Error(), Valid: true}
+			break
+		}
+
+		event := ScanProgressEvent{
+			LibraryId: library.Id,
+			Library:   library.Name,
+			Done:      i + 1,
+			Total:     len(libraries),
+			Summary:   libraryResult.summary(),
+		}
+		app.Events.Publish(EventScanProgress, event)
+		progress(event)
+	}
+
+	run.FinishedAt = time.Now()
+	run.Summary = result.summary()
+	run.Result = &result
+
+	err := app.Repo.SaveScanRun(&run)
+
+	// the full result can be large, listeners can fetch it from the scan runs
+	finished := run
+	finished.Result = nil
+	app.Events.Publish(EventScanFinished, finished)
+
+	if scanErr != nil {
+		return run, scanErr
+	}
+
+	return run, err
+}
+
+// reconcileLibrary brings the repository in line with the library folder. New
+// files are imported, unless they match the size and fingerprint of a video
+// whose file vanished, in which case it was renamed. Vanished files are
+// flagged as missing, and files that came back, or were downloaded again after
+// being truncated, are restored.
+func (app App) reconcileLibrary(ctx context.Context, library Library) (ScanResult, error) {
+	var result ScanResult
+
+	entries, err := app.Repo.ListDirVideos(library)
+	if err != nil {
+		return result, err
+	}
+
+	stored, err := app.Repo.ListStoredFiles(library.Id)
+	if err != nil {
+		return result, err
+	}
+
+	byFilename := make(map[string]StoredFile, len(stored))
+	for _, file := range stored {
+		byFilename[file.Filename] = file
+	}
+
+	var changes ScanChanges
+	var added []VideoFsEntry
+	seen := make(map[int32]bool, len(stored))
+
+	for _, entry := range entries {
+		if err = ctx.Err(); err != nil {
+			return result, err
+		}
+
+		file, known := byFilename[entry.Filename]
+		if known {
+			seen[file.Id] = true
+		}
+
+		scanEntry := ScanEntry{LibraryId: library.Id, VideoId: file.Id, Filename: entry.Filename}
+		if entry.Err != nil {
+			scanEntry.Error = entry.Err.Error()
+			result.Failed = append(result.Failed, scanEntry)
+			continue
+		}
+
+		if !known {
+			added = append(added, entry)
+			continue
+		}
+
+		sizeChanged := !file.Size.Valid || file.Size.Int64 != entry.Size
+		needsFingerprint := entry.Size > 0 && (sizeChanged || !file.Fingerprint.Valid)
+		if !sizeChanged && !needsFingerprint && !file.Missing {
+			result.Skipped = append(result.Skipped, scanEntry)
+			continue
+		}
+
+		state := FileState{Id: file.Id, Size: entry.Size}
+		if needsFingerprint {
+			state.Fingerprint, err = app.entryFingerprint(library, entry)
+			if err != nil {
+				scanEntry.Error = err.Error()
+				result.Failed = append(result.Failed, scanEntry)
+				continue
+			}
+		}
+		changes.Files = append(changes.Files, state)
+
+		wasTruncated := false
+		if entry.Size > 0 {
+			wasTruncated, err = app.knownEmpty(file)
+			if err != nil {
+				return result, err
+			}
+		}
+
+		if file.Missing || wasTruncated {
+			result.Restored = append(result.Restored, scanEntry)
+		} else {
+			result.Skipped = append(result.Skipped, scanEntry)
+		}
+	}
+
+	var vanished []StoredFile
+	for _, file := range stored {
+		if !seen[file.Id] {
+			vanished = append(vanished, file)
+		}
+	}
+
+	var imported []VideoFsEntry
+	for _, entry := range added {
+		if err = ctx.Err(); err != nil {
+			return result, err
+		}
+
+		if entry.Size > 0 {
+			entry.Fingerprint, err = app.entryFingerprint(library, entry)
+			if err != nil {
+				result.Failed = append(result.Failed, ScanEntry{
+					LibraryId: library.Id,
+					Filename:  entry.Filename,
+					Error:     err.Error(),
+				})
+				continue
+			}
+		}
+
+		match := findRenamed(vanished, entry)
+		if match < 0 {
+			imported = append(imported, entry)
+			continue
+		}
+
+		changes.Renamed = append(changes.Renamed, FileRename{Id: vanished[match].Id, Filename: entry.Filename})
+		result.Renamed = append(result.Renamed, ScanEntry{
+			LibraryId:        library.Id,
+			VideoId:          vanished[match].Id,
+			Filename:         entry.Filename,
+			PreviousFilename: vanished[match].Filename,
+		})
+		vanished = append(vanished[:match], vanished[match+1:]...)
+	}
+
+	for _, file := range vanished {
+		if file.Missing {
+			continue
+		}
+
+		expected, err := app.expectsFile(file)
+		if err != nil {
+			return result, err
+		}
+
+		if expected {
+			changes.Missing = append(changes.Missing, file.Id)
+			result.Missing = append(result.Missing, ScanEntry{
+				LibraryId: library.Id,
+				VideoId:   file.Id,
+				Filename:  file.Filename,
+			})
+		}
+	}
+
+	if err = app.Repo.ApplyScan(changes); err != nil {
+		return result, err
+	}
+
+	importResult, err := app.Repo.ImportFsEntries(library.Id, imported)
+	if err != nil {
+		return result, err
+	}
+	result.add(importResult)
+
+	return result, nil
+}
+
+// findRenamed returns the index of the vanished file that has the same size and
+// fingerprint as the entry, or -1 when the entry is a new file.
+func findRenamed(vanished []StoredFile, entry VideoFsEntry) int {
+	if !entry.Fingerprint.Valid {
+		return -1
+	}
+
+	for i, file := range vanished {
+		if file.Size.Valid && file.Size.Int64 == entry.Size && file.Fingerprint == entry.Fingerprint {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// expectsFile tells whether the file of a video should be in the folder, which
+// isn't the case when its disposal moved or deleted it.
+func (app App) expectsFile(file StoredFile) (bool, error) {
+	pending, err := app.Repo.FindPendingDisposal(file.Id)
+	if err != nil {
+		return false, err
+	}
+
+	if pending != nil {
+		return false, nil
+	}
+
+	mode := app.Config.DisposalFor(file.Status)
+	return mode != DisposalDelete && mode != DisposalArchive, nil
+}
+
+// knownEmpty tells whether the file of a video was empty when last seen. The
+// size of videos imported before it was stored is unknown, their file is
+// expected empty when their status truncates it and it isn't still waiting
+// for the grace period to end.
+func (app App) knownEmpty(file StoredFile) (bool, error) {
+	if file.Size.Valid {
+		return file.Size.Int64 == 0, nil
+	}
+
+	if file.Status == VideoUnwatched || app.Config.DisposalFor(file.Status) != DisposalTruncate {
+		return false, nil
+	}
+
+	pending, err := app.Repo.FindPendingDisposal(file.Id)
+	return pending == nil, err
+}
+
+func (app App) entryFingerprint(library Library, entry VideoFsEntry) (NullString, error) {
+	path, err := resolveInFolder(library.VideoFolder, entry.Filename)
+	if err != nil {
+		return NullString{}, err
+	}
+
+	fingerprint, err := fileFingerprint(path)
+	if err != nil {
+		return NullString{}, err
+	}
+
+	return NullString{String: fingerprint, Valid: true}, nil
+}
+
+// fileFingerprint hashes the size of the file along with its first and last
+// few megabytes, which is enough to recognize a file without reading it all.
+func fileFingerprint(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	binary.Write(hash, binary.LittleEndian, info.Size())
+
+	if info.Size() <= 2*fingerprintChunk {
+		if _, err = io.Copy(hash, file); err != nil {
+			return "", err
+		}
+	} else {
+		if _, err = io.CopyN(hash, file, fingerprintChunk); err != nil {
+			return "", err
+		}
+
+		tail := io.NewSectionReader(file, info.Size()-fingerprintChunk, fingerprintChunk)
+		if _, err = io.Copy(hash, tail); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,130 @@
+package internals
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// The search index needs sqlite to be compiled with FTS5, which go-sqlite3 only
+// does when building with the "sqlite_fts5" tag.
+
+const searchIndexColumns = `
+	videos.id,
+	videos.filename,
+	coalesce(videos.nickname, ''),
+	coalesce(
+		(
+			select
+				group_concat(tags.name, ' ')
+			from
+				video_tags
+				join tags on tags.id = video_tags.tag_id
+			where
+				video_tags.video_id = videos.id
+		),
+		''
+	)
+`
+
+// SearchVideos runs a full text search over the filename, nickname and tags of
+// the videos, every word of the text matches as a prefix. The results are
+// ranked by relevance and optionally filtered by status.
+func (repo VideoRepository) SearchVideos(text string, statuses []VideoStatus, query VideoListQuery) ([]Video, int, error) {
+	match := searchMatchExpression(text)
+	if match == "" {
+		return []Video{}, 0, nil
+	}
+
+	where, args := statusFilterClause(statuses)
+	args = append([]any{match}, args...)
+
+	var total int
+	err := repo.db.QueryRow(
+		`
+		select
+			count(videos.id)
+		from
+			videos_search
+			join videos on videos.id = videos_search.rowid
+		where
+			videos_search match ?
+			and `+where,
+		args...,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	videos, err := repo.queryVideos(
+		fmt.Sprintf(
+			`
+			select
+				%v
+			from
+				videos_search
+				join videos on videos.id = videos_search.rowid
+			where
+				videos_search match ?
+				and %v
+			order by
+				bm25(videos_search, 1.0, 2.0, 2.0),
+				videos.id
+			limit ? offset ?
+			`,
+			videoColumns,
+			where,
+		),
+		append(args, query.PageSize, query.Offset())...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return videos, total, nil
+}
+
+// searchMatchExpression turns free text into an FTS5 query where each word is
+// a quoted prefix, so user input can't inject FTS operators.
+func searchMatchExpression(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		word = strings.ReplaceAll(word, `"`, "")
+		if word == "" {
+			continue
+		}
+
+		terms = append(terms, `"`+word+`"*`)
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// reindexVideos rebuilds the search entries of the videos matching the filter.
+func reindexVideos(tx *sql.Tx, filter string, args ...any) error {
+	_, err := tx.Exec(
+		"delete from videos_search where rowid in (select id from videos where "+filter+")",
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`
+		insert into videos_search (rowid, filename, nickname, tags)
+		select
+			`+searchIndexColumns+`
+		from
+			videos
+		where
+			`+filter,
+		args...,
+	)
+	return err
+}
+
+// indexNewVideos adds the videos that are not in the search index yet.
+func indexNewVideos(tx *sql.Tx) error {
+	return reindexVideos(tx, "id not in (select rowid from videos_search)")
+}
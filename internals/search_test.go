@@ -0,0 +1,60 @@
+//go:build sqlite_fts5
+
+package internals
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSearchMatchExpression(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "frie", want: `"frie"*`},
+		{text: "  sousou  no  ", want: `"sousou"* "no"*`},
+		{text: `say "hi" OR`, want: `"say"* "hi"* "OR"*`},
+		{text: `"`, want: ""},
+		{text: "", want: ""},
+	}
+
+	for _, test := range tests {
+		if got := searchMatchExpression(test.text); got != test.want {
+			t.Errorf("searchMatchExpression(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestSearchVideosMatchesPrefix(t *testing.T) {
+	repo, err := NewRepository(Config{
+		Database:  filepath.Join(t.TempDir(), "videos.db"),
+		Libraries: []Library{{Name: DefaultLibraryName, VideoFolder: t.TempDir()}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	_, err = repo.ImportFsEntries(repo.Libraries()[0].Id, []VideoFsEntry{
+		{Filename: "[Group] Frieren - 01 (1080p).mkv", LastModifiedTime: time.Now()},
+		{Filename: "[Group] Other Show - 01 (1080p).mkv", LastModifiedTime: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, text := range []string{"frie", "frieren", "FRIEREN 01"} {
+		videos, total, err := repo.SearchVideos(text, nil, VideoListQuery{Page: 1, PageSize: 10})
+		if err != nil {
+			t.Fatalf("SearchVideos(%q): %v", text, err)
+		}
+
+		if total != 1 || len(videos) != 1 || videos[0].Filename != "[Group] Frieren - 01 (1080p).mkv" {
+			t.Errorf("SearchVideos(%q) = %v videos (total %v), want the Frieren episode", text, len(videos), total)
+		}
+	}
+}
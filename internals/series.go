@@ -0,0 +1,380 @@
+package internals
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// QueueOrder is how the unwatched videos are lined up in the queue.
+type QueueOrder string
+
+const (
+	// QueueByCreatedAt plays the videos in the order they were found.
+	QueueByCreatedAt QueueOrder = "created_at"
+	// QueueBySeries plays every episode of a series in episode order, series
+	// come in the order their oldest unwatched episode was found.
+	QueueBySeries QueueOrder = "series"
+)
+
+// episodeOrder sorts the episodes of a series.
+const episodeOrder = `
+	videos.season is null,
+	videos.season,
+	videos.episode is null,
+	videos.episode,
+	videos.special,
+	videos.episode_end,
+	videos.created_at,
+	videos.id
+`
+
+var (
+	ErrSeriesNotFound     = errors.New("series not found")
+	ErrSeriesWrongLibrary = errors.New("series belongs to another library")
+)
+
+type Series struct {
+	Id        int32  `json:"id"`
+	LibraryId int32  `json:"library_id"`
+	Title     string `json:"title"`
+	Season    *int   `json:"season"`
+	Episodes  int    `json:"episodes"`
+	Unwatched int    `json:"unwatched"`
+	Watched   int    `json:"watched"`
+	Liked     int    `json:"liked"`
+	Saved     int    `json:"saved"`
+	Next      *Video `json:"next"`
+}
+
+type SeriesListResponse struct {
+	Series []Series `json:"series"`
+}
+
+type SeriesResponse struct {
+	Series   Series  `json:"series"`
+	Episodes []Video `json:"episodes"`
+}
+
+// SeriesAssignPayload moves a video to a series, given by id or by title and
+// season, in which case it is created when needed. Without either the video
+// is left out of any series. Automatic hands the video back to the filename
+// parser.
+type SeriesAssignPayload struct {
+	SeriesId  *int32 `json:"series_id"`
+	Title     string `json:"title"`
+	Season    *int   `json:"season"`
+	Automatic bool   `json:"automatic"`
+}
+
+func (order QueueOrder) valid() bool {
+	return order == QueueByCreatedAt || order == QueueBySeries
+}
+
+// ParseQueueOrder reads the "queue_order" query parameter, falling back to the
+// configured order.
+func ParseQueueOrder(values url.Values, fallback QueueOrder) (QueueOrder, error) {
+	value := values.Get("queue_order")
+	if value == "" {
+		return fallback, nil
+	}
+
+	order := QueueOrder(strings.ToLower(value))
+	if !order.valid() {
+		return "", fmt.Errorf("invalid value for queue_order \"%v\"", value)
+	}
+
+	return order, nil
+}
+
+func (order QueueOrder) clause() string {
+	if order == QueueBySeries {
+		return `
+			coalesce(
+				(
+					select
+						min(first.created_at)
+					from
+						videos as first
+					where
+						first.series_id = videos.series_id
+						and first.status = videos.status
+						and first.missing_since is null
+				),
+				videos.created_at
+			),
+			videos.series_id,
+		` + episodeOrder
+	}
+
+	return "videos.created_at"
+}
+
+func (repo VideoRepository) ListSeries() ([]Series, error) {
+	series, err := repo.querySeries("")
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := repo.nextEpisodes()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range series {
+		if video, found := next[series[i].Id]; found {
+			series[i].Next = &video
+		}
+	}
+
+	return series, nil
+}
+
+func (repo VideoRepository) FindSeries(id int32) (*Series, error) {
+	series, err := repo.querySeries("where series.id = ?", id)
+	if err != nil || len(series) == 0 {
+		return nil, err
+	}
+
+	next, err := repo.nextEpisodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if video, found := next[id]; found {
+		series[0].Next = &video
+	}
+
+	return &series[0], nil
+}
+
+func (repo VideoRepository) ListSeriesEpisodes(id int32) ([]Video, error) {
+	return repo.queryVideos(
+		`
+		select
+			`+videoColumns+`
+		from
+			videos
+		where
+			series_id = ?
+		order by
+			`+episodeOrder,
+		id,
+	)
+}
+
+func (repo VideoRepository) querySeries(filter string, args ...any) ([]Series, error) {
+	rows, err := repo.db.Query(
+		`
+		select
+			series.id,
+			series.library_id,
+			series.title,
+			series.season,
+			count(videos.id),
+			count(videos.id) filter (where videos.status = ?),
+			count(videos.id) filter (where videos.status = ?),
+			count(videos.id) filter (where videos.status = ?),
+			count(videos.id) filter (where videos.status = ?)
+		from
+			series
+			left join videos on videos.series_id = series.id
+		`+filter+`
+		group by
+			series.id
+		order by
+			series.title collate nocase,
+			series.season
+		`,
+		append([]any{VideoUnwatched, VideoWatched, VideoLiked, VideoSaved}, args...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := []Series{}
+	for rows.Next() {
+		var item Series
+		var season sql.NullInt64
+
+		err = rows.Scan(
+			&item.Id,
+			&item.LibraryId,
+			&item.Title,
+			&season,
+			&item.Episodes,
+			&item.Unwatched,
+			&item.Watched,
+			&item.Liked,
+			&item.Saved,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		item.Season = nullableInt(season)
+		series = append(series, item)
+	}
+
+	return series, rows.Err()
+}
+
+// nextEpisodes returns the first unwatched episode of every series.
+func (repo VideoRepository) nextEpisodes() (map[int32]Video, error) {
+	videos, err := repo.queryVideos(
+		`
+		select
+			`+videoColumns+`
+		from
+			(
+				select
+					videos.*,
+					row_number() over (partition by videos.series_id order by `+episodeOrder+`) as position
+				from
+					videos
+				where
+					videos.status = ?
+					and videos.missing_since is null
+					and videos.series_id is not null
+			) as videos
+		where
+			position = 1
+		`,
+		VideoUnwatched,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	next := make(map[int32]Video, len(videos))
+	for _, video := range videos {
+		next[*video.SeriesId] = video
+	}
+
+	return next, nil
+}
+
+// AssignSeries sets the series of a video by hand, the parser won't change it
+// until the assignment is made automatic again.
+func (repo VideoRepository) AssignSeries(videoId int32, payload SeriesAssignPayload) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var libraryId int32
+	err = tx.QueryRow("select library_id from videos where id = ?", videoId).Scan(&libraryId)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrVideoNotFound
+		}
+		return err
+	}
+
+	if payload.Automatic {
+		_, err = tx.Exec("update videos set series_manual = false where id = ?", videoId)
+		if err == nil {
+			err = parseReleases(tx, "id = ?", videoId)
+		}
+	} else {
+		err = assignSeriesByHand(tx, videoId, libraryId, payload)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = deleteEmptySeries(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func assignSeriesByHand(tx *sql.Tx, videoId int32, libraryId int32, payload SeriesAssignPayload) error {
+	var seriesId sql.NullInt32
+
+	switch {
+	case payload.SeriesId != nil:
+		var seriesLibrary int32
+		err := tx.QueryRow("select library_id from series where id = ?", *payload.SeriesId).Scan(&seriesLibrary)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSeriesNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if seriesLibrary != libraryId {
+			return ErrSeriesWrongLibrary
+		}
+
+		seriesId = sql.NullInt32{Int32: *payload.SeriesId, Valid: true}
+	case strings.TrimSpace(payload.Title) != "":
+		var err error
+		seriesId, err = ensureSeries(tx, libraryId, strings.TrimSpace(payload.Title), payload.Season)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(
+		"update videos set series_id = ?, series_manual = true where id = ?",
+		seriesId,
+		videoId,
+	)
+	return err
+}
+
+// ensureSeries returns the id of the series, creating it when needed. Titles
+// are compared ignoring case.
+func ensureSeries(tx *sql.Tx, libraryId int32, title string, season *int) (sql.NullInt32, error) {
+	_, err := tx.Exec(
+		"insert into series (library_id, title, season) values (?, ?, ?) on conflict do nothing",
+		libraryId,
+		title,
+		season,
+	)
+	if err != nil {
+		return sql.NullInt32{}, err
+	}
+
+	var id sql.NullInt32
+	err = tx.QueryRow(
+		`
+		select
+			id
+		from
+			series
+		where
+			library_id = ?
+			and title = ? collate nocase
+			and ifnull(season, 0) = ifnull(?, 0)
+		`,
+		libraryId,
+		title,
+		season,
+	).Scan(&id)
+
+	return id, err
+}
+
+func deleteEmptySeries(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		delete from series
+		where id not in (select series_id from videos where series_id is not null)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("delete from skip_markers where series_id not in (select id from series)")
+	return err
+}
@@ -0,0 +1,249 @@
+package internals
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-video-viewer/internals/remux"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// remuxJobKey keeps a single indexing of the library running, the videos
+// requested before it reaches them are indexed by their own job.
+const remuxJobKey = "remux"
+
+// ErrStreamPending means the video is still being indexed, the stream can be
+// requested again in a moment.
+var ErrStreamPending = errors.New("the video stream is still being indexed")
+
+type RemuxProgress struct {
+	Done     int    `json:"done"`
+	Total    int    `json:"total"`
+	Filename string `json:"filename"`
+}
+
+type RemuxResult struct {
+	Indexed int `json:"indexed"`
+}
+
+// videoStream closes the video file along with its stream.
+type videoStream struct {
+	*remux.Stream
+	file *os.File
+}
+
+func (stream videoStream) Close() error {
+	return stream.file.Close()
+}
+
+// VideoStream returns the video remuxed to a fragmented MP4, along with the time
+// its file was last modified. Indexing the fragments reads the whole file, so
+// it's done in the background after the scans, a video that wasn't indexed yet
+// starts its own indexing and fails with ErrStreamPending. Videos whose probe
+// tells they can't be remuxed fail with remux.ErrUnsupported right away.
+func (app App) VideoStream(video Video) (io.ReadSeekCloser, time.Time, error) {
+	media, err := app.VideoMedia(video)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// truncated files have nothing left to play
+	if media == nil || media.Container != "matroska" || !remux.Supported(*media) {
+		return nil, time.Time{}, remux.ErrUnsupported
+	}
+
+	videoPath, err := app.VideoPath(video)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	stat, err := os.Stat(videoPath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	folder := filepath.Join(app.Config.CacheFolder, "remux")
+	key := cacheKey(video.Id, stat)
+
+	index, err := readRemuxIndex(filepath.Join(folder, key+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		if failure := readCacheFailure(folder, key); failure != nil {
+			return nil, time.Time{}, fmt.Errorf("%w: %v", remux.ErrUnsupported, failure)
+		}
+
+		app.startRemuxIndex(video)
+		return nil, time.Time{}, ErrStreamPending
+	}
+
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	file, err := os.Open(videoPath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	stream, err := remux.NewStream(file, stat.Size(), index)
+	if err != nil {
+		file.Close()
+		return nil, time.Time{}, err
+	}
+
+	return videoStream{Stream: stream, file: file}, stat.ModTime(), nil
+}
+
+// remuxReady tells if the stream of the video is indexed. It only looks at the
+// cache, the indexing is started by the scans and by the stream requests.
+func (app App) remuxReady(video Video) bool {
+	videoPath, err := app.VideoPath(video)
+	if err != nil {
+		return false
+	}
+
+	stat, err := os.Stat(videoPath)
+	if err != nil || stat.Size() == 0 {
+		return false
+	}
+
+	folder := filepath.Join(app.Config.CacheFolder, "remux")
+	key := cacheKey(video.Id, stat)
+
+	_, err = os.Stat(filepath.Join(folder, key+".json"))
+	return err == nil
+}
+
+// StartRemuxIndexing indexes the Matroska videos that can be remuxed and
+// weren't indexed yet in the background, reading at most index_rate megabytes
+// per second so it doesn't starve the videos being played.
+func (app App) StartRemuxIndexing() (Job, bool) {
+	return app.Jobs.Start("remux", remuxJobKey, func(ctx context.Context, progress func(any)) (any, error) {
+		videos, err := app.Repo.VideosWithFiles()
+		if err != nil {
+			return nil, err
+		}
+
+		var result RemuxResult
+		for i, video := range videos {
+			if err = ctx.Err(); err != nil {
+				return result, err
+			}
+
+			media, err := app.VideoMedia(video)
+			if err != nil || media == nil || media.Container != "matroska" || !remux.Supported(*media) {
+				continue
+			}
+
+			progress(RemuxProgress{Done: i, Total: len(videos), Filename: video.Filename})
+
+			indexed, err := app.buildRemuxIndex(ctx, video, app.Config.IndexRate)
+			if err != nil {
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+
+				log.Printf("Failed to index the stream of '%v': %v", video.Filename, err)
+				continue
+			}
+
+			if indexed {
+				result.Indexed++
+			}
+		}
+
+		return result, nil
+	})
+}
+
+// startRemuxIndex indexes a single video in the background, so it doesn't wait
+// for the indexing of the whole library. It's requested by a player, so it
+// reads as fast as it can.
+func (app App) startRemuxIndex(video Video) {
+	key := fmt.Sprintf("%v-%v", remuxJobKey, video.Id)
+	app.Jobs.Start("remux", key, func(ctx context.Context, progress func(any)) (any, error) {
+		var result RemuxResult
+		indexed, err := app.buildRemuxIndex(ctx, video, 0)
+		if indexed {
+			result.Indexed++
+		}
+
+		return result, err
+	})
+}
+
+// buildRemuxIndex reads the video file to cache its index, at most rate
+// megabytes per second when positive, telling if it was built. Files that
+// can't be indexed are remembered as failed.
+func (app App) buildRemuxIndex(ctx context.Context, video Video, rate int) (bool, error) {
+	videoPath, err := app.VideoPath(video)
+	if err != nil {
+		return false, err
+	}
+
+	stat, err := os.Stat(videoPath)
+	if err != nil {
+		return false, err
+	}
+
+	folder := filepath.Join(app.Config.CacheFolder, "remux")
+	key := cacheKey(video.Id, stat)
+	cachePath := filepath.Join(folder, key+".json")
+
+	unlock := app.extractions.Lock(video.Id)
+	defer unlock()
+
+	// another job may have built it while this one waited
+	if _, err = os.Stat(cachePath); err == nil || readCacheFailure(folder, key) != nil {
+		return false, nil
+	}
+
+	if err = os.MkdirAll(folder, 0755); err != nil {
+		return false, err
+	}
+
+	removeStaleCache(folder, video.Id, key)
+
+	index, err := buildRemuxIndexFile(ctx, videoPath, stat.Size(), rate)
+	if err != nil {
+		if ctx.Err() == nil {
+			if failure := writeCacheFailure(folder, key, err); failure != nil {
+				log.Printf("Failed to remember the index failure of '%v': %v", video.Filename, failure)
+			}
+		}
+
+		return false, err
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return false, err
+	}
+
+	return true, writeFileAtomic(cachePath, data)
+}
+
+func buildRemuxIndexFile(ctx context.Context, videoPath string, size int64, rate int) (remux.Index, error) {
+	file, err := os.Open(videoPath)
+	if err != nil {
+		return remux.Index{}, err
+	}
+	defer file.Close()
+
+	return remux.Build(ctx, limitedReader{ReadSeeker: file, limiter: newReadLimiter(ctx, rate)}, size)
+}
+
+func readRemuxIndex(cachePath string) (remux.Index, error) {
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		return remux.Index{}, err
+	}
+
+	var index remux.Index
+	err = json.Unmarshal(data, &index)
+	return index, err
+}
@@ -0,0 +1,346 @@
+package subtitle
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// assStyle is the part of an ASS style that WebVTT can show.
+type assStyle struct {
+	bold      bool
+	italic    bool
+	underline bool
+	alignment int
+}
+
+// Script holds the styles of an ASS/SSA script, which its events refer to.
+type Script struct {
+	styles map[string]assStyle
+}
+
+// ParseASS reads the dialogue events of an ASS or SSA script. Comments and
+// drawings are left out.
+func ParseASS(text string) []Cue {
+	script := ParseScriptHeader(text)
+
+	var cues []Cue
+	var format []string
+	inEvents := false
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+
+		if !inEvents {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		switch strings.TrimSpace(key) {
+		case "Format":
+			format = splitFormat(value)
+		case "Dialogue":
+			if format == nil {
+				format = splitFormat("Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text")
+			}
+
+			fields := splitFields(value, len(format))
+			start, okStart := parseClock(fieldOf(format, fields, "start"))
+			end, okEnd := parseClock(fieldOf(format, fields, "end"))
+			if !okStart || !okEnd {
+				continue
+			}
+
+			cues = append(cues, script.Cue(start, end, fieldOf(format, fields, "style"), fieldOf(format, fields, "text")))
+		}
+	}
+
+	return cues
+}
+
+// ParseScriptHeader reads the styles of a script, which can also come from the
+// header of a subtitle track embedded in a video.
+func ParseScriptHeader(text string) Script {
+	script := Script{styles: map[string]assStyle{}}
+
+	var format []string
+	section := ""
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			section = strings.ToLower(line)
+			format = nil
+			continue
+		}
+
+		if section != "[v4+ styles]" && section != "[v4 styles]" {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		switch strings.TrimSpace(key) {
+		case "Format":
+			format = splitFormat(value)
+		case "Style":
+			if format == nil {
+				continue
+			}
+
+			fields := splitFields(value, len(format))
+			alignment, _ := strconv.Atoi(fieldOf(format, fields, "alignment"))
+			if section == "[v4 styles]" {
+				alignment = legacyAlignment(alignment)
+			}
+
+			script.styles[strings.ToLower(fieldOf(format, fields, "name"))] = assStyle{
+				bold:      assFlag(fieldOf(format, fields, "bold")),
+				italic:    assFlag(fieldOf(format, fields, "italic")),
+				underline: assFlag(fieldOf(format, fields, "underline")),
+				alignment: alignment,
+			}
+		}
+	}
+
+	return script
+}
+
+// Cue converts the text of an event with the given style to a WebVTT cue.
+func (script Script) Cue(start time.Duration, end time.Duration, styleName string, text string) Cue {
+	style, found := script.styles[strings.ToLower(strings.TrimPrefix(strings.TrimSpace(styleName), "*"))]
+	if !found {
+		style = script.styles["default"]
+	}
+
+	markup, alignment := assText(text, style)
+	return Cue{Start: start, End: end, Text: markup, Settings: alignmentSettings(alignment)}
+}
+
+// BlockCue converts an event stored in a Matroska block, whose fields are
+// ReadOrder, Layer, Style, Name, MarginL, MarginR, MarginV, Effect and Text.
+func (script Script) BlockCue(start time.Duration, end time.Duration, block string) Cue {
+	fields := splitFields(block, 9)
+	if len(fields) < 9 {
+		return Cue{Start: start, End: end}
+	}
+
+	return script.Cue(start, end, fields[2], fields[8])
+}
+
+func splitFormat(value string) []string {
+	fields := strings.Split(value, ",")
+	for i, field := range fields {
+		fields[i] = strings.ToLower(strings.TrimSpace(field))
+	}
+
+	return fields
+}
+
+// splitFields splits the values of a line, the last one being free to contain
+// commas.
+func splitFields(value string, count int) []string {
+	fields := strings.SplitN(value, ",", count)
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	return fields
+}
+
+func fieldOf(format []string, fields []string, name string) string {
+	for i, field := range format {
+		if field == name && i < len(fields) {
+			return fields[i]
+		}
+	}
+
+	return ""
+}
+
+// assFlag reads the booleans of the styles, which are -1 when true.
+func assFlag(value string) bool {
+	number, err := strconv.Atoi(strings.TrimSpace(value))
+	return err == nil && number != 0
+}
+
+// legacyAlignment converts the SSA alignment, where 1 to 3 are the bottom, 5 to
+// 7 the top and 9 to 11 the middle, to the numpad layout of ASS.
+func legacyAlignment(value int) int {
+	switch {
+	case value >= 5 && value <= 7:
+		return value + 2
+	case value >= 9 && value <= 11:
+		return value - 5
+	default:
+		return value
+	}
+}
+
+// alignmentSettings positions a cue like the numpad alignment of ASS. Bottom
+// center is the default of both formats.
+func alignmentSettings(alignment int) string {
+	var settings []string
+
+	switch alignment {
+	case 7, 8, 9:
+		settings = append(settings, "line:0")
+	case 4, 5, 6:
+		settings = append(settings, "line:50%")
+	}
+
+	switch alignment {
+	case 1, 4, 7:
+		settings = append(settings, "align:start")
+	case 3, 6, 9:
+		settings = append(settings, "align:end")
+	}
+
+	return strings.Join(settings, " ")
+}
+
+// overrideAlignment finds the \an or \a tag of an override block.
+func overrideAlignment(block string) (int, bool) {
+	alignment, found := 0, false
+	for _, tag := range strings.Split(block, "\\") {
+		if value, ok := strings.CutPrefix(tag, "an"); ok {
+			if number, err := strconv.Atoi(value); err == nil {
+				alignment, found = number, true
+			}
+		} else if value, ok := strings.CutPrefix(tag, "a"); ok {
+			if number, err := strconv.Atoi(value); err == nil {
+				alignment, found = legacyAlignment(number), true
+			}
+		}
+	}
+
+	return alignment, found
+}
+
+// assText converts the text of an event to WebVTT markup, following the bold,
+// italic and underline overrides. It returns the alignment of the event.
+func assText(text string, style assStyle) (string, int) {
+	var builder strings.Builder
+
+	current := assStyle{}
+	wanted := style
+	alignment := style.alignment
+	drawing := false
+
+	// the tags are reopened after any change so they stay nested
+	apply := func() {
+		if current == wanted {
+			return
+		}
+
+		if current.underline {
+			builder.WriteString("</u>")
+		}
+		if current.italic {
+			builder.WriteString("</i>")
+		}
+		if current.bold {
+			builder.WriteString("</b>")
+		}
+
+		if wanted.bold {
+			builder.WriteString("<b>")
+		}
+		if wanted.italic {
+			builder.WriteString("<i>")
+		}
+		if wanted.underline {
+			builder.WriteString("<u>")
+		}
+
+		current = wanted
+	}
+
+	for len(text) > 0 {
+		if strings.HasPrefix(text, "{") {
+			end := strings.IndexByte(text, '}')
+			if end < 0 {
+				break
+			}
+
+			block := text[1:end]
+			text = text[end+1:]
+
+			if found, ok := overrideAlignment(block); ok {
+				alignment = found
+			}
+
+			for _, tag := range strings.Split(block, "\\")[1:] {
+				switch {
+				case strings.HasPrefix(tag, "r"):
+					wanted = style
+				case len(tag) >= 1 && tag[0] == 'p' && isNumber(tag[1:]):
+					drawing = tag[1:] != "0"
+				case len(tag) >= 1 && tag[0] == 'b' && isNumber(tag[1:]):
+					// \b700 is a font weight
+					weight, _ := strconv.Atoi(tag[1:])
+					wanted.bold = weight == 1 || weight >= 600
+				case len(tag) >= 1 && tag[0] == 'i' && isNumber(tag[1:]):
+					wanted.italic = tag[1:] == "1"
+				case len(tag) >= 1 && tag[0] == 'u' && isNumber(tag[1:]):
+					wanted.underline = tag[1:] == "1"
+				}
+			}
+			continue
+		}
+
+		next := strings.IndexAny(text, "{\\")
+		if next < 0 {
+			next = len(text)
+		}
+
+		if next == 0 {
+			// a backslash that isn't the start of a tag
+			escape := text[:min(2, len(text))]
+			text = text[len(escape):]
+			if drawing {
+				continue
+			}
+
+			apply()
+			switch escape {
+			case "\\N":
+				builder.WriteString("\n")
+			case "\\n":
+				builder.WriteString(" ")
+			case "\\h":
+				builder.WriteString("\u00a0")
+			default:
+				builder.WriteString(escapeText(escape))
+			}
+			continue
+		}
+
+		if !drawing {
+			apply()
+			builder.WriteString(escapeText(text[:next]))
+		}
+		text = text[next:]
+	}
+
+	wanted = assStyle{}
+	apply()
+
+	return builder.String(), alignment
+}
+
+func isNumber(value string) bool {
+	_, err := strconv.Atoi(value)
+	return err == nil
+}
@@ -0,0 +1,184 @@
+package subtitle
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseSRT reads the cues of a SubRip file. The counters are ignored, so files
+// with missing or repeated ones still work.
+func ParseSRT(text string) []Cue {
+	var cues []Cue
+	var current *Cue
+	var lines []string
+
+	flush := func() {
+		if current != nil {
+			cues = append(cues, TextCue(current.Start, current.End, strings.Join(lines, "\n")))
+		}
+
+		current = nil
+		lines = nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if start, end, ok := parseSRTTiming(line); ok {
+			flush()
+			current = &Cue{Start: start, End: end}
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+	flush()
+
+	return cues
+}
+
+// TextCue converts the text of a cue in the SubRip markup, which is also what
+// the plain text subtitle tracks of Matroska use.
+func TextCue(start time.Duration, end time.Duration, text string) Cue {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	markup, settings := srtText(text)
+	return Cue{Start: start, End: end, Text: markup, Settings: settings}
+}
+
+// parseSRTTiming reads a "00:01:02,500 --> 00:01:04,000" line, which may be
+// followed by coordinates.
+func parseSRTTiming(line string) (time.Duration, time.Duration, bool) {
+	left, right, found := strings.Cut(line, "-->")
+	if !found {
+		return 0, 0, false
+	}
+
+	fields := strings.Fields(right)
+	if len(fields) == 0 {
+		return 0, 0, false
+	}
+
+	start, ok := parseClock(strings.TrimSpace(left))
+	if !ok {
+		return 0, 0, false
+	}
+
+	end, ok := parseClock(fields[0])
+	return start, end, ok
+}
+
+// parseClock reads times like "01:02:03,450", "1:02:03.45" or "02:03.450",
+// the fraction being in seconds.
+func parseClock(value string) (time.Duration, bool) {
+	value = strings.ReplaceAll(value, ",", ".")
+	whole, fraction, _ := strings.Cut(value, ".")
+
+	parts := strings.Split(whole, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+
+	var total time.Duration
+	for _, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return 0, false
+		}
+
+		total = total*60 + time.Duration(number)*time.Second
+	}
+
+	if fraction != "" {
+		digits, err := strconv.Atoi(fraction)
+		if err != nil || digits < 0 {
+			return 0, false
+		}
+
+		scale := time.Second
+		for range fraction {
+			scale /= 10
+		}
+		total += time.Duration(digits) * scale
+	}
+
+	return total, true
+}
+
+// srtText converts the text of a cue to WebVTT markup. The b, i and u tags are
+// kept, font tags are dropped, and the {\anN} alignment that some files borrow
+// from ASS becomes the cue position.
+func srtText(text string) (string, string) {
+	var builder strings.Builder
+	var settings string
+
+	for len(text) > 0 {
+		switch {
+		case strings.HasPrefix(text, "<"):
+			// a lone "<", like in "a < b", isn't the start of a tag
+			end := strings.IndexByte(text, '>')
+			if end < 0 || !isTag(text[1:end]) {
+				builder.WriteString("&lt;")
+				text = text[1:]
+				continue
+			}
+
+			var tag string
+			if fields := strings.Fields(strings.ToLower(text[1:end])); len(fields) > 0 {
+				tag = fields[0]
+			}
+
+			switch strings.TrimPrefix(tag, "/") {
+			case "b", "i", "u":
+				builder.WriteString("<" + tag + ">")
+			case "font", "s":
+			default:
+				builder.WriteString(escapeText(text[:end+1]))
+			}
+			text = text[end+1:]
+		case strings.HasPrefix(text, "{\\"):
+			end := strings.IndexByte(text, '}')
+			if end < 0 {
+				builder.WriteString(escapeText(text))
+				text = ""
+				continue
+			}
+
+			if alignment, found := overrideAlignment(text[1:end]); found {
+				settings = alignmentSettings(alignment)
+			}
+			text = text[end+1:]
+		default:
+			next := strings.IndexAny(text[1:], "<{")
+			if next < 0 {
+				next = len(text)
+			} else {
+				next++
+			}
+
+			builder.WriteString(escapeText(text[:next]))
+			text = text[next:]
+		}
+	}
+
+	return builder.String(), settings
+}
+
+// isTag tells if the text between "<" and ">" is a tag, starting with its name
+// right away.
+func isTag(inner string) bool {
+	inner = strings.TrimPrefix(inner, "/")
+	if inner == "" || strings.Contains(inner, "<") {
+		return false
+	}
+
+	first := inner[0] | 0x20
+	return first >= 'a' && first <= 'z'
+}
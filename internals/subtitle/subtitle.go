@@ -0,0 +1,182 @@
+// Package subtitle converts SRT and ASS/SSA subtitles to WebVTT, the only
+// format browsers show on their own. Timings are kept as they are, and the
+// bold, italic and underline styling is carried over to WebVTT tags.
+package subtitle
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+type Format string
+
+const (
+	FormatSRT Format = "srt"
+	FormatASS Format = "ass"
+	FormatSSA Format = "ssa"
+	FormatVTT Format = "vtt"
+)
+
+var ErrUnknownFormat = errors.New("unknown subtitle format")
+
+// Cue is a subtitle shown between two times. The text is already WebVTT
+// markup, and the settings position the cue, like "line:0" for the top.
+type Cue struct {
+	Start    time.Duration
+	End      time.Duration
+	Text     string
+	Settings string
+}
+
+// ParseFormat returns the format of a subtitle file extension, with or
+// without its dot.
+func ParseFormat(extension string) (Format, bool) {
+	switch format := Format(strings.ToLower(strings.TrimPrefix(extension, "."))); format {
+	case FormatSRT, FormatASS, FormatSSA, FormatVTT:
+		return format, true
+	default:
+		return "", false
+	}
+}
+
+// ToVTT converts the subtitles read from r to WebVTT. WebVTT files are copied
+// as they are, once decoded to UTF-8.
+func ToVTT(w io.Writer, r io.Reader, format Format) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	text := decodeText(data)
+
+	var cues []Cue
+	switch format {
+	case FormatSRT:
+		cues = ParseSRT(text)
+	case FormatASS, FormatSSA:
+		cues = ParseASS(text)
+	case FormatVTT:
+		_, err = io.WriteString(w, text)
+		return err
+	default:
+		return ErrUnknownFormat
+	}
+
+	return WriteVTT(w, cues)
+}
+
+// WriteVTT writes the cues as a WebVTT file, in the order they start.
+func WriteVTT(w io.Writer, cues []Cue) error {
+	cues = slices.Clone(cues)
+	slices.SortStableFunc(cues, func(a, b Cue) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+
+	buf := bufio.NewWriter(w)
+	buf.WriteString("WEBVTT\n")
+
+	for _, cue := range cues {
+		text := cleanCueText(cue.Text)
+		if text == "" || cue.End <= cue.Start {
+			continue
+		}
+
+		fmt.Fprintf(buf, "\n%v --> %v", vttTime(cue.Start), vttTime(cue.End))
+		if cue.Settings != "" {
+			buf.WriteString(" " + cue.Settings)
+		}
+		buf.WriteString("\n" + text + "\n")
+	}
+
+	return buf.Flush()
+}
+
+func vttTime(value time.Duration) string {
+	millis := value.Milliseconds()
+	return fmt.Sprintf(
+		"%02d:%02d:%02d.%03d",
+		millis/3600000,
+		millis/60000%60,
+		millis/1000%60,
+		millis%1000,
+	)
+}
+
+// cleanCueText drops the blank lines, which would end the cue early.
+func cleanCueText(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, strings.TrimRight(line, " \t"))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// escapeText escapes the characters that WebVTT reads as markup.
+func escapeText(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// decodeText reads UTF-16 files with a byte order mark, and falls back to
+// Windows-1252 for files that aren't valid UTF-8, which is what most old
+// subtitles use. Line endings become "\n".
+func decodeText(data []byte) string {
+	var text string
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		text = string(data[3:])
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}), bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		text = decodeUTF16(data[2:], data[0] == 0xFE)
+	case utf8.Valid(data):
+		text = string(data)
+	default:
+		text = decodeWindows1252(data)
+	}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.ReplaceAll(text, "\r", "\n")
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+
+	return string(utf16.Decode(units))
+}
+
+// windows1252 maps the bytes 0x80 to 0x9F, the others are the same as Latin-1.
+var windows1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\u008D', 'Ž', '\u008F',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\u009D', 'ž', 'Ÿ',
+}
+
+func decodeWindows1252(data []byte) string {
+	var builder strings.Builder
+	for _, b := range data {
+		if b >= 0x80 && b < 0xA0 {
+			builder.WriteRune(windows1252[b-0x80])
+		} else {
+			builder.WriteRune(rune(b))
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,247 @@
+package subtitle
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{value: "01:02:03,450", want: time.Hour + 2*time.Minute + 3*time.Second + 450*time.Millisecond, ok: true},
+		{value: "1:02:03.45", want: time.Hour + 2*time.Minute + 3*time.Second + 450*time.Millisecond, ok: true},
+		{value: "02:03.450", want: 2*time.Minute + 3*time.Second + 450*time.Millisecond, ok: true},
+		{value: "0:00:01.5", want: 1500 * time.Millisecond, ok: true},
+		{value: "00:00:05", want: 5 * time.Second, ok: true},
+		{value: "05", ok: false},
+		{value: "1:2:3:4", ok: false},
+		{value: "aa:00:01", ok: false},
+		{value: "00:-1:00", ok: false},
+		{value: "00:00:01,x", ok: false},
+	}
+
+	for _, test := range tests {
+		got, ok := parseClock(test.value)
+		if ok != test.ok || (ok && got != test.want) {
+			t.Errorf("parseClock(%q) = %v, %v, want %v, %v", test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestToVTT(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		input  string
+		want   string
+	}{
+		{
+			name:   "srt",
+			format: FormatSRT,
+			input:  "1\r\n00:00:01,000 --> 00:00:02,500\r\nHello\r\nworld\r\n\r\n2\r\n00:00:03,000 --> 00:00:04,000\r\nBye\r\n",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\nHello\nworld\n\n00:00:03.000 --> 00:00:04.000\nBye\n",
+		},
+		{
+			name:   "srt without counters and with coordinates",
+			format: FormatSRT,
+			input:  "00:00:01,000 --> 00:00:02,000 X1:10 X2:20\nFirst\n\n\n00:00:03,000 --> 00:00:04,000\nSecond",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nFirst\n\n00:00:03.000 --> 00:00:04.000\nSecond\n",
+		},
+		{
+			name:   "srt sorted by start",
+			format: FormatSRT,
+			input:  "2\n00:00:05,000 --> 00:00:06,000\nLater\n\n1\n00:00:01,000 --> 00:00:02,000\nSooner\n",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nSooner\n\n00:00:05.000 --> 00:00:06.000\nLater\n",
+		},
+		{
+			name:   "srt tags",
+			format: FormatSRT,
+			input:  "1\n00:00:01,000 --> 00:00:02,000\n<b>Bold</b> <I>it</I> <font color=\"red\">red</font> a < b & <c>\n",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\n<b>Bold</b> <i>it</i> red a &lt; b &amp; &lt;c&gt;\n",
+		},
+		{
+			name:   "srt ass alignment",
+			format: FormatSRT,
+			input:  "1\n00:00:01,000 --> 00:00:02,000\n{\\an8}Top\n",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000 line:0\nTop\n",
+		},
+		{
+			name:   "srt empty and backwards cues",
+			format: FormatSRT,
+			input:  "1\n00:00:01,000 --> 00:00:02,000\n<i></i>\n\n2\n00:00:04,000 --> 00:00:03,000\nBackwards\n",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\n<i></i>\n",
+		},
+		{
+			name:   "ass styles and format",
+			format: FormatASS,
+			input: strings.Join([]string{
+				"[Script Info]",
+				"ScriptType: v4.00+",
+				"",
+				"[V4+ Styles]",
+				"Format: Name, Fontname, Bold, Italic, Underline, Alignment",
+				"Style: Default,Arial,0,0,0,2",
+				"Style: Sign,Arial,-1,0,0,8",
+				"",
+				"[Events]",
+				"Format: Layer, Start, End, Style, Name, Text",
+				"Dialogue: 0,0:00:01.00,0:00:02.50,Default,,Hello, world",
+				"Dialogue: 0,0:00:03.00,0:00:04.00,Sign,,Sign text",
+				"Comment: 0,0:00:05.00,0:00:06.00,Default,,Hidden",
+			}, "\n"),
+			want: "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\nHello, world\n\n00:00:03.000 --> 00:00:04.000 line:0\n<b>Sign text</b>\n",
+		},
+		{
+			name:   "ass override tags",
+			format: FormatASS,
+			input: strings.Join([]string{
+				"[V4+ Styles]",
+				"Format: Name, Bold, Italic, Underline, Alignment",
+				"Style: Default,0,0,0,2",
+				"[Events]",
+				"Format: Layer, Start, End, Style, Text",
+				`Dialogue: 0,0:00:01.00,0:00:02.00,Default,{\i1}it{\i0} plain{\b700} heavy{\r}\Nline\hspace`,
+				`Dialogue: 0,0:00:03.00,0:00:04.00,Default,{\an7\u1}corner`,
+				`Dialogue: 0,0:00:05.00,0:00:06.00,Default,{\p1}m 0 0 l 10 10{\p0}`,
+				`Dialogue: 0,0:00:07.00,0:00:08.00,Unknown,{\b1\i1}both{\i0} bold & <x>`,
+			}, "\n"),
+			want: "WEBVTT\n\n" +
+				"00:00:01.000 --> 00:00:02.000\n<i>it</i> plain<b> heavy</b>\nline space\n\n" +
+				"00:00:03.000 --> 00:00:04.000 line:0 align:start\n<u>corner</u>\n\n" +
+				"00:00:07.000 --> 00:00:08.000\n<b><i>both</i></b><b> bold &amp; &lt;x&gt;</b>\n",
+		},
+		{
+			name:   "ass default format without a format line",
+			format: FormatASS,
+			input:  "[Events]\nDialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,Text, with commas\n",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nText, with commas\n",
+		},
+		{
+			name:   "ssa legacy alignment",
+			format: FormatSSA,
+			input: strings.Join([]string{
+				"[V4 Styles]",
+				"Format: Name, Bold, Italic, Alignment",
+				"Style: Default,0,-1,6",
+				"Style: Middle,0,0,10",
+				"[Events]",
+				"Format: Marked, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text",
+				"Dialogue: Marked=0,0:00:01.00,0:00:02.00,Default,,0,0,0,,Top",
+				"Dialogue: Marked=0,0:00:03.00,0:00:04.00,*Middle,,0,0,0,,Middle",
+				`Dialogue: Marked=0,0:00:05.00,0:00:06.00,Middle,,0,0,0,,{\a1}Bottom left`,
+			}, "\n"),
+			want: "WEBVTT\n\n" +
+				"00:00:01.000 --> 00:00:02.000 line:0\n<i>Top</i>\n\n" +
+				"00:00:03.000 --> 00:00:04.000 line:50%\nMiddle\n\n" +
+				"00:00:05.000 --> 00:00:06.000 align:start\nBottom left\n",
+		},
+		{
+			name:   "vtt copied",
+			format: FormatVTT,
+			input:  "WEBVTT\r\n\r\n00:01.000 --> 00:02.000\r\nHi\r\n",
+			want:   "WEBVTT\n\n00:01.000 --> 00:02.000\nHi\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var vtt bytes.Buffer
+			if err := ToVTT(&vtt, strings.NewReader(test.input), test.format); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := vtt.String(); got != test.want {
+				t.Errorf("ToVTT\n got %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestToVTTUnknownFormat(t *testing.T) {
+	var vtt bytes.Buffer
+	if err := ToVTT(&vtt, strings.NewReader(""), Format("sub")); err != ErrUnknownFormat {
+		t.Errorf("ToVTT = %v, want %v", err, ErrUnknownFormat)
+	}
+}
+
+func TestBlockCue(t *testing.T) {
+	script := ParseScriptHeader("[V4+ Styles]\nFormat: Name, Bold, Italic, Underline, Alignment\nStyle: Top,0,0,0,8\n")
+
+	tests := []struct {
+		block string
+		want  Cue
+	}{
+		{
+			block: "3,0,Top,,0,0,0,,Hello, world",
+			want:  Cue{Start: time.Second, End: 2 * time.Second, Text: "Hello, world", Settings: "line:0"},
+		},
+		{
+			block: "4,0,Default,,0,0,0,,{\\an3}Right",
+			want:  Cue{Start: time.Second, End: 2 * time.Second, Text: "Right", Settings: "align:end"},
+		},
+		{
+			block: "5,0,Top",
+			want:  Cue{Start: time.Second, End: 2 * time.Second},
+		},
+	}
+
+	for _, test := range tests {
+		if got := script.BlockCue(time.Second, 2*time.Second, test.block); got != test.want {
+			t.Errorf("BlockCue(%q) = %+v, want %+v", test.block, got, test.want)
+		}
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{name: "utf-8", data: []byte("caf\xc3\xa9\r\nok"), want: "café\nok"},
+		{name: "utf-8 bom", data: []byte("\xef\xbb\xbfHi"), want: "Hi"},
+		{name: "utf-16 le", data: []byte{0xFF, 0xFE, 'H', 0, 'i', 0, 0xE9, 0, '\r', 0, '\n', 0}, want: "Hié\n"},
+		{name: "utf-16 be", data: []byte{0xFE, 0xFF, 0, 'H', 0xD8, 0x3D, 0xDE, 0x00}, want: "H😀"},
+		{name: "windows-1252", data: []byte("\x93caf\xe9\x94 \x80\x85\r"), want: "“café” €…\n"},
+		{name: "mac line endings", data: []byte("a\rb"), want: "a\nb"},
+	}
+
+	for _, test := range tests {
+		if got := decodeText(test.data); got != test.want {
+			t.Errorf("decodeText(%v) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAlignment(t *testing.T) {
+	tests := []struct {
+		ssa      int
+		ass      int
+		settings string
+	}{
+		{ssa: 1, ass: 1, settings: "align:start"},
+		{ssa: 2, ass: 2, settings: ""},
+		{ssa: 3, ass: 3, settings: "align:end"},
+		{ssa: 9, ass: 4, settings: "line:50% align:start"},
+		{ssa: 10, ass: 5, settings: "line:50%"},
+		{ssa: 11, ass: 6, settings: "line:50% align:end"},
+		{ssa: 5, ass: 7, settings: "line:0 align:start"},
+		{ssa: 6, ass: 8, settings: "line:0"},
+		{ssa: 7, ass: 9, settings: "line:0 align:end"},
+	}
+
+	for _, test := range tests {
+		if got := legacyAlignment(test.ssa); got != test.ass {
+			t.Errorf("legacyAlignment(%v) = %v, want %v", test.ssa, got, test.ass)
+		}
+
+		if got := alignmentSettings(test.ass); got != test.settings {
+			t.Errorf("alignmentSettings(%v) = %q, want %q", test.ass, got, test.settings)
+		}
+	}
+}
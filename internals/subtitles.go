@@ -0,0 +1,383 @@
+package internals
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"go-video-viewer/internals/probe"
+	"go-video-viewer/internals/subtitle"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrSubtitleNotFound = errors.New("subtitle not found")
+
+// SubtitleTrack is a subtitle the browser can load as WebVTT from its url. The
+// index of sidecar files is their position in the list, embedded tracks use
+// their track number. Image based tracks are listed without a url.
+type SubtitleTrack struct {
+	Index     int             `json:"index"`
+	Embedded  bool            `json:"embedded"`
+	Filename  string          `json:"filename,omitempty"`
+	Format    subtitle.Format `json:"format"`
+	Language  string          `json:"language,omitempty"`
+	Label     string          `json:"label"`
+	Forced    bool            `json:"forced"`
+	Supported bool            `json:"supported"`
+	Url       string          `json:"url,omitempty"`
+}
+
+type SubtitleListResponse struct {
+	Subtitles []SubtitleTrack `json:"subtitles"`
+}
+
+// subtitleLanguage matches the language codes found in sidecar filenames, like
+// "en", "jpn" or "pt-BR".
+var subtitleLanguage = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})?$`)
+
+// subtitleFlags are the words of sidecar filenames that aren't languages.
+var subtitleFlags = []string{"forced", "sdh", "cc", "hi", "default", "full", "signs"}
+
+// textSubtitleCodecs are the Matroska subtitle codecs that can be converted to
+// WebVTT, the others are images.
+var textSubtitleCodecs = []string{"S_TEXT/UTF8", "S_TEXT/ASS", "S_TEXT/SSA", "S_ASS", "S_SSA", "S_TEXT/WEBVTT"}
+
+// embeddedPrefix comes before the track number in the url of embedded tracks.
+const embeddedPrefix = "track"
+
+// embeddedFallbackDuration is how long a cue without a duration lasts, unless
+// the next one starts earlier.
+const embeddedFallbackDuration = 5 * time.Second
+
+// VideoSubtitles lists the sidecar subtitles of the video, the files next to it
+// named after it, like "Show - 05.en.srt" for "Show - 05.mkv", followed by the
+// subtitle tracks of the video itself.
+func (app App) VideoSubtitles(video Video) ([]SubtitleTrack, error) {
+	videoPath, err := app.VideoPath(video)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(videoPath))
+	if err != nil {
+		return nil, err
+	}
+
+	basename := strings.TrimSuffix(path.Base(video.Filename), path.Ext(video.Filename))
+	tracks := []SubtitleTrack{}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		format, ok := subtitle.ParseFormat(filepath.Ext(name))
+		if !ok || len(name) <= len(basename) || !strings.EqualFold(name[:len(basename)], basename) {
+			continue
+		}
+
+		// the basename must be followed by the extension or by a dot
+		tags := strings.TrimSuffix(name[len(basename):], filepath.Ext(name))
+		if tags != "" && !strings.HasPrefix(tags, ".") {
+			continue
+		}
+
+		track := SubtitleTrack{Index: len(tracks), Filename: name, Format: format, Supported: true}
+		readSubtitleTags(&track, tags)
+		track.Url = fmt.Sprintf("/api/video/%v/subtitles/%v.vtt", video.Id, track.Index)
+
+		tracks = append(tracks, track)
+	}
+
+	// the sidecar files are still listed while the video file is missing
+	media, err := app.VideoMedia(video)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if media == nil {
+		return tracks, nil
+	}
+
+	extractable := media.Container == "matroska" || media.Container == "webm"
+	for _, track := range media.Tracks {
+		if track.Type != probe.TrackSubtitle {
+			continue
+		}
+
+		embedded := SubtitleTrack{
+			Index:     track.Number,
+			Embedded:  true,
+			Format:    subtitle.Format(track.Codec),
+			Language:  track.Language,
+			Label:     track.Name,
+			Forced:    track.Forced,
+			Supported: extractable && slices.Contains(textSubtitleCodecs, track.CodecId),
+		}
+
+		if embedded.Label == "" {
+			embedded.Label = embedded.Language
+		}
+
+		if embedded.Label == "" {
+			embedded.Label = fmt.Sprintf("Track %v", track.Number)
+		}
+
+		if embedded.Supported {
+			embedded.Url = fmt.Sprintf("/api/video/%v/subtitles/%v%v.vtt", video.Id, embeddedPrefix, track.Number)
+		}
+
+		tracks = append(tracks, embedded)
+	}
+
+	return tracks, nil
+}
+
+// readSubtitleTags reads the language and flags between the basename and the
+// extension, anything else goes in the label.
+func readSubtitleTags(track *SubtitleTrack, tags string) {
+	var label []string
+
+	for _, tag := range strings.Split(tags, ".") {
+		if tag == "" {
+			continue
+		}
+
+		lower := strings.ToLower(tag)
+		switch {
+		case lower == "forced":
+			track.Forced = true
+		case track.Language == "" && subtitleLanguage.MatchString(tag) && !slices.Contains(subtitleFlags, lower):
+			track.Language = tag
+			continue
+		}
+
+		label = append(label, tag)
+	}
+
+	track.Label = strings.Join(label, " ")
+	switch {
+	case track.Label == "" && track.Language != "":
+		track.Label = track.Language
+	case track.Label == "":
+		track.Label = fmt.Sprintf("Subtitle %v", track.Index+1)
+	case track.Language != "":
+		track.Label = track.Language + " " + track.Label
+	}
+}
+
+// SubtitleVTT returns the subtitle converted to WebVTT. The name is the index of
+// a sidecar file, or the track number of an embedded track after its prefix.
+// Extracting an embedded track stops when the context ends.
+func (app App) SubtitleVTT(ctx context.Context, video Video, name string) ([]byte, error) {
+	tracks, err := app.VideoSubtitles(video)
+	if err != nil {
+		return nil, err
+	}
+
+	embedded := strings.HasPrefix(name, embeddedPrefix)
+	index, err := strconv.Atoi(strings.TrimPrefix(name, embeddedPrefix))
+	if err != nil {
+		return nil, ErrSubtitleNotFound
+	}
+
+	found := slices.IndexFunc(tracks, func(track SubtitleTrack) bool {
+		return track.Embedded == embedded && track.Index == index && track.Supported
+	})
+	if found < 0 {
+		return nil, ErrSubtitleNotFound
+	}
+
+	if embedded {
+		return app.embeddedSubtitle(ctx, video, index)
+	}
+
+	library, err := app.Repo.FindLibrary(video.LibraryId)
+	if err != nil {
+		return nil, err
+	}
+
+	subtitlePath, err := resolveInFolder(library.VideoFolder, path.Join(path.Dir(video.Filename), tracks[found].Filename))
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(subtitlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var vtt bytes.Buffer
+	if err = subtitle.ToVTT(&vtt, file, tracks[found].Format); err != nil {
+		return nil, err
+	}
+
+	return vtt.Bytes(), nil
+}
+
+// embeddedSubtitle returns an embedded track from the cache. On a miss every
+// text track is extracted at once, since they are spread over the whole file.
+// The cache is keyed by the size and modification time of the file, so
+// replaced files are extracted again.
+func (app App) embeddedSubtitle(ctx context.Context, video Video, number int) ([]byte, error) {
+	videoPath, err := app.VideoPath(video)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(videoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	folder := filepath.Join(app.Config.CacheFolder, "subtitles")
+	key := cacheKey(video.Id, stat)
+	cachePath := func(track int) string {
+		return filepath.Join(folder, fmt.Sprintf("%v-%v%v.vtt", key, embeddedPrefix, track))
+	}
+
+	if vtt, err := os.ReadFile(cachePath(number)); err == nil {
+		return vtt, nil
+	}
+
+	unlock := app.extractions.Lock(video.Id)
+	defer unlock()
+
+	// another request may have extracted it while this one waited
+	if vtt, err := os.ReadFile(cachePath(number)); err == nil {
+		return vtt, nil
+	}
+
+	extracted, err := extractSubtitles(ctx, videoPath, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(folder, 0755); err != nil {
+		return nil, err
+	}
+
+	removeStaleCache(folder, video.Id, key)
+
+	for track, vtt := range extracted {
+		if err = writeFileAtomic(cachePath(track), vtt); err != nil {
+			return nil, err
+		}
+	}
+
+	vtt, found := extracted[number]
+	if !found {
+		return nil, ErrSubtitleNotFound
+	}
+
+	return vtt, nil
+}
+
+// extractSubtitles reads the text subtitle tracks of a Matroska file, skipping
+// the blocks of the other tracks. The subtitle blocks are sparse, so the reads
+// of the file check the context too, not only the blocks.
+func extractSubtitles(ctx context.Context, videoPath string, size int64) (map[int][]byte, error) {
+	file, err := os.Open(videoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	demuxer, err := probe.OpenMatroska(limitedReader{ReadSeeker: file, limiter: newReadLimiter(ctx, 0)}, size)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[int]probe.TrackEntry{}
+	scripts := map[int]subtitle.Script{}
+	cues := map[int][]subtitle.Cue{}
+
+	var selected []int
+	for _, entry := range demuxer.Tracks() {
+		if entry.Type != probe.TrackSubtitle || !slices.Contains(textSubtitleCodecs, entry.CodecId) {
+			continue
+		}
+
+		entries[entry.Number] = entry
+		scripts[entry.Number] = subtitle.ParseScriptHeader(string(entry.CodecPrivate))
+		cues[entry.Number] = []subtitle.Cue{}
+		selected = append(selected, entry.Number)
+	}
+	demuxer.Select(selected...)
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		block, err := demuxer.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry := entries[block.Track]
+		for _, frame := range block.Frames {
+			end := block.Time + block.Duration
+
+			var cue subtitle.Cue
+			switch entry.CodecId {
+			case "S_TEXT/UTF8":
+				cue = subtitle.TextCue(block.Time, end, string(frame))
+			case "S_TEXT/WEBVTT":
+				cue = subtitle.Cue{Start: block.Time, End: end, Text: string(frame)}
+			default:
+				cue = scripts[block.Track].BlockCue(block.Time, end, string(frame))
+			}
+
+			cues[block.Track] = append(cues[block.Track], cue)
+		}
+	}
+
+	extracted := make(map[int][]byte, len(cues))
+	for track, trackCues := range cues {
+		fillCueEnds(trackCues)
+
+		var vtt bytes.Buffer
+		if err = subtitle.WriteVTT(&vtt, trackCues); err != nil {
+			return nil, err
+		}
+
+		extracted[track] = vtt.Bytes()
+	}
+
+	return extracted, nil
+}
+
+// fillCueEnds ends the cues without a duration when the next one starts, or
+// after a fallback duration.
+func fillCueEnds(cues []subtitle.Cue) {
+	slices.SortStableFunc(cues, func(a, b subtitle.Cue) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+
+	for i := range cues {
+		if cues[i].End > cues[i].Start {
+			continue
+		}
+
+		cues[i].End = cues[i].Start + embeddedFallbackDuration
+		if i+1 < len(cues) && cues[i+1].Start > cues[i].Start {
+			cues[i].End = min(cues[i].End, cues[i+1].Start)
+		}
+	}
+}
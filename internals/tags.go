@@ -0,0 +1,217 @@
+package internals
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var (
+	ErrTagNotFound  = errors.New("tag not found")
+	ErrTagNameTaken = errors.New("a tag with this name already exists")
+)
+
+type Tag struct {
+	Id    int32  `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type TagListResponse struct {
+	Tags []Tag `json:"tags"`
+}
+
+type TagRenamePayload struct {
+	Name string `json:"name"`
+}
+
+type TagMergePayload struct {
+	Into int32 `json:"into"`
+}
+
+func (repo VideoRepository) ListTags() ([]Tag, error) {
+	rows, err := repo.db.Query(
+		`
+		select
+			tags.id,
+			tags.name,
+			count(video_tags.video_id) as usage
+		from
+			tags
+			left join video_tags on video_tags.tag_id = tags.id
+		group by
+			tags.id
+		order by
+			usage desc,
+			tags.name
+		`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err = rows.Scan(&tag.Id, &tag.Name, &tag.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// RenameTag changes the name of a tag on every video that uses it, recording a
+// tag event for each of them. Renaming to the name of another existing tag
+// fails with ErrTagNameTaken, MergeTags should be used instead.
+func (repo VideoRepository) RenameTag(id int32, name string) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = ensureTagExists(tx, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var existing int32
+	err = tx.QueryRow("select id from tags where name = ?", name).Scan(&existing)
+	if err == nil && existing != id {
+		tx.Rollback()
+		return ErrTagNameTaken
+	}
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return err
+	}
+
+	before, err := videoTagLists(tx, "id in (select video_id from video_tags where tag_id = ?)", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("update tags set name = ? where id = ?", name, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = recordTagChanges(tx, before); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = reindexVideos(tx, "id in (select video_id from video_tags where tag_id = ?)", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MergeTags moves every video tagged with "from" to the "into" tag and removes
+// the "from" tag, recording a tag event for each moved video.
+func (repo VideoRepository) MergeTags(from int32, into int32) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range []int32{from, into} {
+		if err = ensureTagExists(tx, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if from == into {
+		return tx.Commit()
+	}
+
+	before, err := videoTagLists(tx, "id in (select video_id from video_tags where tag_id = ?)", from)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(
+		`
+		insert into video_tags (video_id, tag_id)
+		select video_id, ? from video_tags where tag_id = ?
+		on conflict do nothing
+		`,
+		into,
+		from,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("delete from video_tags where tag_id = ?", from); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("delete from tags where id = ?", from); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = recordTagChanges(tx, before); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = reindexVideos(tx, "id in (select video_id from video_tags where tag_id = ?)", into)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func ensureTagExists(tx *sql.Tx, id int32) error {
+	var found int32
+	err := tx.QueryRow("select id from tags where id = ?", id).Scan(&found)
+	if err == sql.ErrNoRows {
+		return ErrTagNotFound
+	}
+
+	return err
+}
+
+// replaceVideoTags sets the tags of a video, creating the missing ones and
+// dropping the tags no video uses anymore.
+func replaceVideoTags(tx *sql.Tx, videoId int32, tags []string) error {
+	if _, err := tx.Exec("delete from video_tags where video_id = ?", videoId); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		_, err := tx.Exec("insert into tags (name) values (?) on conflict (name) do nothing", tag)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			`
+			insert into video_tags (video_id, tag_id)
+			select ?, id from tags where name = ?
+			on conflict do nothing
+			`,
+			videoId,
+			tag,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec("delete from tags where id not in (select tag_id from video_tags)")
+	return err
+}
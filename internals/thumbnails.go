@@ -0,0 +1,317 @@
+package internals
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-video-viewer/internals/probe"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// thumbnailJobKey keeps a single thumbnail generation running.
+const thumbnailJobKey = "thumbnails"
+
+// thumbnailTimeout is how long ffmpeg has to extract a frame.
+const thumbnailTimeout = 30 * time.Second
+
+// thumbnailWidth is the width of the frames taken by ffmpeg, the height follows
+// the aspect ratio of the video.
+const thumbnailWidth = 480
+
+const (
+	placeholderWidth  = 320
+	placeholderHeight = 180
+)
+
+// errNoThumbnail means neither ffmpeg nor the cover art gave a thumbnail.
+var errNoThumbnail = errors.New("the video has no thumbnail")
+
+// errNoFrame means ffmpeg ran without error but didn't output a frame.
+var errNoFrame = errors.New("ffmpeg didn't output a frame")
+
+type ThumbnailProgress struct {
+	Done     int    `json:"done"`
+	Total    int    `json:"total"`
+	Filename string `json:"filename"`
+}
+
+type ThumbnailResult struct {
+	Generated int `json:"generated"`
+}
+
+// VideoThumbnail returns the JPEG thumbnail of the video along with the time
+// it was last modified. It's a frame taken by ffmpeg when configured, else the
+// cover art attached to the file. Both are cached, keyed by the size and
+// modification time of the file. Videos without either get a placeholder, the
+// failure is only cached when ffmpeg ran and found no frame, so timeouts are
+// tried again and videos get a thumbnail once ffmpeg is configured.
+func (app App) VideoThumbnail(ctx context.Context, video Video) ([]byte, time.Time, error) {
+	videoPath, err := app.VideoPath(video)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	stat, err := os.Stat(videoPath)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && stat.Size() == 0) {
+		// nothing can be read from missing and truncated files
+		data, err := placeholderThumbnail(video.Filename)
+		return data, time.Time{}, err
+	}
+
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	folder := filepath.Join(app.Config.CacheFolder, "thumbnails")
+	key := cacheKey(video.Id, stat)
+	cachePath := filepath.Join(folder, key+".jpg")
+
+	if data, err := os.ReadFile(cachePath); err == nil {
+		return data, stat.ModTime(), nil
+	}
+
+	unlock := app.thumbnails.Lock(video.Id)
+	defer unlock()
+
+	// another request may have generated it while this one waited
+	if data, err := os.ReadFile(cachePath); err == nil {
+		return data, stat.ModTime(), nil
+	}
+
+	if readCacheFailure(folder, key) != nil {
+		data, err := placeholderThumbnail(video.Filename)
+		return data, time.Time{}, err
+	}
+
+	if err = os.MkdirAll(folder, 0755); err != nil {
+		return nil, time.Time{}, err
+	}
+	removeStaleCache(folder, video.Id, key)
+
+	data, err := app.renderThumbnail(ctx, video, videoPath, stat.Size())
+	if errors.Is(err, errNoThumbnail) {
+		if errors.Is(err, errNoFrame) {
+			if failure := writeCacheFailure(folder, key, err); failure != nil {
+				log.Printf("Failed to remember the thumbnail failure of '%v': %v", video.Filename, failure)
+			}
+		}
+
+		data, err = placeholderThumbnail(video.Filename)
+		return data, time.Time{}, err
+	}
+
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if err = writeFileAtomic(cachePath, data); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return data, stat.ModTime(), nil
+}
+
+// StartThumbnails generates the thumbnails of the videos that don't have one
+// cached yet in the background.
+func (app App) StartThumbnails() (Job, bool) {
+	return app.Jobs.Start("thumbnails", thumbnailJobKey, func(ctx context.Context, progress func(any)) (any, error) {
+		videos, err := app.Repo.VideosWithFiles()
+		if err != nil {
+			return nil, err
+		}
+
+		var result ThumbnailResult
+		for i, video := range videos {
+			if err = ctx.Err(); err != nil {
+				return result, err
+			}
+
+			progress(ThumbnailProgress{Done: i, Total: len(videos), Filename: video.Filename})
+
+			_, modified, err := app.VideoThumbnail(ctx, video)
+			if err != nil {
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+
+				// the file may be gone by now, the next scan will notice
+				log.Printf("Failed to generate the thumbnail of '%v': %v", video.Filename, err)
+				continue
+			}
+
+			// placeholders don't have a modification time
+			if !modified.IsZero() {
+				result.Generated++
+			}
+		}
+
+		return result, nil
+	})
+}
+
+// renderThumbnail takes a frame of the video with ffmpeg, falling back to the
+// cover art when ffmpeg isn't configured or fails. When there's no cover art
+// either, the error also wraps the one of ffmpeg.
+func (app App) renderThumbnail(ctx context.Context, video Video, videoPath string, size int64) ([]byte, error) {
+	var ffmpegErr error
+	if app.Config.FFmpeg != "" {
+		data, err := app.ffmpegThumbnail(ctx, video, videoPath)
+		if err == nil {
+			return data, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		log.Printf("ffmpeg failed to take a frame of '%v': %v", video.Filename, err)
+		ffmpegErr = err
+	}
+
+	data, err := coverArtThumbnail(videoPath, size)
+	if err != nil && ffmpegErr != nil {
+		return nil, errors.Join(err, ffmpegErr)
+	}
+
+	return data, err
+}
+
+// ffmpegThumbnail takes the frame at a tenth of the video, which is usually
+// past the black frames of its start. Short or broken videos whose frame
+// can't be found there are taken from the start.
+func (app App) ffmpegThumbnail(ctx context.Context, video Video, videoPath string) ([]byte, error) {
+	position := 10.0
+	if media, err := app.VideoMedia(video); err == nil && media != nil && media.Duration > 0 {
+		position = media.Duration / 10
+	}
+
+	data, err := ffmpegFrame(ctx, app.Config.FFmpeg, videoPath, position)
+	if err == nil && len(data) == 0 && position > 0 {
+		data, err = ffmpegFrame(ctx, app.Config.FFmpeg, videoPath, 0)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, errNoFrame
+	}
+
+	return data, nil
+}
+
+// ffmpegFrame runs ffmpeg to get the frame at the position in seconds as a
+// JPEG. The output is empty when the position is past the end.
+func ffmpegFrame(ctx context.Context, ffmpeg string, videoPath string, position float64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, thumbnailTimeout)
+	defer cancel()
+
+	command := exec.CommandContext(
+		ctx,
+		ffmpeg,
+		"-hide_banner",
+		"-loglevel", "error",
+		"-ss", fmt.Sprintf("%.3f", position),
+		"-i", videoPath,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%v:-2", thumbnailWidth),
+		"-f", "image2pipe",
+		"-c:v", "mjpeg",
+		"-q:v", "4",
+		"-",
+	)
+
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	if err := command.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%w: %v", err, message)
+		}
+
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// coverArtThumbnail returns the cover art attached to a Matroska file, images
+// that aren't JPEG are converted.
+func coverArtThumbnail(videoPath string, size int64) ([]byte, error) {
+	file, err := os.Open(videoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, mimeType, err := probe.CoverArt(file, size)
+	if err != nil {
+		// other containers and unreadable files have no cover art either
+		return nil, errNoThumbnail
+	}
+
+	if mimeType == "image/jpeg" || mimeType == "image/jpg" {
+		return data, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errNoThumbnail
+	}
+
+	return encodeJpeg(img)
+}
+
+// placeholderThumbnail draws a play sign over a colour picked from the
+// filename, so videos without thumbnails are still told apart.
+func placeholderThumbnail(filename string) ([]byte, error) {
+	hash := fnv.New32a()
+	hash.Write([]byte(filename))
+	sum := hash.Sum32()
+
+	background := color.RGBA{
+		R: 40 + uint8(sum%96),
+		G: 40 + uint8(sum>>8%96),
+		B: 40 + uint8(sum>>16%96),
+		A: 255,
+	}
+	foreground := color.RGBA{R: 235, G: 235, B: 235, A: 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, placeholderWidth, placeholderHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: background}, image.Point{}, draw.Src)
+
+	// the triangle points right, its widest column is on the left
+	half := placeholderHeight / 6
+	left := placeholderWidth/2 - half*2/3
+	for dy := -half; dy <= half; dy++ {
+		width := 2 * (half - max(dy, -dy))
+		for dx := 0; dx < width; dx++ {
+			img.Set(left+dx, placeholderHeight/2+dy, foreground)
+		}
+	}
+
+	return encodeJpeg(img)
+}
+
+func encodeJpeg(img image.Image) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
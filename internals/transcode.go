@@ -0,0 +1,396 @@
+package internals
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTranscodeDisabled = errors.New("transcoding needs the ffmpeg config")
+	ErrTooManyTranscodes = errors.New("too many videos are being transcoded")
+	ErrSegmentNotFound   = errors.New("segment not found")
+)
+
+// transcodeSegment is the duration of the HLS segments in seconds. Keyframes
+// are forced at their boundaries, so a transcode can start at any of them and
+// still line up with the segments of the others.
+const transcodeSegment = 6
+
+// transcodeLookahead is how many segments past the last one written a request
+// waits for, requests further ahead restart ffmpeg where they point.
+const transcodeLookahead = 3
+
+// transcodeWait is how long a request waits for its segment to be written.
+const transcodeWait = time.Minute
+
+// transcodePoll is how often the folder of the session is checked for the
+// segment being waited for.
+const transcodePoll = 100 * time.Millisecond
+
+// ffmpegDuration matches the duration ffmpeg prints while reading a file.
+var ffmpegDuration = regexp.MustCompile(`Duration: (\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+// transcoder keeps the HLS sessions of the videos being played, each one with
+// the folder its segments are written to. The segments stay there until the
+// session is idle for too long, so seeking back doesn't transcode again.
+type transcoder struct {
+	mutex    *sync.Mutex
+	sessions map[int32]*transcodeSession
+}
+
+type transcodeSession struct {
+	key       string
+	folder    string
+	videoPath string
+	duration  float64
+	lastUsed  time.Time
+	// run is the ffmpeg writing the segments, nil until one is requested
+	run *transcodeRun
+}
+
+// transcodeRun is an ffmpeg process writing the segments from start onwards.
+type transcodeRun struct {
+	start  int
+	cancel context.CancelFunc
+	// done is closed once ffmpeg exits, err is set before that
+	done chan struct{}
+	err  error
+}
+
+func newTranscoder() *transcoder {
+	return &transcoder{
+		mutex:    &sync.Mutex{},
+		sessions: map[int32]*transcodeSession{},
+	}
+}
+
+func (run *transcodeRun) running() bool {
+	select {
+	case <-run.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (session *transcodeSession) segments() int {
+	return int(math.Ceil(session.duration / transcodeSegment))
+}
+
+func (session *transcodeSession) segmentPath(index int) string {
+	return filepath.Join(session.folder, fmt.Sprintf("segment%v.ts", index))
+}
+
+// written returns the last segment written in a row from the start of the
+// running ffmpeg.
+func (session *transcodeSession) written() int {
+	last := session.run.start - 1
+	for last+1 < session.segments() {
+		if _, err := os.Stat(session.segmentPath(last + 1)); err != nil {
+			break
+		}
+		last++
+	}
+
+	return last
+}
+
+// TranscodePlaylist returns the HLS playlist of the video. Every segment is
+// listed from the start, they are transcoded once requested.
+func (app App) TranscodePlaylist(ctx context.Context, video Video) ([]byte, error) {
+	session, err := app.transcodeSession(ctx, video)
+	if err != nil {
+		return nil, err
+	}
+
+	var playlist bytes.Buffer
+	playlist.WriteString("#EXTM3U\n")
+	playlist.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&playlist, "#EXT-X-TARGETDURATION:%v\n", transcodeSegment)
+	playlist.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	playlist.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+
+	for i := 0; i < session.segments(); i++ {
+		length := min(transcodeSegment, session.duration-float64(i*transcodeSegment))
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\nsegment%v.ts\n", length, i)
+	}
+
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+	return playlist.Bytes(), nil
+}
+
+// TranscodeSegment returns the path of a segment of the video, waiting for
+// ffmpeg to write it. Segments far from the ones being written restart ffmpeg
+// from there.
+func (app App) TranscodeSegment(ctx context.Context, video Video, index int) (string, error) {
+	session, err := app.transcodeSession(ctx, video)
+	if err != nil {
+		return "", err
+	}
+
+	if index < 0 || index >= session.segments() {
+		return "", ErrSegmentNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, transcodeWait)
+	defer cancel()
+
+	segmentPath := session.segmentPath(index)
+	for {
+		if _, err := os.Stat(segmentPath); err == nil {
+			return segmentPath, nil
+		}
+
+		app.transcodes.mutex.Lock()
+		session.lastUsed = time.Now()
+		run := session.run
+		if run != nil && !run.running() && run.start == index {
+			// ffmpeg was started for this segment and stopped without it
+			app.transcodes.mutex.Unlock()
+			if _, err := os.Stat(segmentPath); err == nil {
+				return segmentPath, nil
+			}
+
+			if run.err != nil {
+				return "", fmt.Errorf("ffmpeg failed to transcode segment %v: %w", index, run.err)
+			}
+			return "", fmt.Errorf("ffmpeg stopped before segment %v", index)
+		}
+
+		var started error
+		if run == nil || !run.running() || index < run.start || index > session.written()+transcodeLookahead {
+			started = app.startTranscode(session, index)
+		}
+		app.transcodes.mutex.Unlock()
+
+		if started != nil {
+			return "", started
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(transcodePoll):
+		}
+	}
+}
+
+// transcodeSession returns the session of the video, starting one when there
+// is none or when its file changed.
+func (app App) transcodeSession(ctx context.Context, video Video) (*transcodeSession, error) {
+	if app.Config.FFmpeg == "" {
+		return nil, ErrTranscodeDisabled
+	}
+
+	videoPath, err := app.VideoPath(video)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(videoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// truncated files have nothing left to play
+	if stat.Size() == 0 {
+		return nil, ErrSegmentNotFound
+	}
+
+	key := cacheKey(video.Id, stat)
+
+	app.transcodes.mutex.Lock()
+	session, found := app.transcodes.sessions[video.Id]
+	if found && session.key == key {
+		session.lastUsed = time.Now()
+		app.transcodes.mutex.Unlock()
+		return session, nil
+	}
+	app.transcodes.mutex.Unlock()
+
+	duration, err := app.transcodeDuration(ctx, video, videoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	folder := filepath.Join(app.Config.CacheFolder, "transcodes", key)
+	if err = os.MkdirAll(folder, 0755); err != nil {
+		return nil, err
+	}
+
+	app.transcodes.mutex.Lock()
+	defer app.transcodes.mutex.Unlock()
+
+	// another request may have started it while this one read the duration
+	if current, found := app.transcodes.sessions[video.Id]; found {
+		if current.key == key {
+			return current, nil
+		}
+
+		stopTranscode(current)
+	}
+
+	session = &transcodeSession{
+		key:       key,
+		folder:    folder,
+		videoPath: videoPath,
+		duration:  duration,
+		lastUsed:  time.Now(),
+	}
+	app.transcodes.sessions[video.Id] = session
+
+	return session, nil
+}
+
+// transcodeDuration reads the duration from the media of the video, or from
+// ffmpeg for the containers the probe doesn't know.
+func (app App) transcodeDuration(ctx context.Context, video Video, videoPath string) (float64, error) {
+	if media, err := app.VideoMedia(video); err == nil && media != nil && media.Duration > 0 {
+		return media.Duration, nil
+	}
+
+	// ffmpeg fails without an output, after printing what it read of the input
+	var stderr bytes.Buffer
+	command := exec.CommandContext(ctx, app.Config.FFmpeg, "-hide_banner", "-i", videoPath)
+	command.Stderr = &stderr
+	command.Run()
+
+	match := ffmpegDuration.FindStringSubmatch(stderr.String())
+	if match == nil {
+		return 0, fmt.Errorf("ffmpeg couldn't read the duration of '%v'", video.Filename)
+	}
+
+	hours, _ := strconv.Atoi(match[1])
+	minutes, _ := strconv.Atoi(match[2])
+	seconds, _ := strconv.ParseFloat(match[3], 64)
+
+	return float64(hours*3600+minutes*60) + seconds, nil
+}
+
+// startTranscode replaces the ffmpeg of the session with one starting at the
+// segment. Only a limited number of sessions transcode at once. It must be
+// called with the mutex of the transcoder held.
+func (app App) startTranscode(session *transcodeSession, index int) error {
+	if session.run == nil || !session.run.running() {
+		running := 0
+		for _, other := range app.transcodes.sessions {
+			if other.run != nil && other.run.running() {
+				running++
+			}
+		}
+
+		if running >= app.Config.TranscodeLimit {
+			return ErrTooManyTranscodes
+		}
+	}
+
+	if session.run != nil {
+		session.run.cancel()
+		<-session.run.done
+	}
+
+	start := strconv.Itoa(index * transcodeSegment)
+	ctx, cancel := context.WithCancel(context.Background())
+	command := exec.CommandContext(
+		ctx,
+		app.Config.FFmpeg,
+		"-hide_banner",
+		"-loglevel", "error",
+		"-ss", start,
+		"-i", session.videoPath,
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-sn", "-dn",
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "23",
+		"-pix_fmt", "yuv420p",
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%v)", transcodeSegment),
+		"-c:a", "aac",
+		"-ac", "2",
+		"-b:a", "160k",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(transcodeSegment),
+		"-hls_list_size", "0",
+		"-hls_flags", "temp_file",
+		"-hls_segment_filename", filepath.Join(session.folder, "segment%d.ts"),
+		"-start_number", strconv.Itoa(index),
+		"-output_ts_offset", start,
+		filepath.Join(session.folder, "ffmpeg.m3u8"),
+	)
+
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+
+	if err := command.Start(); err != nil {
+		cancel()
+		return err
+	}
+
+	run := &transcodeRun{start: index, cancel: cancel, done: make(chan struct{})}
+	session.run = run
+
+	go func() {
+		err := command.Wait()
+		if err != nil && ctx.Err() == nil {
+			if message := strings.TrimSpace(stderr.String()); message != "" {
+				err = fmt.Errorf("%w: %v", err, message)
+			}
+			log.Printf("ffmpeg failed to transcode '%v': %v", session.videoPath, err)
+		}
+
+		run.err = err
+		cancel()
+		close(run.done)
+	}()
+
+	return nil
+}
+
+// stopTranscode stops the ffmpeg of the session and removes its segments. It
+// must be called with the mutex of the transcoder held.
+func stopTranscode(session *transcodeSession) {
+	if session.run != nil {
+		session.run.cancel()
+		<-session.run.done
+	}
+
+	if err := os.RemoveAll(session.folder); err != nil {
+		log.Printf("Failed to remove the segments of '%v': %v", session.videoPath, err)
+	}
+}
+
+// RunTranscodeCleanup stops the sessions that weren't used for the idle time
+// of the config. The segments left by a previous run are removed first, since
+// their sessions are gone.
+func (app App) RunTranscodeCleanup(interval time.Duration) {
+	if err := os.RemoveAll(filepath.Join(app.Config.CacheFolder, "transcodes")); err != nil {
+		log.Println("Failed to remove the old transcodes:", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.transcodes.mutex.Lock()
+		for id, session := range app.transcodes.sessions {
+			if time.Since(session.lastUsed) >= app.Config.TranscodeIdle {
+				stopTranscode(session)
+				delete(app.transcodes.sessions, id)
+			}
+		}
+		app.transcodes.mutex.Unlock()
+	}
+}
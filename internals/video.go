@@ -4,6 +4,9 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"go-video-viewer/internals/probe"
+	"go-video-viewer/internals/release"
+	"net/url"
 	"path/filepath"
 	"slices"
 	"strconv"
@@ -20,6 +23,27 @@ const (
 	VideoSaved
 )
 
+type VideoSortField string
+
+const (
+	SortByCreatedAt VideoSortField = "created_at"
+	SortByFilename  VideoSortField = "filename"
+	SortByNickname  VideoSortField = "nickname"
+	SortById        VideoSortField = "id"
+)
+
+type SortOrder string
+
+const (
+	OrderAsc  SortOrder = "asc"
+	OrderDesc SortOrder = "desc"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
 type NullString sql.NullString
 
 func (ns NullString) MarshalJSON() ([]byte, error) {
@@ -63,12 +87,17 @@ func (ns *NullString) Scan(value any) error {
 }
 
 type Video struct {
-	Id        int32       `json:"id"`
-	Filename  string      `json:"filename"`
-	Nickname  NullString  `json:"nickname"`
-	Tags      []string    `json:"tags"`
-	CreatedAt time.Time   `json:"created_at"`
-	Status    VideoStatus `json:"status"`
+	Id             int32          `json:"id"`
+	LibraryId      int32          `json:"library_id"`
+	Filename       string         `json:"filename"`
+	Nickname       NullString     `json:"nickname"`
+	Tags           []string       `json:"tags"`
+	CreatedAt      time.Time      `json:"created_at"`
+	Status         VideoStatus    `json:"status"`
+	Missing        bool           `json:"missing"`
+	Release        release.Info   `json:"release"`
+	SeriesId       *int32         `json:"series_id"`
+	ChecksumStatus ChecksumStatus `json:"checksum_status"`
 }
 
 type LastUpdateResponse struct {
@@ -82,16 +111,31 @@ type VideoUpdatePayload struct {
 }
 
 type VideoResponse struct {
-	Video Video  `json:"video"`
-	Next  *Video `json:"next"`
+	Video    Video          `json:"video"`
+	Progress *VideoProgress `json:"progress"`
+	Next     *Video         `json:"next"`
+	Media    *probe.Info    `json:"media"`
+	Playback Playback       `json:"playback"`
 }
 
 type VideoListResponse struct {
-	Videos []Video `json:"videos"`
+	Videos   []Video `json:"videos"`
+	Total    int     `json:"total"`
+	Page     int     `json:"page"`
+	PageSize int     `json:"page_size"`
+}
+
+type VideoListQuery struct {
+	LibraryId int32
+	Page      int
+	PageSize  int
+	Sort      VideoSortField
+	Order     SortOrder
 }
 
 type VideoStatsResponse struct {
-	Stats VideoStats `json:"stats"`
+	Stats     VideoStats    `json:"stats"`
+	Checksums ChecksumStats `json:"checksums"`
 }
 
 type VideoJsonEntry struct {
@@ -112,7 +156,10 @@ type VideoJsonFile struct {
 type VideoFsEntry struct {
 	Filename         string
 	LastModifiedTime time.Time
+	Size             int64
+	Fingerprint      NullString
 	IsTruncated      bool
+	Err              error
 }
 
 type VideoStats struct {
@@ -152,6 +199,81 @@ func StatusFromStringValue(value string) (VideoStatus, error) {
 	}
 }
 
+func ParseVideoListQuery(values url.Values) (VideoListQuery, error) {
+	query := VideoListQuery{
+		Page:     1,
+		PageSize: DefaultPageSize,
+		Sort:     SortByCreatedAt,
+		Order:    OrderAsc,
+	}
+
+	if value := values.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return VideoListQuery{}, fmt.Errorf("invalid value for page \"%v\"", value)
+		}
+		query.Page = page
+	}
+
+	if value := values.Get("page_size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 || size > MaxPageSize {
+			return VideoListQuery{}, fmt.Errorf("invalid value for page_size \"%v\", should be in the range [1, %v]", value, MaxPageSize)
+		}
+		query.PageSize = size
+	}
+
+	if value := values.Get("sort"); value != "" {
+		switch sort := VideoSortField(strings.ToLower(value)); sort {
+		case SortByCreatedAt, SortByFilename, SortByNickname, SortById:
+			query.Sort = sort
+		default:
+			return VideoListQuery{}, fmt.Errorf("invalid value for sort \"%v\"", value)
+		}
+	}
+
+	if value := values.Get("order"); value != "" {
+		switch order := SortOrder(strings.ToLower(value)); order {
+		case OrderAsc, OrderDesc:
+			query.Order = order
+		default:
+			return VideoListQuery{}, fmt.Errorf("invalid value for order \"%v\"", value)
+		}
+	}
+
+	return query, nil
+}
+
+// ParseStatusFilter reads the "status" query parameter, which may be repeated
+// or hold comma separated values. Duplicated statuses are ignored.
+func ParseStatusFilter(values url.Values) ([]VideoStatus, error) {
+	var statuses []VideoStatus
+
+	for _, value := range values["status"] {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+
+			status, err := StatusFromStringValue(part)
+			if err != nil {
+				return nil, err
+			}
+
+			if !slices.Contains(statuses, status) {
+				statuses = append(statuses, status)
+			}
+		}
+	}
+
+	return statuses, nil
+}
+
+func (query VideoListQuery) Offset() int {
+	return (query.Page - 1) * query.PageSize
+}
+
 func (status VideoStatus) PersistFile() bool {
 	return status == VideoSaved
 }
@@ -0,0 +1,306 @@
+package internals
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// watchSettleDelay is how long a file has to go without events and without
+// changing size before it is imported, so downloads in progress are left alone.
+const watchSettleDelay = 3 * time.Second
+
+var ErrWatcherUnsupported = errors.New("the filesystem watcher is not supported on this system")
+
+type watchEvent struct {
+	Path     string
+	IsDir    bool
+	Overflow bool
+}
+
+// pendingFile is a file that changed recently and waits to settle.
+type pendingFile struct {
+	library   Library
+	relative  string
+	lastEvent time.Time
+	size      int64
+}
+
+// RunWatcher watches the library folders and imports the videos that show up
+// in them. It only returns when the watcher can't be started or stops.
+func (app App) RunWatcher() {
+	watcher, err := newFolderWatcher()
+	if err != nil {
+		log.Println("Failed to start the filesystem watcher:", err)
+		return
+	}
+
+	pending := map[string]*pendingFile{}
+	for _, library := range app.Repo.Libraries() {
+		app.watchFolder(watcher, pending, library, library.VideoFolder, false)
+	}
+
+	events := make(chan watchEvent, 64)
+	go func() {
+		if err := watcher.Run(events); err != nil {
+			log.Println("The filesystem watcher stopped:", err)
+		}
+
+		close(events)
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			app.handleWatchEvent(watcher, pending, event)
+		case <-ticker.C:
+			app.importSettledFiles(pending)
+		}
+	}
+}
+
+// RunRescans scans every library periodically, catching whatever the watcher
+// missed.
+func (app App) RunRescans(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, started := app.StartScan(nil); !started {
+			log.Println("Skipped the periodic scan, another scan is running")
+		}
+	}
+}
+
+func (app App) handleWatchEvent(watcher *folderWatcher, pending map[string]*pendingFile, event watchEvent) {
+	if event.Overflow {
+		log.Println("The filesystem watcher lost events, scanning every library")
+		app.StartScan(nil)
+
+		return
+	}
+
+	library, relative, found := app.libraryOfPath(event.Path)
+	if !found {
+		return
+	}
+
+	if event.IsDir {
+		if app.Repo.scansFolder(library, event.Path, relative) {
+			app.watchFolder(watcher, pending, library, event.Path, true)
+		}
+
+		return
+	}
+
+	app.markPending(pending, library, event.Path, relative)
+}
+
+// watchFolder adds the folder and the subfolders the library scans to the
+// watcher. Folders created after the start may already have files, those are
+// marked as pending.
+func (app App) watchFolder(watcher *folderWatcher, pending map[string]*pendingFile, library Library, folder string, markFiles bool) {
+	filepath.WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Failed to watch '%v': %v", path, err)
+			return nil
+		}
+
+		relative, err := filepath.Rel(library.VideoFolder, path)
+		if err != nil {
+			return err
+		}
+		relative = filepath.ToSlash(relative)
+
+		if !entry.IsDir() {
+			if markFiles {
+				app.markPending(pending, library, path, relative)
+			}
+
+			return nil
+		}
+
+		if path != library.VideoFolder && !app.Repo.scansFolder(library, path, relative) {
+			return filepath.SkipDir
+		}
+
+		if err = watcher.Add(path); err != nil {
+			log.Printf("Failed to watch '%v': %v", path, err)
+		}
+
+		return nil
+	})
+}
+
+func (app App) markPending(pending map[string]*pendingFile, library Library, path string, relative string) {
+	if !hasVideoExtension(path) {
+		return
+	}
+
+	file, found := pending[path]
+	if !found {
+		file = &pendingFile{library: library, relative: relative, size: -1}
+		pending[path] = file
+	}
+
+	file.lastEvent = time.Now()
+}
+
+// importSettledFiles imports the pending files whose size didn't change since
+// the last check.
+func (app App) importSettledFiles(pending map[string]*pendingFile) {
+	settled := map[int32][]VideoFsEntry{}
+	libraries := map[int32]Library{}
+
+	for path, file := range pending {
+		if time.Since(file.lastEvent) < watchSettleDelay {
+			continue
+		}
+
+		// files that vanished or can't be read are left for the next scan
+		info, err := os.Stat(path)
+		if err == nil {
+			_, err = resolveInFolder(file.library.VideoFolder, file.relative)
+		}
+
+		if err != nil || info.IsDir() {
+			delete(pending, path)
+			continue
+		}
+
+		if info.Size() != file.size {
+			file.size = info.Size()
+			file.lastEvent = time.Now()
+			continue
+		}
+
+		delete(pending, path)
+		libraries[file.library.Id] = file.library
+		settled[file.library.Id] = append(settled[file.library.Id], VideoFsEntry{
+			Filename:         file.relative,
+			LastModifiedTime: info.ModTime(),
+			Size:             info.Size(),
+			IsTruncated:      info.Size() <= 0,
+		})
+	}
+
+	for id, entries := range settled {
+		if err := app.importWatchedFiles(libraries[id], entries); err != nil {
+			log.Printf("Failed to import the files of library \"%v\": %v", libraries[id].Name, err)
+		}
+	}
+}
+
+// importWatchedFiles imports the new files, or renames the video whose file
+// vanished when it has the same size and fingerprint. Changes to known files
+// are left for the scan.
+func (app App) importWatchedFiles(library Library, entries []VideoFsEntry) error {
+	app.scans.Lock()
+	defer app.scans.Unlock()
+
+	stored, err := app.Repo.ListStoredFiles(library.Id)
+	if err != nil {
+		return err
+	}
+
+	known := make(map[string]bool, len(stored))
+	for _, file := range stored {
+		known[file.Filename] = true
+	}
+
+	var changes ScanChanges
+	var imported []VideoFsEntry
+
+	for _, entry := range entries {
+		if known[entry.Filename] {
+			continue
+		}
+
+		if entry.Size > 0 {
+			entry.Fingerprint, err = app.entryFingerprint(library, entry)
+			if err != nil {
+				log.Printf("Failed to fingerprint '%v': %v", entry.Filename, err)
+				continue
+			}
+		}
+
+		vanished := app.vanishedFiles(library, stored, entry.Size)
+		if match := findRenamed(vanished, entry); match >= 0 {
+			changes.Renamed = append(changes.Renamed, FileRename{Id: vanished[match].Id, Filename: entry.Filename})
+			continue
+		}
+
+		imported = append(imported, entry)
+	}
+
+	if err = app.Repo.ApplyScan(changes); err != nil {
+		return err
+	}
+
+	result, err := app.Repo.ImportFsEntries(library.Id, imported)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range slices.Concat(result.Added, result.Truncated) {
+		log.Printf("Imported '%v' into library \"%v\"", entry.Filename, library.Name)
+	}
+	app.publishImports(result)
+
+	return app.probeLibraryMedia(context.Background(), library)
+}
+
+// vanishedFiles returns the stored files with the given size that are no longer
+// in the library folder.
+func (app App) vanishedFiles(library Library, stored []StoredFile, size int64) []StoredFile {
+	var vanished []StoredFile
+
+	for _, file := range stored {
+		if !file.Size.Valid || file.Size.Int64 != size {
+			continue
+		}
+
+		if file.Missing {
+			vanished = append(vanished, file)
+			continue
+		}
+
+		path, err := resolveInFolder(library.VideoFolder, file.Filename)
+		if err != nil {
+			continue
+		}
+
+		if _, err = os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			vanished = append(vanished, file)
+		}
+	}
+
+	return vanished
+}
+
+// libraryOfPath finds the library whose folder holds the path, along with the
+// slash separated path relative to it.
+func (app App) libraryOfPath(path string) (Library, string, bool) {
+	for _, library := range app.Repo.Libraries() {
+		relative, err := filepath.Rel(library.VideoFolder, path)
+		if err != nil || !filepath.IsLocal(relative) {
+			continue
+		}
+
+		return library, filepath.ToSlash(relative), true
+	}
+
+	return Library{}, "", false
+}
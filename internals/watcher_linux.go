@@ -0,0 +1,95 @@
+//go:build linux
+
+package internals
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const watchMask = syscall.IN_CREATE |
+	syscall.IN_MODIFY |
+	syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_TO |
+	syscall.IN_ONLYDIR
+
+// folderWatcher watches folders with inotify, subfolders have to be added one
+// by one.
+type folderWatcher struct {
+	fd   int
+	dirs map[int32]string
+	mu   *sync.Mutex
+}
+
+func newFolderWatcher() (*folderWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	return &folderWatcher{fd: fd, dirs: map[int32]string{}, mu: &sync.Mutex{}}, nil
+}
+
+func (watcher *folderWatcher) Add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(watcher.fd, dir, watchMask)
+	if err != nil {
+		return err
+	}
+
+	watcher.mu.Lock()
+	watcher.dirs[int32(wd)] = dir
+	watcher.mu.Unlock()
+
+	return nil
+}
+
+// Run reads the inotify events until reading fails, sending them to the
+// channel.
+func (watcher *folderWatcher) Run(events chan<- watchEvent) error {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+	for {
+		n, err := syscall.Read(watcher.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+nameLen]), "\x00")
+			offset = nameStart + nameLen
+
+			if mask&syscall.IN_Q_OVERFLOW != 0 {
+				events <- watchEvent{Overflow: true}
+				continue
+			}
+
+			watcher.mu.Lock()
+			dir, found := watcher.dirs[wd]
+			if mask&syscall.IN_IGNORED != 0 {
+				delete(watcher.dirs, wd)
+			}
+			watcher.mu.Unlock()
+
+			if !found || name == "" {
+				continue
+			}
+
+			events <- watchEvent{
+				Path:  filepath.Join(dir, name),
+				IsDir: mask&syscall.IN_ISDIR != 0,
+			}
+		}
+	}
+}
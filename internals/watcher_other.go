@@ -0,0 +1,19 @@
+//go:build !linux
+
+package internals
+
+// folderWatcher is only implemented with inotify, other systems rely on the
+// periodic rescan.
+type folderWatcher struct{}
+
+func newFolderWatcher() (*folderWatcher, error) {
+	return nil, ErrWatcherUnsupported
+}
+
+func (watcher *folderWatcher) Add(dir string) error {
+	return ErrWatcherUnsupported
+}
+
+func (watcher *folderWatcher) Run(events chan<- watchEvent) error {
+	return ErrWatcherUnsupported
+}
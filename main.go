@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	inter "go-video-viewer/internals"
+	"go-video-viewer/internals/remux"
 	"io"
 	"log"
 	"net/http"
@@ -12,6 +15,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -62,8 +66,16 @@ func handleApiGetStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	checksums, err := app.Repo.QueryChecksumStats()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("QueryChecksumStats() failed", err)
+		return
+	}
+
 	response := inter.VideoStatsResponse{
-		Stats: stats,
+		Stats:     stats,
+		Checksums: checksums,
 	}
 
 	if err = json.NewEncoder(w).Encode(response); err != nil {
@@ -76,7 +88,29 @@ func handleApiGetStats(w http.ResponseWriter, r *http.Request) {
 func handleApiGetNextVideo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
-	videos, err := app.Repo.NextInQueue(2)
+	writeNextVideo(w, r, 0)
+}
+
+func handleApiGetLibraryNextVideo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	library, ok := libraryFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	writeNextVideo(w, r, library.Id)
+}
+
+func writeNextVideo(w http.ResponseWriter, r *http.Request, libraryId int32) {
+	order, err := inter.ParseQueueOrder(r.URL.Query(), app.Config.QueueOrder)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid queue order:", err)
+		return
+	}
+
+	videos, err := app.Repo.NextInQueue(libraryId, 2, order)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Println("NextInQueue() failed", err)
@@ -88,9 +122,17 @@ func handleApiGetNextVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	progress, err := app.Repo.FindProgress(videos[0].Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("FindProgress '%v' failed: %v", videos[0].Id, err)
+		return
+	}
+
 	response := inter.VideoResponse{
-		Video: videos[0],
-		Next:  nil,
+		Video:    videos[0],
+		Progress: progress,
+		Next:     nil,
 	}
 
 	if len(videos) > 1 {
@@ -107,15 +149,41 @@ func handleApiGetNextVideo(w http.ResponseWriter, r *http.Request) {
 func handleApiListVideos(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
-	videos, err := app.Repo.ListAllSaved()
+	writeSavedVideos(w, r, 0)
+}
+
+func handleApiListLibraryVideos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	library, ok := libraryFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	writeSavedVideos(w, r, library.Id)
+}
+
+func writeSavedVideos(w http.ResponseWriter, r *http.Request, libraryId int32) {
+	query, err := inter.ParseVideoListQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid list query:", err)
+		return
+	}
+	query.LibraryId = libraryId
+
+	videos, total, err := app.Repo.ListSaved(query)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("NextInQueue() failed", err)
+		log.Println("ListSaved() failed", err)
 		return
 	}
 
 	reponse := inter.VideoListResponse{
-		Videos: videos,
+		Videos:   videos,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
 	}
 
 	if err = json.NewEncoder(w).Encode(reponse); err != nil {
@@ -125,6 +193,89 @@ func handleApiListVideos(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func handleApiListVideosByStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	statuses, err := inter.ParseStatusFilter(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid status filter:", err)
+		return
+	}
+
+	query, err := inter.ParseVideoListQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid list query:", err)
+		return
+	}
+
+	videos, total, err := app.Repo.ListByStatus(statuses, query)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("ListByStatus() failed", err)
+		return
+	}
+
+	response := inter.VideoListResponse{
+		Videos:   videos,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	}
+
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Failed to encode json", err)
+		return
+	}
+}
+
+func handleApiSearchVideos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	if text == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("missing search text")
+		return
+	}
+
+	statuses, err := inter.ParseStatusFilter(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid status filter:", err)
+		return
+	}
+
+	query, err := inter.ParseVideoListQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid list query:", err)
+		return
+	}
+
+	videos, total, err := app.Repo.SearchVideos(text, statuses, query)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("SearchVideos() failed", err)
+		return
+	}
+
+	response := inter.VideoListResponse{
+		Videos:   videos,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	}
+
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Failed to encode json", err)
+		return
+	}
+}
+
 func handleApiGetVideo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
@@ -147,9 +298,17 @@ func handleApiGetVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	progress, err := app.Repo.FindProgress(int32(id))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("FindProgress '%v' failed: %v", id, err)
+		return
+	}
+
 	response := inter.VideoResponse{
-		Video: *video,
-		Next:  nil,
+		Video:    *video,
+		Progress: progress,
+		Next:     nil,
 	}
 
 	video, err = app.Repo.NextSavedById(int32(id))
@@ -161,6 +320,13 @@ func handleApiGetVideo(w http.ResponseWriter, r *http.Request) {
 
 	response.Next = video
 
+	// the video is still useful without its media, e.g. while the file is missing
+	response.Media, err = app.VideoMedia(response.Video)
+	if err != nil {
+		log.Printf("VideoMedia '%v' failed: %v", id, err)
+	}
+	response.Playback = app.VideoPlayback(response.Video, response.Media)
+
 	if err = json.NewEncoder(w).Encode(response); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Println("Failed to encode json", err)
@@ -188,21 +354,66 @@ func handleApiServeVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.ServeFile(w, r, app.VideoPath(*video))
+	videoPath, err := app.VideoPath(*video)
+	if err != nil {
+		switch {
+		case errors.Is(err, inter.ErrPathOutsideFolder):
+			w.WriteHeader(http.StatusForbidden)
+		case errors.Is(err, inter.ErrLibraryNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		log.Printf("VideoPath '%v' failed: %v", id, err)
+		return
+	}
+
+	http.ServeFile(w, r, videoPath)
 }
 
-func handleApiScanVideos(w http.ResponseWriter, r *http.Request) {
-	err := app.UpdateRepoFromFolder()
+// handleApiVideoThumbnail serves the JPEG thumbnail of the video, browsers can
+// revalidate it with its modification time.
+func handleApiVideoThumbnail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	video, err := app.Repo.FindById(int32(id))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("UpdateRepoFromFolder() failed:", err)
+		log.Printf("FindById '%v' failed: %v", id, err)
+		return
+	}
+
+	if video == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	data, modified, err := app.VideoThumbnail(r.Context(), *video)
+	if err != nil {
+		switch {
+		case errors.Is(err, inter.ErrPathOutsideFolder):
+			w.WriteHeader(http.StatusForbidden)
+		case errors.Is(err, inter.ErrLibraryNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		log.Printf("VideoThumbnail '%v' failed: %v", id, err)
 		return
 	}
 
-	handleApiGetLastUpdate(w, r)
+	w.Header().Add("Content-Type", "image/jpeg")
+	http.ServeContent(w, r, "thumbnail.jpg", modified, bytes.NewReader(data))
 }
 
-func handleApiUpdateVideo(w http.ResponseWriter, r *http.Request) {
+// handleApiTranscodePlaylist serves the HLS playlist of a video that browsers
+// can't play as it is.
+func handleApiTranscodePlaylist(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -210,22 +421,46 @@ func handleApiUpdateVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1048576))
+	video, err := app.Repo.FindById(int32(id))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("failed to read request body")
+		log.Printf("FindById '%v' failed: %v", id, err)
 		return
 	}
 
-	var payload inter.VideoUpdatePayload
-	if err = json.Unmarshal(body, &payload); err != nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		log.Println("invalid request body")
+	if video == nil {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	payload.Tags = filterEmptyStrings(payload.Tags)
-	log.Print(payload)
+	playlist, err := app.TranscodePlaylist(r.Context(), *video)
+	if err != nil {
+		if writeTranscodeError(w, err) == http.StatusInternalServerError {
+			log.Printf("TranscodePlaylist '%v' failed: %v", id, err)
+		}
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Add("Cache-Control", "no-cache")
+	w.Write(playlist)
+}
+
+// handleApiTranscodeSegment serves a segment of the HLS playlist once ffmpeg
+// wrote it.
+func handleApiTranscodeSegment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	var index int
+	if _, err = fmt.Sscanf(r.PathValue("segment"), "segment%d.ts", &index); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 
 	video, err := app.Repo.FindById(int32(id))
 	if err != nil {
@@ -239,53 +474,940 @@ func handleApiUpdateVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	video.Nickname = payload.Nickname
-	video.Status = payload.Status
-	video.Tags = payload.Tags
+	segmentPath, err := app.TranscodeSegment(r.Context(), *video, index)
+	if err != nil {
+		// the player gave up on the segment, e.g. after seeking
+		if r.Context().Err() != nil {
+			return
+		}
 
-	err = app.UpdateVideo(*video)
+		if writeTranscodeError(w, err) == http.StatusInternalServerError {
+			log.Printf("TranscodeSegment '%v' %v failed: %v", id, index, err)
+		}
+		return
+	}
+
+	w.Header().Add("Content-Type", "video/mp2t")
+	http.ServeFile(w, r, segmentPath)
+}
+
+// writeTranscodeError writes the status of a transcoding error and returns it.
+func writeTranscodeError(w http.ResponseWriter, err error) int {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, inter.ErrTranscodeDisabled), errors.Is(err, inter.ErrSegmentNotFound), errors.Is(err, os.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, inter.ErrPathOutsideFolder):
+		status = http.StatusForbidden
+	case errors.Is(err, inter.ErrTooManyTranscodes):
+		// another video may stop transcoding by then
+		w.Header().Add("Retry-After", "10")
+		status = http.StatusServiceUnavailable
+	}
+
+	w.WriteHeader(status)
+	return status
+}
+
+// handleApiStreamVideo serves a Matroska video remuxed to MP4, the ranges of
+// the requests seek in it.
+func handleApiStreamVideo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	video, err := app.Repo.FindById(int32(id))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("UpdateVideo failed:", err)
+		log.Printf("FindById '%v' failed: %v", id, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
-}
+	if video == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 
-func publicFolder() (string, error) {
-	exec, err := os.Executable()
+	stream, modified, err := app.VideoStream(*video)
 	if err != nil {
-		return "", err
+		switch {
+		case errors.Is(err, inter.ErrStreamPending):
+			w.Header().Add("Retry-After", "5")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case errors.Is(err, remux.ErrUnsupported):
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		case errors.Is(err, inter.ErrPathOutsideFolder):
+			w.WriteHeader(http.StatusForbidden)
+		case errors.Is(err, inter.ErrLibraryNotFound), errors.Is(err, os.ErrNotExist):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("VideoStream '%v' failed: %v", id, err)
+		}
+		return
 	}
+	defer stream.Close()
 
-	execDir := filepath.Dir(exec)
-	return filepath.Join(execDir, "..", "public"), nil
+	w.Header().Add("Content-Type", "video/mp4")
+	http.ServeContent(w, r, "stream.mp4", modified, stream)
 }
 
-func handleServeFile(relativePath string) http.HandlerFunc {
-	folder, _ := publicFolder()
-	filepath := path.Join(folder, relativePath)
-	return func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath)
+func handleApiScanVideos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	job, _ := app.StartScan(nil)
+	writeJobAccepted(w, job)
+}
+
+func handleApiScanLibraryVideos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	library, ok := libraryFromRequest(w, r)
+	if !ok {
+		return
 	}
+
+	job, _ := app.StartScan(library)
+	writeJobAccepted(w, job)
 }
 
-func main() {
-	_, err := publicFolder()
+func handleApiVerifyVideos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	job, _ := app.StartVerification()
+	writeJobAccepted(w, job)
+}
+
+// writeJobAccepted answers with the job that runs the request, which may be a
+// job that was already running.
+func writeJobAccepted(w http.ResponseWriter, job inter.Job) {
+	w.Header().Add("Location", fmt.Sprintf("/api/jobs/%v", job.Id))
+	w.WriteHeader(http.StatusAccepted)
+
+	if err := json.NewEncoder(w).Encode(inter.JobResponse{Job: job}); err != nil {
+		log.Println("Failed to encode json", err)
+		return
+	}
+}
+
+func handleApiGetJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil {
-		log.Fatalln("Could not resolve index file path", err)
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
 		return
 	}
 
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	log.Println("Booting up!")
+	job, err := app.Jobs.Find(id)
+	if errors.Is(err, inter.ErrJobNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 
-	app = inter.NewApp()
-	defer app.Close()
+	if err = json.NewEncoder(w).Encode(inter.JobResponse{Job: *job}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Failed to encode json", err)
+		return
+	}
+}
 
-	app.Init()
-	log.Println("Application initialized")
+func handleApiCancelJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	job, err := app.Jobs.Cancel(id)
+	if errors.Is(err, inter.ErrJobNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if errors.Is(err, inter.ErrJobFinished) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	if err = json.NewEncoder(w).Encode(inter.JobResponse{Job: *job}); err != nil {
+		log.Println("Failed to encode json", err)
+		return
+	}
+}
+
+// handleApiEvents streams the application events as Server-Sent Events until
+// the client goes away.
+func handleApiEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("streaming is not supported by the response writer")
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/event-stream")
+	w.Header().Add("Cache-Control", "no-cache")
+	w.Header().Add("Connection", "keep-alive")
+
+	events, unsubscribe := app.Events.Subscribe()
+	defer unsubscribe()
+
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Println("Failed to encode json", err)
+				continue
+			}
+
+			fmt.Fprintf(w, "id: %v\nevent: %v\ndata: %s\n\n", event.Id, event.Kind, data)
+		}
+
+		flusher.Flush()
+	}
+}
+
+func handleApiListScans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	query, err := inter.ParseVideoListQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid list query:", err)
+		return
+	}
+
+	runs, total, err := app.Repo.ListScanRuns(query.Page, query.PageSize)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("ListScanRuns() failed", err)
+		return
+	}
+
+	response := inter.ScanListResponse{
+		Runs:     runs,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	}
+
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Failed to encode json", err)
+		return
+	}
+}
+
+func handleApiGetScan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	run, err := app.Repo.FindScanRun(id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("FindScanRun '%v' failed: %v", id, err)
+		return
+	}
+
+	if run == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(run); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Failed to encode json", err)
+		return
+	}
+}
+
+func handleApiListLibraries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	response := inter.LibraryListResponse{
+		Libraries: app.Repo.Libraries(),
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Failed to encode json", err)
+		return
+	}
+}
+
+// libraryFromRequest finds the library named in the path, writing the error
+// response when there is none.
+func libraryFromRequest(w http.ResponseWriter, r *http.Request) (*inter.Library, bool) {
+	library, err := app.Repo.FindLibraryByName(r.PathValue("library"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		log.Println("unknown library:", r.PathValue("library"))
+		return nil, false
+	}
+
+	return library, true
+}
+
+func handleApiUpdateVideo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("failed to read request body")
+		return
+	}
+
+	var payload inter.VideoUpdatePayload
+	if err = json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		log.Println("invalid request body")
+		return
+	}
+
+	payload.Tags = filterEmptyStrings(payload.Tags)
+	log.Print(payload)
+
+	video, err := app.Repo.FindById(int32(id))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("FindById '%v' failed: %v", id, err)
+		return
+	}
+
+	if video == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	video.Nickname = payload.Nickname
+	video.Status = payload.Status
+	video.Tags = payload.Tags
+
+	err = app.UpdateVideo(*video)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("UpdateVideo failed:", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleApiSaveProgress(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("failed to read request body")
+		return
+	}
+
+	var payload inter.VideoProgressPayload
+	if err = json.Unmarshal(body, &payload); err != nil || !payload.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		log.Println("invalid request body")
+		return
+	}
+
+	video, err := app.Repo.FindById(int32(id))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("FindById '%v' failed: %v", id, err)
+		return
+	}
+
+	if video == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = app.Repo.SaveProgress(video.Id, payload.Position, payload.Duration)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("SaveProgress failed:", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleApiUndoVideo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	video, err := app.UndoVideo(int32(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, inter.ErrVideoNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, inter.ErrNothingToUndo), errors.Is(err, inter.ErrUndoExpired):
+			w.WriteHeader(http.StatusConflict)
+			log.Printf("cannot undo video '%v': %v", id, err)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Println("UndoVideo failed:", err)
+		}
+		return
+	}
+
+	response := inter.VideoResponse{
+		Video: *video,
+		Next:  nil,
+	}
+
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Failed to encode json", err)
+		return
+	}
+}
+
+func handleApiVideoHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	video, err := app.Repo.FindById(int32(id))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("FindById '%v' failed: %v", id, err)
+		return
+	}
+
+	if video == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	writeHistory(w, r, video.Id)
+}
+
+func handleApiHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	writeHistory(w, r, 0)
+}
+
+func writeHistory(w http.ResponseWriter, r *http.Request, videoId int32) {
+	query, err := inter.ParseHistoryQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid history query:", err)
+		return
+	}
+
+	events, total, err := app.Repo.ListHistory(videoId, query)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("ListHistory() failed", err)
+		return
+	}
+
+	response := inter.VideoHistoryResponse{
+		Events:   events,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	}
+
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Failed to encode json", err)
+		return
+	}
+}
+
+func handleApiListTags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	tags, err := app.Repo.ListTags()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("ListTags() failed", err)
+		return
+	}
+
+	response := inter.TagListResponse{
+		Tags: tags,
+	}
+
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Failed to encode json", err)
+		return
+	}
+}
+
+func handleApiRenameTag(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("failed to read request body")
+		return
+	}
+
+	var payload inter.TagRenamePayload
+	if err = json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		log.Println("invalid request body")
+		return
+	}
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		log.Println("tag name cannot be empty")
+		return
+	}
+
+	err = app.Repo.RenameTag(int32(id), payload.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, inter.ErrTagNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, inter.ErrTagNameTaken):
+			w.WriteHeader(http.StatusConflict)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Println("RenameTag failed:", err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleApiMergeTags(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("failed to read request body")
+		return
+	}
+
+	var payload inter.TagMergePayload
+	if err = json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		log.Println("invalid request body")
+		return
+	}
+
+	err = app.Repo.MergeTags(int32(id), payload.Into)
+	if err != nil {
+		if errors.Is(err, inter.ErrTagNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Println("MergeTags failed:", err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleApiListSeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	series, err := app.Repo.ListSeries()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("ListSeries() failed", err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(inter.SeriesListResponse{Series: series}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Failed to encode json", err)
+		return
+	}
+}
+
+func handleApiGetSeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	series, err := app.Repo.FindSeries(int32(id))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("FindSeries '%v' failed: %v", id, err)
+		return
+	}
+
+	if series == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	episodes, err := app.Repo.ListSeriesEpisodes(series.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("ListSeriesEpisodes '%v' failed: %v", id, err)
+		return
+	}
+
+	response := inter.SeriesResponse{
+		Series:   *series,
+		Episodes: episodes,
+	}
+
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Failed to encode json", err)
+		return
+	}
+}
+
+func handleApiAssignSeries(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("failed to read request body")
+		return
+	}
+
+	var payload inter.SeriesAssignPayload
+	if err = json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		log.Println("invalid request body")
+		return
+	}
+
+	err = app.Repo.AssignSeries(int32(id), payload)
+	if err != nil {
+		switch {
+		case errors.Is(err, inter.ErrVideoNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, inter.ErrSeriesNotFound), errors.Is(err, inter.ErrSeriesWrongLibrary):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			log.Println("AssignSeries failed:", err)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Println("AssignSeries failed:", err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleApiGetVideoChapters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	video, err := app.Repo.FindById(int32(id))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("FindById '%v' failed: %v", id, err)
+		return
+	}
+
+	if video == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	response, err := app.VideoChapters(*video)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("VideoChapters '%v' failed: %v", id, err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Failed to encode json", err)
+	}
+}
+
+func handleApiListSubtitles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	video, err := app.Repo.FindById(int32(id))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("FindById '%v' failed: %v", id, err)
+		return
+	}
+
+	if video == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	tracks, err := app.VideoSubtitles(*video)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("VideoSubtitles '%v' failed: %v", id, err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(inter.SubtitleListResponse{Subtitles: tracks}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Failed to encode json", err)
+	}
+}
+
+// handleApiServeSubtitle serves a subtitle converted to WebVTT, the file in
+// the path is the name of the subtitle in its url, like "0.vtt" or "track3.vtt".
+func handleApiServeSubtitle(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("invalid id:", r.PathValue("id"))
+		return
+	}
+
+	name, found := strings.CutSuffix(r.PathValue("file"), ".vtt")
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	video, err := app.Repo.FindById(int32(id))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("FindById '%v' failed: %v", id, err)
+		return
+	}
+
+	if video == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	vtt, err := app.SubtitleVTT(r.Context(), *video, name)
+	if err != nil {
+		// the player closed the subtitle before it was extracted
+		if r.Context().Err() != nil {
+			return
+		}
+
+		switch {
+		case errors.Is(err, inter.ErrSubtitleNotFound), errors.Is(err, os.ErrNotExist):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, inter.ErrPathOutsideFolder):
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("SubtitleVTT '%v' failed: %v", id, err)
+		}
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/vtt; charset=utf-8")
+	w.Write(vtt)
+}
+
+// handleApiSaveSkipMarker saves the marker of the kind in the path on a video
+// or on a series.
+func handleApiSaveSkipMarker(owner inter.MarkerSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			log.Println("invalid id:", r.PathValue("id"))
+			return
+		}
+
+		kind, ok := inter.ParseSkipKind(r.PathValue("kind"))
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			log.Println("invalid marker kind:", r.PathValue("kind"))
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1048576))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Println("failed to read request body")
+			return
+		}
+
+		var payload inter.SkipMarkerPayload
+		if err = json.Unmarshal(body, &payload); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			log.Println("invalid request body")
+			return
+		}
+
+		err = app.Repo.SaveSkipMarker(owner, int32(id), kind, payload)
+		writeSkipMarkerResult(w, err)
+	}
+}
+
+func handleApiDeleteSkipMarker(owner inter.MarkerSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			log.Println("invalid id:", r.PathValue("id"))
+			return
+		}
+
+		kind, ok := inter.ParseSkipKind(r.PathValue("kind"))
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			log.Println("invalid marker kind:", r.PathValue("kind"))
+			return
+		}
+
+		err = app.Repo.DeleteSkipMarker(owner, int32(id), kind)
+		writeSkipMarkerResult(w, err)
+	}
+}
+
+func writeSkipMarkerResult(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, inter.ErrVideoNotFound), errors.Is(err, inter.ErrSeriesNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, inter.ErrInvalidMarker):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		log.Println("invalid marker:", err)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("skip marker update failed:", err)
+	}
+}
+
+func publicFolder() (string, error) {
+	exec, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	execDir := filepath.Dir(exec)
+	return filepath.Join(execDir, "..", "public"), nil
+}
+
+func handleServeFile(relativePath string) http.HandlerFunc {
+	folder, _ := publicFolder()
+	filepath := path.Join(folder, relativePath)
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath)
+	}
+}
+
+func main() {
+	_, err := publicFolder()
+	if err != nil {
+		log.Fatalln("Could not resolve index file path", err)
+		return
+	}
+
+	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	log.Println("Booting up!")
+
+	app = inter.NewApp()
+	defer app.Close()
+
+	app.Init()
+	log.Println("Application initialized")
+
+	go app.RunDisposals(time.Minute)
+
+	if app.Config.Watch {
+		go app.RunWatcher()
+	}
+
+	if app.Config.RescanInterval > 0 {
+		go app.RunRescans(app.Config.RescanInterval)
+	}
+
+	if app.Config.FFmpeg != "" {
+		go app.RunTranscodeCleanup(time.Minute)
+	}
 
 	http.HandleFunc("GET /", handleServeFile("index.html"))
 	http.HandleFunc("GET /index.js", handleServeFile("index.js"))
@@ -294,9 +1416,42 @@ func main() {
 	http.HandleFunc("GET /api/video/next", handleApiGetNextVideo)
 	http.HandleFunc("GET /api/video/{id}", handleApiGetVideo)
 	http.HandleFunc("GET /api/video/{id}/serve", handleApiServeVideo)
+	http.HandleFunc("GET /api/video/{id}/stream.mp4", handleApiStreamVideo)
+	http.HandleFunc("GET /api/video/{id}/thumbnail", handleApiVideoThumbnail)
+	http.HandleFunc("GET /api/video/{id}/hls/index.m3u8", handleApiTranscodePlaylist)
+	http.HandleFunc("GET /api/video/{id}/hls/{segment}", handleApiTranscodeSegment)
 	http.HandleFunc("GET /api/video/list", handleApiListVideos)
+	http.HandleFunc("GET /api/videos", handleApiListVideosByStatus)
+	http.HandleFunc("GET /api/search", handleApiSearchVideos)
 	http.HandleFunc("POST /api/video/scan", handleApiScanVideos)
+	http.HandleFunc("GET /api/jobs/{id}", handleApiGetJob)
+	http.HandleFunc("DELETE /api/jobs/{id}", handleApiCancelJob)
+	http.HandleFunc("GET /api/events", handleApiEvents)
+	http.HandleFunc("POST /api/video/verify", handleApiVerifyVideos)
+	http.HandleFunc("GET /api/scans", handleApiListScans)
+	http.HandleFunc("GET /api/scans/{id}", handleApiGetScan)
+	http.HandleFunc("GET /api/libraries", handleApiListLibraries)
+	http.HandleFunc("GET /api/library/{library}/video/next", handleApiGetLibraryNextVideo)
+	http.HandleFunc("GET /api/library/{library}/video/list", handleApiListLibraryVideos)
+	http.HandleFunc("POST /api/library/{library}/video/scan", handleApiScanLibraryVideos)
 	http.HandleFunc("POST /api/video/{id}", handleApiUpdateVideo)
+	http.HandleFunc("PUT /api/video/{id}/progress", handleApiSaveProgress)
+	http.HandleFunc("POST /api/video/{id}/undo", handleApiUndoVideo)
+	http.HandleFunc("GET /api/video/{id}/history", handleApiVideoHistory)
+	http.HandleFunc("GET /api/history", handleApiHistory)
+	http.HandleFunc("PUT /api/video/{id}/series", handleApiAssignSeries)
+	http.HandleFunc("GET /api/video/{id}/chapters", handleApiGetVideoChapters)
+	http.HandleFunc("GET /api/video/{id}/subtitles", handleApiListSubtitles)
+	http.HandleFunc("GET /api/video/{id}/subtitles/{file}", handleApiServeSubtitle)
+	http.HandleFunc("PUT /api/video/{id}/markers/{kind}", handleApiSaveSkipMarker(inter.MarkerFromVideo))
+	http.HandleFunc("DELETE /api/video/{id}/markers/{kind}", handleApiDeleteSkipMarker(inter.MarkerFromVideo))
+	http.HandleFunc("GET /api/series", handleApiListSeries)
+	http.HandleFunc("GET /api/series/{id}", handleApiGetSeries)
+	http.HandleFunc("PUT /api/series/{id}/markers/{kind}", handleApiSaveSkipMarker(inter.MarkerFromSeries))
+	http.HandleFunc("DELETE /api/series/{id}/markers/{kind}", handleApiDeleteSkipMarker(inter.MarkerFromSeries))
+	http.HandleFunc("GET /api/tags", handleApiListTags)
+	http.HandleFunc("POST /api/tags/{id}", handleApiRenameTag)
+	http.HandleFunc("POST /api/tags/{id}/merge", handleApiMergeTags)
 
 	log.Printf("Listening on %v:%v\n", app.Config.Address, app.Config.Port)
 	err = http.ListenAndServe(